@@ -0,0 +1,84 @@
+// Package availability implements the availability metric of device inputs.
+//
+// Inputs polling devices report one point per device and collection with an
+// "up" field, 1 if the device was polled successfully and 0 otherwise, in
+// their own measurement and with the tags identifying the device. Failed
+// polls add a "reason" tag classifying the error, so availability can be
+// graphed the same way for every input without parsing error messages.
+package availability
+
+import (
+	"net"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+)
+
+// Values of the reason tag.
+const (
+	// ReasonTimeout is used for connections and requests that timed out,
+	// including those aborted by a gather deadline.
+	ReasonTimeout = "timeout"
+	// ReasonRefused is used when nothing listens on the device address.
+	ReasonRefused = "connection_refused"
+	// ReasonDNS is used when the device name could not be resolved.
+	ReasonDNS = "dns"
+	// ReasonUnauthorized is used for rejected credentials.
+	ReasonUnauthorized = "unauthorized"
+	// ReasonHTTPStatus is used for other unsuccessful HTTP responses.
+	ReasonHTTPStatus = "http_status"
+	// ReasonSkipped is used for polls skipped by a devicehttp.Breaker.
+	ReasonSkipped = "skipped"
+	// ReasonError is used for all other errors, such as invalid responses.
+	ReasonError = "error"
+)
+
+// Reason classifies the error of a failed poll.
+func Reason(err error) string {
+	switch e := err.(type) {
+	case *devicehttp.BreakerOpenError:
+		return ReasonSkipped
+	case *devicehttp.StatusError:
+		if e.StatusCode == 401 || e.StatusCode == 403 {
+			return ReasonUnauthorized
+		}
+		return ReasonHTTPStatus
+	case *net.DNSError:
+		return ReasonDNS
+	case net.Error:
+		if e.Timeout() {
+			return ReasonTimeout
+		}
+	}
+	if err == devicehttp.ErrDeadlineExceeded {
+		return ReasonTimeout
+	}
+
+	// transport errors usually reach the inputs wrapped into a message
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "Timeout"):
+		return ReasonTimeout
+	case strings.Contains(msg, "connection refused"):
+		return ReasonRefused
+	case strings.Contains(msg, "no such host"):
+		return ReasonDNS
+	}
+	return ReasonError
+}
+
+// Add reports the availability of a device: up is 1 if err is nil, and 0
+// with the reason tag otherwise. The tags are not modified.
+func Add(acc telegraf.Accumulator, measurement string, tags map[string]string, err error) {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	up := 1
+	if err != nil {
+		up = 0
+		t["reason"] = Reason(err)
+	}
+	acc.AddFields(measurement, map[string]interface{}{"up": up}, t)
+}
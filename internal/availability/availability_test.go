@@ -0,0 +1,62 @@
+package availability
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o deadline reached" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestReason(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	_, refused := net.DialTimeout("tcp", addr, time.Second)
+	require.Error(t, refused)
+
+	for _, tt := range []struct {
+		err    error
+		reason string
+	}{
+		{timeoutError{}, ReasonTimeout},
+		{devicehttp.ErrDeadlineExceeded, ReasonTimeout},
+		{fmt.Errorf("error making HTTP request to http://x: net/http: request canceled (Client.Timeout exceeded)"), ReasonTimeout},
+		{refused, ReasonRefused},
+		{fmt.Errorf("error making HTTP request to http://x: %s", refused), ReasonRefused},
+		{&net.DNSError{Err: "no such host", Name: "router"}, ReasonDNS},
+		{&devicehttp.StatusError{StatusCode: 401}, ReasonUnauthorized},
+		{&devicehttp.StatusError{StatusCode: 500}, ReasonHTTPStatus},
+		{&devicehttp.BreakerOpenError{Key: "router"}, ReasonSkipped},
+		{errors.New("unable to parse status"), ReasonError},
+	} {
+		assert.Equal(t, tt.reason, Reason(tt.err), tt.err.Error())
+	}
+}
+
+func TestAdd(t *testing.T) {
+	var acc testutil.Accumulator
+	tags := map[string]string{"server": "router"}
+	Add(&acc, "device", tags, nil)
+	Add(&acc, "device", tags, errors.New("invalid response"))
+
+	acc.AssertContainsTaggedFields(t, "device",
+		map[string]interface{}{"up": 1},
+		map[string]string{"server": "router"})
+	acc.AssertContainsTaggedFields(t, "device",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": "router", "reason": "error"})
+	assert.Len(t, tags, 1)
+}
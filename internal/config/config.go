@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"log"
 	"os"
@@ -16,6 +17,7 @@ import (
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/state"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/outputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
@@ -49,6 +51,9 @@ type Config struct {
 	Agent   *AgentConfig
 	Inputs  []*internal_models.RunningInput
 	Outputs []*internal_models.RunningOutput
+
+	// stateKeys holds the state keys of the stateful inputs added so far
+	stateKeys map[string]bool
 }
 
 func NewConfig() *Config {
@@ -128,6 +133,10 @@ type AgentConfig struct {
 	Quiet        bool
 	Hostname     string
 	OmitHostname bool
+
+	// StateDir is the directory inputs keep their state in across restarts,
+	// e.g. to continue counters. State is only kept in memory if empty.
+	StateDir string
 }
 
 // Inputs returns a list of strings of the configured inputs.
@@ -229,6 +238,9 @@ var header = `# Telegraf Configuration
   hostname = ""
   ## If set to true, do no set the "host" tag in the telegraf agent.
   omit_hostname = false
+  ## Directory for plugins to keep state in across restarts, such as the
+  ## wrap offsets of device counters. State is only kept in memory if empty.
+  # state_dir = "/var/lib/telegraf"
 
 
 ###############################################################################
@@ -568,6 +580,40 @@ func parseFile(fpath string) (*ast.Table, error) {
 	return toml.Parse(contents)
 }
 
+// renamedOptions maps the old names of renamed plugin options to their
+// current names, by plugin. Configurations using the old names keep working
+// with a warning; if both names are set, the current one wins.
+var renamedOptions = map[string]map[string]string{
+	"outputs.kafka": {
+		"certificate": "ssl_cert",
+		"key":         "ssl_key",
+		"ca":          "ssl_ca",
+	},
+}
+
+// renameOptions renames the deprecated options of a plugin table to their
+// current names.
+func renameOptions(plugin string, tbl *ast.Table) {
+	for old, name := range renamedOptions[plugin] {
+		val, ok := tbl.Fields[old]
+		if !ok {
+			continue
+		}
+		delete(tbl.Fields, old)
+		if _, ok := tbl.Fields[name]; ok {
+			log.Printf("WARNING %s: option %s is deprecated and ignored, as %s "+
+				"is set\n", plugin, old, name)
+			continue
+		}
+		log.Printf("WARNING %s: option %s is deprecated, use %s instead\n",
+			plugin, old, name)
+		if kv, ok := val.(*ast.KeyValue); ok {
+			kv.Key = name
+		}
+		tbl.Fields[name] = val
+	}
+}
+
 func (c *Config) addOutput(name string, table *ast.Table) error {
 	if len(c.OutputFilters) > 0 && !sliceContains(name, c.OutputFilters) {
 		return nil
@@ -594,6 +640,8 @@ func (c *Config) addOutput(name string, table *ast.Table) error {
 		return err
 	}
 
+	renameOptions("outputs."+name, table)
+
 	if err := config.UnmarshalTable(table, output); err != nil {
 		return err
 	}
@@ -635,10 +683,26 @@ func (c *Config) addInput(name string, table *ast.Table) error {
 		return err
 	}
 
+	renameOptions("inputs."+name, table)
+
 	if err := config.UnmarshalTable(table, input); err != nil {
 		return err
 	}
 
+	if t, ok := input.(state.StatefulInput); ok && c.Agent.StateDir != "" {
+		key := stateKey(name, pluginConfig.Alias, table)
+		if c.stateKeys[key] {
+			return fmt.Errorf("inputs.%s: another instance keeps its state as %s, "+
+				"set an alias to tell them apart", name, key)
+		}
+		if c.stateKeys == nil {
+			c.stateKeys = make(map[string]bool)
+		}
+		c.stateKeys[key] = true
+		t.SetStatePersister(state.WithPrefix(
+			&state.DirPersister{Dir: c.Agent.StateDir}, key))
+	}
+
 	rp := &internal_models.RunningInput{
 		Name:   name,
 		Input:  input,
@@ -648,6 +712,54 @@ func (c *Config) addInput(name string, table *ast.Table) error {
 	return nil
 }
 
+// stateKeyOptions are the options that tell the instances of an input apart,
+// in the order they are looked for.
+var stateKeyOptions = []string{"servers", "urls", "url", "devices"}
+
+// stateKey returns the prefix of the state of an input: its name followed by
+// the alias of the instance, or else by a hash of the first of
+// stateKeyOptions it sets, so the state stays with the devices polled when
+// instances are added or reordered. Inputs setting none of them use their
+// name.
+func stateKey(name, alias string, tbl *ast.Table) string {
+	if alias != "" {
+		return name + "-" + alias
+	}
+	for _, option := range stateKeyOptions {
+		values := stringValues(tbl.Fields[option])
+		if len(values) == 0 {
+			continue
+		}
+		sort.Strings(values)
+		h := fnv.New32a()
+		h.Write([]byte(strings.Join(values, "\n")))
+		return fmt.Sprintf("%s-%08x", name, h.Sum32())
+	}
+	return name
+}
+
+// stringValues returns the value of a string option, or the strings of an
+// array option.
+func stringValues(node interface{}) []string {
+	kv, ok := node.(*ast.KeyValue)
+	if !ok {
+		return nil
+	}
+	switch v := kv.Value.(type) {
+	case *ast.String:
+		return []string{v.Value}
+	case *ast.Array:
+		var values []string
+		for _, elem := range v.Value {
+			if str, ok := elem.(*ast.String); ok {
+				values = append(values, str.Value)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
 // buildFilter builds a Filter
 // (tagpass/tagdrop/namepass/namedrop/fieldpass/fielddrop) to
 // be inserted into the internal_models.OutputConfig/internal_models.InputConfig
@@ -833,6 +945,14 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 		}
 	}
 
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.Alias = str.Value
+			}
+		}
+	}
+
 	cp.Tags = make(map[string]string)
 	if node, ok := tbl.Fields["tags"]; ok {
 		if subtbl, ok := node.(*ast.Table); ok {
@@ -845,6 +965,7 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 	delete(tbl.Fields, "name_prefix")
 	delete(tbl.Fields, "name_suffix")
 	delete(tbl.Fields, "name_override")
+	delete(tbl.Fields, "alias")
 	delete(tbl.Fields, "interval")
 	delete(tbl.Fields, "tags")
 	var err error
@@ -916,6 +1037,18 @@ func buildParser(name string, tbl *ast.Table) (parsers.Parser, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["jsarray_variables"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						c.JSArrayVariables = append(c.JSArrayVariables, str.Value)
+					}
+				}
+			}
+		}
+	}
+
 	c.MetricName = name
 
 	delete(tbl.Fields, "data_format")
@@ -923,6 +1056,7 @@ func buildParser(name string, tbl *ast.Table) (parsers.Parser, error) {
 	delete(tbl.Fields, "templates")
 	delete(tbl.Fields, "tag_keys")
 	delete(tbl.Fields, "data_type")
+	delete(tbl.Fields, "jsarray_variables")
 
 	return parsers.NewParser(c)
 }
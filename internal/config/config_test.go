@@ -5,12 +5,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/state"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/inputs/exec"
 	"github.com/influxdata/telegraf/plugins/inputs/memcached"
 	"github.com/influxdata/telegraf/plugins/inputs/procstat"
 	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/toml"
+	"github.com/influxdata/toml/ast"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -177,3 +181,75 @@ func TestConfig_LoadDirectory(t *testing.T) {
 	assert.Equal(t, pConfig, c.Inputs[3].Config,
 		"Merged Testdata did not produce correct procstat metadata.")
 }
+
+func TestConfig_RenameOptions(t *testing.T) {
+	tbl, err := toml.Parse([]byte(`
+certificate = "/etc/telegraf/cert.pem"
+ssl_key = "/etc/telegraf/key.pem"
+`))
+	assert.NoError(t, err)
+	renameOptions("outputs.kafka", tbl)
+	assert.Contains(t, tbl.Fields, "ssl_cert")
+	assert.NotContains(t, tbl.Fields, "certificate")
+	assert.Contains(t, tbl.Fields, "ssl_key")
+
+	// the current name wins
+	tbl, err = toml.Parse([]byte(`
+ca = "/etc/telegraf/old-ca.pem"
+ssl_ca = "/etc/telegraf/ca.pem"
+`))
+	assert.NoError(t, err)
+	renameOptions("outputs.kafka", tbl)
+	assert.NotContains(t, tbl.Fields, "ca")
+	kv, ok := tbl.Fields["ssl_ca"].(*ast.KeyValue)
+	assert.True(t, ok)
+	assert.Equal(t, "/etc/telegraf/ca.pem", kv.Value.(*ast.String).Value)
+}
+
+func TestConfig_StateKey(t *testing.T) {
+	key := func(alias, options string) string {
+		tbl, err := toml.Parse([]byte(options))
+		assert.NoError(t, err)
+		return stateKey("ddwrt", alias, tbl)
+	}
+
+	first := key("", `servers = ["http://192.168.1.1", "http://192.168.2.1"]`)
+	assert.Contains(t, first, "ddwrt-")
+	assert.Equal(t, first,
+		key("", `servers = ["http://192.168.2.1", "http://192.168.1.1"]`))
+	assert.NotEqual(t, first, key("", `servers = ["http://192.168.1.1"]`))
+	assert.NotEqual(t, key("", `url = "http://192.168.1.1"`),
+		key("", `url = "http://192.168.2.1"`))
+
+	assert.Equal(t, "ddwrt-office", key("office", `servers = ["http://192.168.1.1"]`))
+	assert.Equal(t, "ddwrt", key("", `interfaces = ["vlan2"]`))
+}
+
+func TestConfig_StateKeyConflict(t *testing.T) {
+	c := NewConfig()
+	c.Agent.StateDir = os.TempDir()
+	add := func(options string) error {
+		tbl, err := toml.Parse([]byte(options))
+		assert.NoError(t, err)
+		return c.addInput("stateful", tbl)
+	}
+
+	inputs.Add("stateful", func() telegraf.Input { return &statefulInput{} })
+	assert.NoError(t, add(`servers = ["http://192.168.1.1"]`))
+	assert.NoError(t, add(`servers = ["http://192.168.2.1"]`))
+	assert.Error(t, add(`servers = ["http://192.168.1.1"]`))
+	assert.NoError(t, add(`
+servers = ["http://192.168.1.1"]
+alias = "backup"
+`))
+	assert.Equal(t, "backup", c.Inputs[2].Config.Alias)
+}
+
+type statefulInput struct {
+	Servers []string
+}
+
+func (s *statefulInput) SampleConfig() string                     { return "" }
+func (s *statefulInput) Description() string                      { return "" }
+func (s *statefulInput) Gather(acc telegraf.Accumulator) error    { return nil }
+func (s *statefulInput) SetStatePersister(p state.StatePersister) {}
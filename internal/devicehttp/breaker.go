@@ -0,0 +1,81 @@
+package devicehttp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BreakerOpenError is returned by Breaker.Allow for devices that are being
+// skipped.
+type BreakerOpenError struct {
+	Key      string
+	Failures int
+	// Remaining is the number of polls still skipped after this one.
+	Remaining int
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("skipping %s after %d failed polls, %d more to skip",
+		e.Key, e.Failures, e.Remaining)
+}
+
+// Breaker is a circuit breaker for polling devices. After Threshold
+// consecutive failed polls of a device, the next Skip polls are skipped
+// without contacting it. The poll after that is tried again: a success
+// closes the breaker, a failure opens it for another Skip polls.
+//
+// Inputs call Allow before polling a device and Record with the result.
+// The zero value never skips a poll.
+type Breaker struct {
+	Threshold int
+	Skip      int
+
+	mu      sync.Mutex
+	devices map[string]*breakerState
+}
+
+type breakerState struct {
+	failures int
+	skip     int
+}
+
+// Allow returns a *BreakerOpenError if the poll of the device identified
+// by key is to be skipped.
+func (b *Breaker) Allow(key string) error {
+	if b.Threshold <= 0 || b.Skip <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.devices[key]
+	if !ok || s.skip == 0 {
+		return nil
+	}
+	s.skip--
+	return &BreakerOpenError{Key: key, Failures: s.failures, Remaining: s.skip}
+}
+
+// Record records the result of a poll of the device identified by key.
+func (b *Breaker) Record(key string, err error) {
+	if b.Threshold <= 0 || b.Skip <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		delete(b.devices, key)
+		return
+	}
+	if b.devices == nil {
+		b.devices = make(map[string]*breakerState)
+	}
+	s, ok := b.devices[key]
+	if !ok {
+		s = &breakerState{}
+		b.devices[key] = s
+	}
+	s.failures++
+	if s.failures >= b.Threshold {
+		s.skip = b.Skip
+	}
+}
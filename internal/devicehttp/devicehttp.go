@@ -0,0 +1,359 @@
+// Package devicehttp implements the HTTP plumbing shared by the inputs that
+// scrape the web interface or API of embedded devices: client construction,
+// authentication, TLS, retries, rate and response size limits, and a circuit
+// breaker for devices that keep failing.
+package devicehttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+)
+
+// Authentication modes understood by the client.
+const (
+	// AuthNone sends no credentials.
+	AuthNone = "none"
+	// AuthBasic sends credentials in a basic Authorization header.
+	AuthBasic = "basic"
+	// AuthCookie sends basic credentials in an "Authorization" cookie, as
+	// done by the web interface of many consumer routers.
+	AuthCookie = "cookie"
+	// AuthDigest answers HTTP digest challenges.
+	AuthDigest = "digest"
+)
+
+const (
+	// DefaultTimeout is used when Config.Timeout is zero.
+	DefaultTimeout = 5 * time.Second
+	// DefaultMaxBodySize is used when Config.MaxBodySize is zero.
+	DefaultMaxBodySize = 10 * 1024 * 1024
+)
+
+// ErrBodyTooLarge is returned when a response exceeds Config.MaxBodySize.
+var ErrBodyTooLarge = errors.New("response body exceeds the maximum size")
+
+// ErrDeadlineExceeded is returned for requests that did not complete before
+// the deadline set with SetDeadline.
+var ErrDeadlineExceeded = errors.New("gather deadline exceeded")
+
+// Config describes how to reach a device.
+type Config struct {
+	Username string
+	Password string
+	// AuthMode is one of AuthNone, AuthBasic, AuthCookie or AuthDigest. If
+	// empty, basic authentication is used when a username is set.
+	AuthMode string
+
+	// Headers are added to every request.
+	Headers map[string]string
+
+	SSLCA              string
+	SSLCert            string
+	SSLKey             string
+	InsecureSkipVerify bool
+
+	// Timeout bounds every attempt of a request, including reading the body.
+	Timeout time.Duration
+	// Retries is the number of extra attempts made after a failed request.
+	Retries int
+	// RetryWait is the pause between attempts.
+	RetryWait time.Duration
+	// MaxBodySize limits the size of the responses read.
+	MaxBodySize int64
+	// MinInterval is the minimum time between the start of two requests,
+	// or attempts, to the same host. Zero sends requests right away.
+	MinInterval time.Duration
+}
+
+// StatusError is returned for responses with a non-2xx status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned HTTP status %s", e.URL, e.Status)
+}
+
+// Client performs requests against a device.
+type Client struct {
+	HTTPClient *http.Client
+
+	config Config
+
+	mu sync.Mutex
+	// digests holds the last digest challenge by host
+	digests  map[string]*digestChallenge
+	deadline time.Time
+	// next holds the earliest start of the next request by host
+	next map[string]time.Time
+}
+
+// NewClient validates the config and builds a Client from it.
+func NewClient(config Config) (*Client, error) {
+	if config.AuthMode == "" {
+		if config.Username != "" {
+			config.AuthMode = AuthBasic
+		} else {
+			config.AuthMode = AuthNone
+		}
+	}
+	switch config.AuthMode {
+	case AuthNone, AuthBasic, AuthCookie, AuthDigest:
+	default:
+		return nil, fmt.Errorf("invalid auth mode %q", config.AuthMode)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+	if config.Retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative")
+	}
+
+	tlsCfg, err := internal.GetTLSConfig(
+		config.SSLCert, config.SSLKey, config.SSLCA, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsCfg,
+			},
+			Timeout: config.Timeout,
+		},
+		config: config,
+	}, nil
+}
+
+// SetDeadline bounds all requests made from now on, including their retries,
+// to complete before t. Inputs set it at the start of Gather so a slow device
+// cannot hold up collection past the gather timeout. A zero t removes the
+// deadline.
+func (c *Client) SetDeadline(t time.Time) {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+}
+
+// Get fetches the given URL and returns the response body.
+func (c *Client) Get(url string) ([]byte, error) {
+	return c.Do("GET", url, nil, nil)
+}
+
+// Do sends a request and returns the response body. The request is retried
+// on transport errors and 5xx responses; other failures are returned right
+// away.
+func (c *Client) Do(
+	method, url string,
+	header http.Header,
+	body []byte,
+) ([]byte, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt <= c.config.Retries; attempt++ {
+		if attempt > 0 && c.config.RetryWait > 0 {
+			if !deadline.IsZero() && time.Now().Add(c.config.RetryWait).After(deadline) {
+				return nil, ErrDeadlineExceeded
+			}
+			time.Sleep(c.config.RetryWait)
+		}
+
+		if err = c.wait(url, deadline); err != nil {
+			return nil, err
+		}
+		var b []byte
+		b, err = c.do(method, url, header, body, deadline)
+		if err == nil {
+			return b, nil
+		}
+		if serr, ok := err.(*StatusError); ok && serr.StatusCode < 500 {
+			return nil, err
+		}
+		if err == ErrBodyTooLarge || err == ErrDeadlineExceeded {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// wait delays a request until Config.MinInterval has passed since the
+// previous request to the same host.
+func (c *Client) wait(rawurl string, deadline time.Time) error {
+	if c.config.MinInterval <= 0 {
+		return nil
+	}
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	start := c.next[u.Host]
+	if start.Before(now) {
+		start = now
+	}
+	if !deadline.IsZero() && start.After(deadline) {
+		c.mu.Unlock()
+		return ErrDeadlineExceeded
+	}
+	if c.next == nil {
+		c.next = make(map[string]time.Time)
+	}
+	c.next[u.Host] = start.Add(c.config.MinInterval)
+	c.mu.Unlock()
+
+	time.Sleep(start.Sub(now))
+	return nil
+}
+
+func (c *Client) do(
+	method, url string,
+	header http.Header,
+	body []byte,
+	deadline time.Time,
+) ([]byte, error) {
+	// the request, including reading the body, is cancelled once the
+	// deadline passes
+	var cancel chan struct{}
+	if !deadline.IsZero() {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, ErrDeadlineExceeded
+		}
+		cancel = make(chan struct{})
+		timer := time.AfterFunc(remaining, func() { close(cancel) })
+		defer timer.Stop()
+	}
+
+	b, err := c.roundTrip(method, url, header, body, cancel)
+	if err != nil && !deadline.IsZero() && !time.Now().Before(deadline) {
+		return nil, ErrDeadlineExceeded
+	}
+	return b, err
+}
+
+func (c *Client) roundTrip(
+	method, url string,
+	header http.Header,
+	body []byte,
+	cancel chan struct{},
+) ([]byte, error) {
+	resp, err := c.send(method, url, header, body, cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized &&
+		c.config.AuthMode == AuthDigest {
+		challenge, cerr := parseDigestChallenge(
+			resp.Header.Get("WWW-Authenticate"))
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if cerr != nil {
+			return nil, fmt.Errorf("%s: %s", url, cerr)
+		}
+		c.mu.Lock()
+		if c.digests == nil {
+			c.digests = make(map[string]*digestChallenge)
+		}
+		c.digests[resp.Request.URL.Host] = challenge
+		c.mu.Unlock()
+
+		resp, err = c.send(method, url, header, body, cancel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, &StatusError{
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.config.MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %s", url, err)
+	}
+	if int64(len(b)) > c.config.MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return b, nil
+}
+
+func (c *Client) send(
+	method, url string,
+	header http.Header,
+	body []byte,
+	cancel chan struct{},
+) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.config.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Cancel = cancel
+	c.authenticate(req)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making HTTP request to %s: %s", url, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) authenticate(req *http.Request) {
+	switch c.config.AuthMode {
+	case AuthBasic:
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	case AuthCookie:
+		token := base64.StdEncoding.EncodeToString(
+			[]byte(c.config.Username + ":" + c.config.Password))
+		req.AddCookie(&http.Cookie{
+			Name:  "Authorization",
+			Value: "Basic%20" + strings.Replace(token, "=", "%3D", -1),
+		})
+	case AuthDigest:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if digest, ok := c.digests[req.URL.Host]; ok {
+			req.Header.Set("Authorization", digest.authorize(
+				c.config.Username, c.config.Password, req.Method, req.URL.RequestURI()))
+		}
+	}
+}
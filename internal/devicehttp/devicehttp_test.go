@@ -0,0 +1,343 @@
+package devicehttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{
+		Username: "admin",
+		Password: "secret",
+		Headers:  map[string]string{"X-Foo": "bar"},
+	})
+	require.NoError(t, err)
+
+	b, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestCookieAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("Authorization")
+		require.NoError(t, err)
+		assert.Equal(t, "Basic%20YWRtaW46YWRtaW4%3D", cookie.Value)
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{
+		Username: "admin",
+		Password: "admin",
+		AuthMode: AuthCookie,
+	})
+	require.NoError(t, err)
+
+	_, err = c.Get(ts.URL)
+	require.NoError(t, err)
+}
+
+func TestDigestAuth(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			w.Header().Set("WWW-Authenticate",
+				`Digest realm="router", nonce="abc123", qop="auth,auth-int", opaque="xyz"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		params := map[string]string{}
+		for _, p := range splitParams(auth[len("Digest "):]) {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+		ha1 := md5hex("admin:router:secret")
+		ha2 := md5hex("GET:/status?x=1")
+		expected := md5hex(ha1 + ":abc123:" + params["nc"] + ":" +
+			params["cnonce"] + ":auth:" + ha2)
+		assert.Equal(t, expected, params["response"])
+		assert.Equal(t, "xyz", params["opaque"])
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{
+		Username: "admin",
+		Password: "secret",
+		AuthMode: AuthDigest,
+	})
+	require.NoError(t, err)
+
+	b, err := c.Get(ts.URL + "/status?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(b))
+	assert.Equal(t, 2, requests)
+
+	// the challenge is reused for subsequent requests
+	_, err = c.Get(ts.URL + "/status?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, requests)
+}
+
+// digestServer requires digest authentication with its own nonce and counts
+// the challenges it sends.
+type digestServer struct {
+	nonce string
+
+	sync.Mutex
+	challenges int
+}
+
+func (d *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Authorization"), `nonce="`+d.nonce+`"`) {
+		d.Lock()
+		d.challenges++
+		d.Unlock()
+		w.Header().Set("WWW-Authenticate",
+			`Digest realm="`+d.nonce+`", nonce="`+d.nonce+`", qop="auth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+func TestDigestAuthHosts(t *testing.T) {
+	servers := []*digestServer{{nonce: "box1"}, {nonce: "box2"}}
+	var urls []string
+	for _, d := range servers {
+		ts := httptest.NewServer(d)
+		defer ts.Close()
+		urls = append(urls, ts.URL)
+	}
+
+	c, err := NewClient(Config{
+		Username: "admin",
+		Password: "secret",
+		AuthMode: AuthDigest,
+	})
+	require.NoError(t, err)
+
+	// every host keeps its own challenge, also when polled concurrently
+	for i := 0; i < 5; i++ {
+		var wg sync.WaitGroup
+		for _, u := range urls {
+			wg.Add(1)
+			go func(u string) {
+				defer wg.Done()
+				_, err := c.Get(u)
+				assert.NoError(t, err)
+			}(u)
+		}
+		wg.Wait()
+	}
+	for _, d := range servers {
+		d.Lock()
+		assert.Equal(t, 1, d.challenges, d.nonce)
+		d.Unlock()
+	}
+}
+
+func TestRetries(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{Retries: 2})
+	require.NoError(t, err)
+
+	_, err = c.Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 3, requests)
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{Retries: 2})
+	require.NoError(t, err)
+
+	_, err = c.Get(ts.URL)
+	require.Error(t, err)
+	serr, ok := err.(*StatusError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, serr.StatusCode)
+	assert.Equal(t, 1, requests)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 100))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{MaxBodySize: 10})
+	require.NoError(t, err)
+
+	_, err = c.Get(ts.URL)
+	assert.Equal(t, ErrBodyTooLarge, err)
+}
+
+func TestInvalidAuthMode(t *testing.T) {
+	_, err := NewClient(Config{AuthMode: "kerberos"})
+	assert.Error(t, err)
+}
+
+func TestDeadline(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	c, err := NewClient(Config{Retries: 3})
+	require.NoError(t, err)
+
+	c.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, err = c.Get(ts.URL)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// requests fail right away once the deadline passed
+	_, err = c.Get(ts.URL)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+}
+
+func TestDeadlineCleared(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{})
+	require.NoError(t, err)
+
+	c.SetDeadline(time.Now().Add(-time.Second))
+	_, err = c.Get(ts.URL)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+
+	c.SetDeadline(time.Time{})
+	b, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(b))
+}
+
+// startRecorder records when the requests passing through it start.
+type startRecorder struct {
+	http.RoundTripper
+
+	sync.Mutex
+	starts []time.Time
+}
+
+func (r *startRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.Lock()
+	r.starts = append(r.starts, time.Now())
+	r.Unlock()
+	return r.RoundTripper.RoundTrip(req)
+}
+
+func TestMinInterval(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c, err := NewClient(Config{MinInterval: 50 * time.Millisecond})
+	require.NoError(t, err)
+	// the spacing applies to the start of requests, which the server cannot
+	// see as only the first request pays for connecting
+	recorder := &startRecorder{RoundTripper: c.HTTPClient.Transport}
+	c.HTTPClient.Transport = recorder
+	for i := 0; i < 3; i++ {
+		_, err = c.Get(ts.URL)
+		require.NoError(t, err)
+	}
+	recorder.Lock()
+	starts := recorder.starts
+	recorder.Unlock()
+	require.Len(t, starts, 3)
+	// some slack for the time between scheduling a request and sending it
+	for i := 1; i < len(starts); i++ {
+		assert.True(t, starts[i].Sub(starts[i-1]) >= 40*time.Millisecond,
+			"request %d started %s after the previous one", i, starts[i].Sub(starts[i-1]))
+	}
+
+	// a request that would start after the deadline is not sent
+	c.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = c.Get(ts.URL)
+	assert.Equal(t, ErrDeadlineExceeded, err)
+	recorder.Lock()
+	assert.Len(t, recorder.starts, 3)
+	recorder.Unlock()
+}
+
+func TestBreaker(t *testing.T) {
+	b := &Breaker{Threshold: 2, Skip: 2}
+	failed := fmt.Errorf("connection refused")
+
+	// one failure is tolerated
+	require.NoError(t, b.Allow("router"))
+	b.Record("router", failed)
+	require.NoError(t, b.Allow("router"))
+	b.Record("router", failed)
+
+	err := b.Allow("router")
+	require.Error(t, err)
+	assert.Equal(t, "skipping router after 2 failed polls, 1 more to skip", err.Error())
+	assert.Error(t, b.Allow("router"))
+	// other devices are not affected
+	assert.NoError(t, b.Allow("switch"))
+
+	// the trial poll fails, so the device is skipped again
+	require.NoError(t, b.Allow("router"))
+	b.Record("router", failed)
+	assert.Error(t, b.Allow("router"))
+	assert.Error(t, b.Allow("router"))
+
+	// a successful poll closes the breaker
+	require.NoError(t, b.Allow("router"))
+	b.Record("router", nil)
+	b.Record("router", failed)
+	assert.NoError(t, b.Allow("router"))
+
+	// the zero value never skips
+	var zero Breaker
+	for i := 0; i < 5; i++ {
+		zero.Record("router", failed)
+	}
+	assert.NoError(t, zero.Allow("router"))
+}
@@ -0,0 +1,106 @@
+package devicehttp
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/telegraf/internal"
+)
+
+// digestChallenge holds the parameters of a WWW-Authenticate digest
+// challenge (RFC 2617). Only the MD5 algorithm is supported, which is the
+// only one embedded devices implement in practice.
+type digestChallenge struct {
+	realm  string
+	nonce  string
+	opaque string
+	qop    string
+	nc     int
+}
+
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return nil, fmt.Errorf("expected a digest challenge, got %q", header)
+	}
+
+	d := &digestChallenge{}
+	for _, param := range splitParams(header[len("digest "):]) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		switch key {
+		case "realm":
+			d.realm = value
+		case "nonce":
+			d.nonce = value
+		case "opaque":
+			d.opaque = value
+		case "qop":
+			// the server may offer several, "auth" is the only one we do
+			for _, q := range strings.Split(value, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					d.qop = "auth"
+				}
+			}
+		case "algorithm":
+			if !strings.EqualFold(value, "MD5") {
+				return nil, fmt.Errorf("unsupported digest algorithm %q", value)
+			}
+		}
+	}
+	if d.nonce == "" {
+		return nil, fmt.Errorf("digest challenge without nonce")
+	}
+	return d, nil
+}
+
+// splitParams splits a comma separated list of parameters, ignoring commas
+// inside quoted values.
+func splitParams(s string) []string {
+	var params []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, s[start:])
+}
+
+func (d *digestChallenge) authorize(username, password, method, uri string) string {
+	ha1 := md5hex(username + ":" + d.realm + ":" + password)
+	ha2 := md5hex(method + ":" + uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		username, d.realm, d.nonce, uri)
+	if d.qop == "" {
+		header += fmt.Sprintf(`, response="%s"`,
+			md5hex(ha1+":"+d.nonce+":"+ha2))
+	} else {
+		d.nc++
+		nc := fmt.Sprintf("%08x", d.nc)
+		cnonce := internal.RandomString(16)
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`,
+			d.qop, nc, cnonce,
+			md5hex(ha1+":"+d.nonce+":"+nc+":"+cnonce+":"+d.qop+":"+ha2))
+	}
+	if d.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, d.opaque)
+	}
+	return header
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
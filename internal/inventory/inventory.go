@@ -0,0 +1,178 @@
+// Package inventory maps device identifiers, such as MAC or IP addresses, to
+// descriptive tags like a friendly name, location or owner, so device inputs
+// can enrich per-client metrics from a file maintained by the user.
+//
+// Inventories are read from YAML or CSV files. In YAML, every top-level key
+// is a device identifier mapping to its tags:
+//
+//   "aa:bb:cc:dd:ee:ff":
+//     name: laptop
+//     owner: alice
+//   "192.168.1.20":
+//     name: printer
+//     location: office
+//
+// In CSV, the first row names the columns. The first column holds the device
+// identifier, every other column a tag; empty cells are skipped:
+//
+//   address,name,location,owner
+//   aa:bb:cc:dd:ee:ff,laptop,,alice
+//   192.168.1.20,printer,office,
+package inventory
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Inventory holds the tags of every known device. A nil Inventory is empty.
+type Inventory struct {
+	devices map[string]map[string]string
+}
+
+// Load reads an inventory file. Files with a .csv extension are read as CSV,
+// all others as YAML.
+func Load(path string) (*Inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inv *Inventory
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		inv, err = parseCSV(f)
+	} else {
+		var b []byte
+		if b, err = ioutil.ReadAll(f); err == nil {
+			inv, err = parseYAML(b)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse inventory %s: %s", path, err)
+	}
+	return inv, nil
+}
+
+func parseYAML(b []byte) (*Inventory, error) {
+	var devices map[string]map[string]string
+	if err := yaml.Unmarshal(b, &devices); err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{devices: make(map[string]map[string]string)}
+	for key, tags := range devices {
+		inv.add(key, tags)
+	}
+	return inv, nil
+}
+
+func parseCSV(f *os.File) (*Inventory, error) {
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{devices: make(map[string]map[string]string)}
+	if len(records) == 0 {
+		return inv, nil
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		tags := make(map[string]string)
+		for i := 1; i < len(record) && i < len(header); i++ {
+			if record[i] != "" {
+				tags[header[i]] = record[i]
+			}
+		}
+		inv.add(record[0], tags)
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) add(key string, tags map[string]string) {
+	key = Normalize(key)
+	if key == "" || len(tags) == 0 {
+		return
+	}
+	inv.devices[key] = tags
+}
+
+// Len returns the number of devices in the inventory.
+func (inv *Inventory) Len() int {
+	if inv == nil {
+		return 0
+	}
+	return len(inv.devices)
+}
+
+// Lookup returns the tags of a device, or nil if it is unknown. The returned
+// map must not be modified.
+func (inv *Inventory) Lookup(key string) map[string]string {
+	if inv == nil {
+		return nil
+	}
+	return inv.devices[Normalize(key)]
+}
+
+// Tag adds the inventory tags of the device identified by key to tags.
+// Tags that are already set are left alone.
+func (inv *Inventory) Tag(tags map[string]string, key string) {
+	for k, v := range inv.Lookup(key) {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+}
+
+// Normalize returns the canonical form of a device identifier: lower case,
+// with the dashes and dots that some vendors use in MAC addresses replaced
+// by colons.
+func Normalize(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if isMAC(key) {
+		key = strings.NewReplacer("-", ":", ".", ":").Replace(key)
+		if len(key) == 14 {
+			// Cisco style aabb.ccdd.eeff
+			key = key[0:2] + ":" + key[2:4] + ":" + key[5:7] + ":" +
+				key[7:9] + ":" + key[10:12] + ":" + key[12:14]
+		}
+	}
+	return key
+}
+
+// isMAC reports whether key looks like a MAC address in one of the usual
+// notations: aa:bb:cc:dd:ee:ff, aa-bb-cc-dd-ee-ff or aabb.ccdd.eeff.
+func isMAC(key string) bool {
+	var groups []string
+	var size int
+	switch len(key) {
+	case 17:
+		groups = strings.FieldsFunc(key, func(r rune) bool { return r == ':' || r == '-' })
+		size = 2
+	case 14:
+		groups = strings.Split(key, ".")
+		size = 4
+	default:
+		return false
+	}
+	for _, g := range groups {
+		if len(g) != size {
+			return false
+		}
+		for _, c := range g {
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
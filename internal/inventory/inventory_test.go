@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "inventory.yaml", `
+"AA-BB-CC-DD-EE-FF":
+  name: laptop
+  owner: alice
+"192.168.1.20":
+  name: printer
+  location: office
+"aabb.ccdd.0011":
+  name: switch
+`)
+	inv, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, inv.Len())
+
+	assert.Equal(t, map[string]string{"name": "laptop", "owner": "alice"},
+		inv.Lookup("aa:bb:cc:dd:ee:ff"))
+	assert.Equal(t, map[string]string{"name": "printer", "location": "office"},
+		inv.Lookup("192.168.1.20"))
+	assert.Equal(t, map[string]string{"name": "switch"},
+		inv.Lookup("AA:BB:CC:DD:00:11"))
+	assert.Nil(t, inv.Lookup("11:22:33:44:55:66"))
+}
+
+func TestLoadCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "inventory.csv", `address,name,location,owner
+aa:bb:cc:dd:ee:ff,laptop,,alice
+192.168.1.20, printer, office,
+10.0.0.1,,,
+`)
+	inv, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, inv.Len())
+
+	assert.Equal(t, map[string]string{"name": "laptop", "owner": "alice"},
+		inv.Lookup("AA-BB-CC-DD-EE-FF"))
+	assert.Equal(t, map[string]string{"name": "printer", "location": "office"},
+		inv.Lookup("192.168.1.20"))
+	assert.Nil(t, inv.Lookup("10.0.0.1"))
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+
+	_, err = Load(writeFile(t, dir, "bad.yaml", "- a\n- b\n"))
+	assert.Error(t, err)
+
+	_, err = Load(writeFile(t, dir, "bad.csv", "a,b\n\"c,d\n"))
+	assert.Error(t, err)
+}
+
+func TestTag(t *testing.T) {
+	inv := &Inventory{devices: map[string]map[string]string{
+		"aa:bb:cc:dd:ee:ff": {"name": "laptop", "server": "ignored"},
+	}}
+
+	tags := map[string]string{"server": "gw"}
+	inv.Tag(tags, "AA:BB:CC:DD:EE:FF")
+	assert.Equal(t, map[string]string{"server": "gw", "name": "laptop"}, tags)
+
+	tags = map[string]string{"server": "gw"}
+	inv.Tag(tags, "11:22:33:44:55:66")
+	assert.Equal(t, map[string]string{"server": "gw"}, tags)
+
+	// a nil inventory adds nothing
+	var empty *Inventory
+	empty.Tag(tags, "AA:BB:CC:DD:EE:FF")
+	assert.Equal(t, map[string]string{"server": "gw"}, tags)
+	assert.Equal(t, 0, empty.Len())
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", Normalize("AA:BB:CC:DD:EE:FF"))
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", Normalize(" aa-bb-cc-dd-ee-ff "))
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", Normalize("aabb.ccdd.eeff"))
+	assert.Equal(t, "192.168.1.20", Normalize("192.168.1.20"))
+	assert.Equal(t, "printer.lan", Normalize("Printer.LAN"))
+}
@@ -15,6 +15,7 @@ type RunningInput struct {
 // InputConfig containing a name, interval, and filter
 type InputConfig struct {
 	Name              string
+	Alias             string
 	NameOverride      string
 	MeasurementPrefix string
 	MeasurementSuffix string
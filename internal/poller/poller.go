@@ -0,0 +1,96 @@
+// Package poller runs the collection of inputs polling a list of servers.
+//
+// A Poller polls every server in a goroutine of its own, within the gather
+// timeout of the input, and skips the servers its devicehttp.Breaker has
+// opened for and those its schedule.Scheduler finds not due. Every poll is
+// reported with an availability point. Inputs supply the function polling a
+// single server.
+package poller
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/availability"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/internal/schedule"
+)
+
+// GatherFunc polls a single server and adds its metrics to acc.
+type GatherFunc func(server string, acc telegraf.Accumulator) error
+
+// Poller polls the servers of an input. Inputs set the fields from their
+// configuration before every call to Poll. The zero value polls every
+// server on every collection, without a timeout.
+type Poller struct {
+	// Measurement is the measurement of the availability points.
+	Measurement string
+	// GatherTimeout bounds all requests of a collection.
+	GatherTimeout time.Duration
+	// MaxFailures and SkipPolls configure the Breaker, see
+	// devicehttp.Breaker.
+	MaxFailures int
+	SkipPolls   int
+	// PollInterval and PollJitter configure the Scheduler, see
+	// schedule.Scheduler.
+	PollInterval time.Duration
+	PollJitter   time.Duration
+
+	breaker   devicehttp.Breaker
+	scheduler schedule.Scheduler
+}
+
+// Poll calls gather concurrently for the servers due, with the requests of
+// client bounded by the gather timeout, and returns the errors of the polls.
+func (p *Poller) Poll(
+	client *devicehttp.Client,
+	servers []string,
+	acc telegraf.Accumulator,
+	gather GatherFunc,
+) error {
+	var deadline time.Time
+	if p.GatherTimeout > 0 {
+		deadline = time.Now().Add(p.GatherTimeout)
+	}
+	client.SetDeadline(deadline)
+	p.breaker.Threshold = p.MaxFailures
+	p.breaker.Skip = p.SkipPolls
+	p.scheduler.Jitter = p.PollJitter
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(servers))
+	wg.Add(len(servers))
+	for _, server := range servers {
+		go func(server string) {
+			defer wg.Done()
+			if !p.scheduler.Start(server, p.PollInterval) {
+				return
+			}
+			defer p.scheduler.Done(server)
+
+			err := p.breaker.Allow(server)
+			if err == nil {
+				err = gather(server, acc)
+				p.breaker.Record(server, err)
+			}
+			availability.Add(acc, p.Measurement,
+				map[string]string{"server": ServerHost(server)}, err)
+			errChan.C <- err
+		}(server)
+	}
+
+	wg.Wait()
+	return errChan.Error()
+}
+
+// ServerHost returns the value of the server tag for a configured server:
+// the host and port of its URL, or the server itself if it isn't one.
+func ServerHost(server string) string {
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return server
+}
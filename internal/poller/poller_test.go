@@ -0,0 +1,126 @@
+package poller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter counts the polls of every server.
+type counter struct {
+	sync.Mutex
+	polls map[string]int
+	fail  map[string]bool
+}
+
+func (c *counter) gather(server string, acc telegraf.Accumulator) error {
+	c.Lock()
+	defer c.Unlock()
+	c.polls[server]++
+	if c.fail[server] {
+		return errors.New("invalid response")
+	}
+	acc.AddFields("test", map[string]interface{}{"value": 1},
+		map[string]string{"server": ServerHost(server)})
+	return nil
+}
+
+// upPoints returns the up fields reported by server and reason.
+func upPoints(acc *testutil.Accumulator) map[string][]interface{} {
+	up := make(map[string][]interface{})
+	for _, m := range acc.Metrics {
+		if m.Measurement == "test_up" {
+			key := m.Tags["server"] + " " + m.Tags["reason"]
+			up[key] = append(up[key], m.Fields["up"])
+		}
+	}
+	return up
+}
+
+func TestPoll(t *testing.T) {
+	client, err := devicehttp.NewClient(devicehttp.Config{})
+	require.NoError(t, err)
+	c := &counter{
+		polls: make(map[string]int),
+		fail:  map[string]bool{"http://b:80/status": true},
+	}
+	p := &Poller{Measurement: "test_up", MaxFailures: 1, SkipPolls: 1}
+	servers := []string{"http://a:80/status", "http://b:80/status"}
+
+	var acc testutil.Accumulator
+	assert.Error(t, p.Poll(client, servers, &acc, c.gather))
+	assert.Equal(t, map[string][]interface{}{
+		"a:80 ":      {1},
+		"b:80 error": {0},
+	}, upPoints(&acc))
+
+	// the failed server is skipped once
+	acc = testutil.Accumulator{}
+	assert.Error(t, p.Poll(client, servers, &acc, c.gather))
+	assert.Equal(t, map[string][]interface{}{
+		"a:80 ":        {1},
+		"b:80 skipped": {0},
+	}, upPoints(&acc))
+
+	c.Lock()
+	assert.Equal(t, map[string]int{"http://a:80/status": 2, "http://b:80/status": 1}, c.polls)
+	c.Unlock()
+}
+
+func TestPollInterval(t *testing.T) {
+	client, err := devicehttp.NewClient(devicehttp.Config{})
+	require.NoError(t, err)
+	c := &counter{polls: make(map[string]int)}
+	p := &Poller{Measurement: "test_up", PollInterval: time.Hour}
+
+	var acc testutil.Accumulator
+	require.NoError(t, p.Poll(client, []string{"http://a:80/status"}, &acc, c.gather))
+	// not due yet, so neither polled nor reported
+	acc = testutil.Accumulator{}
+	require.NoError(t, p.Poll(client, []string{"http://a:80/status"}, &acc, c.gather))
+	assert.Equal(t, 0, len(acc.Metrics))
+
+	c.Lock()
+	assert.Equal(t, 1, c.polls["http://a:80/status"])
+	c.Unlock()
+}
+
+func TestPollGatherTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client, err := devicehttp.NewClient(devicehttp.Config{})
+	require.NoError(t, err)
+	p := &Poller{Measurement: "test_up", GatherTimeout: 50 * time.Millisecond}
+	gather := func(server string, acc telegraf.Accumulator) error {
+		_, err := client.Get(server)
+		return err
+	}
+
+	var acc testutil.Accumulator
+	assert.Error(t, p.Poll(client, []string{ts.URL}, &acc, gather))
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	acc.AssertContainsTaggedFields(t, "test_up",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": u.Host, "reason": "timeout"})
+}
+
+func TestServerHost(t *testing.T) {
+	assert.Equal(t, "relay.example.com:22070", ServerHost("http://relay.example.com:22070/status"))
+	assert.Equal(t, "relay.example.com", ServerHost("relay.example.com"))
+}
@@ -0,0 +1,199 @@
+// Package rollover compensates for counters that wrap around when they exceed
+// the width of the register they are kept in, as is common with the 32-bit
+// traffic counters exposed by embedded devices.
+//
+// A Cache remembers, per namespace and key, the last raw value seen and the
+// offset accumulated from previous wraps. Whenever a value is lower than the
+// one before it, the increment is added to the offset, so the compensated
+// value keeps growing monotonically. The state can be persisted through a
+// Persister so totals survive agent restarts.
+//
+// With a Threshold, a value lower than a previous one that was below the
+// threshold is taken as a restart of the device rather than a wrap: the
+// counter starts over from the new value. As the previous value is part of
+// the persisted state, this also catches devices restarted while the agent
+// was down.
+package rollover
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// DefaultIncrement is the value added on every detected wrap of a 32-bit
+// counter.
+const DefaultIncrement uint64 = math.MaxUint32 + 1
+
+// RestartThreshold is a Threshold suited to 32-bit counters: a counter that
+// went down from below 3GiB did not wrap.
+const RestartThreshold uint64 = 3 << 30
+
+// Persister loads and saves the serialized state of a Cache.
+type Persister interface {
+	// Load returns the previously saved state, or nil if there is none.
+	Load() ([]byte, error)
+	// Save stores the given state, replacing anything saved before.
+	Save(state []byte) error
+}
+
+// Entry is the state kept for a single counter.
+type Entry struct {
+	// Last is the last raw value seen.
+	Last uint64 `json:"last"`
+	// Offset is the total added to raw values because of previous wraps.
+	Offset uint64 `json:"offset"`
+}
+
+// Cache tracks counter wraps. It is safe for concurrent use.
+type Cache struct {
+	// Increment is added to the offset of a counter every time it wraps.
+	// Zero means DefaultIncrement.
+	Increment uint64
+	// Threshold enables the detection of device restarts. Zero means every
+	// decrease is a wrap.
+	Threshold uint64
+
+	persister Persister
+
+	mu      sync.Mutex
+	entries map[string]map[string]*Entry
+	dirty   bool
+}
+
+// NewCache returns an empty Cache. If persister is nil, the state is only
+// kept in memory.
+func NewCache(persister Persister) *Cache {
+	return &Cache{
+		persister: persister,
+		entries:   make(map[string]map[string]*Entry),
+	}
+}
+
+// Load replaces the in-memory state with the persisted one.
+func (c *Cache) Load() error {
+	if c.persister == nil {
+		return nil
+	}
+	b, err := c.persister.Load()
+	if err != nil {
+		return fmt.Errorf("unable to load rollover state: %s", err)
+	}
+
+	entries := make(map[string]map[string]*Entry)
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return fmt.Errorf("unable to parse rollover state: %s", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.entries = entries
+	c.dirty = false
+	c.mu.Unlock()
+	return nil
+}
+
+// Save persists the state if it changed since the last Load or Save.
+func (c *Cache) Save() error {
+	if c.persister == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(c.entries)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := c.persister.Save(b); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return fmt.Errorf("unable to save rollover state: %s", err)
+	}
+	return nil
+}
+
+// Compensate records value as the latest raw reading of the counter
+// identified by namespace and key, and returns it with all previous wraps
+// added back in.
+func (c *Cache) Compensate(namespace, key string, value uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ns, ok := c.entries[namespace]
+	if !ok {
+		ns = make(map[string]*Entry)
+		c.entries[namespace] = ns
+	}
+
+	e, ok := ns[key]
+	if !ok {
+		e = &Entry{}
+		ns[key] = e
+	} else if value < e.Last {
+		if e.Last < c.Threshold {
+			// the device restarted and the counter started over
+			e.Offset = 0
+		} else {
+			e.Offset += c.increment()
+		}
+	}
+
+	if !ok || e.Last != value {
+		e.Last = value
+		c.dirty = true
+	}
+	return e.Offset + value
+}
+
+// Last returns the last raw value recorded for a counter.
+func (c *Cache) Last(namespace, key string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[namespace][key]; ok {
+		return e.Last, true
+	}
+	return 0, false
+}
+
+// Rebase forgets the wraps recorded for a counter, e.g. because the device
+// was restarted and its counters started over from zero.
+func (c *Cache) Rebase(namespace, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ns, ok := c.entries[namespace]; ok {
+		if _, ok := ns[key]; ok {
+			delete(ns, key)
+			c.dirty = true
+		}
+	}
+}
+
+// Forget drops every counter of a namespace.
+func (c *Cache) Forget(namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[namespace]; ok {
+		delete(c.entries, namespace)
+		c.dirty = true
+	}
+}
+
+func (c *Cache) increment() uint64 {
+	if c.Increment == 0 {
+		return DefaultIncrement
+	}
+	return c.Increment
+}
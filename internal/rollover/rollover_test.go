@@ -0,0 +1,99 @@
+package rollover
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompensate(t *testing.T) {
+	c := NewCache(nil)
+
+	assert.Equal(t, uint64(100), c.Compensate("dev", "rx", 100))
+	assert.Equal(t, uint64(4294967000), c.Compensate("dev", "rx", 4294967000))
+	// wrapped around
+	assert.Equal(t, DefaultIncrement+50, c.Compensate("dev", "rx", 50))
+	assert.Equal(t, DefaultIncrement+60, c.Compensate("dev", "rx", 60))
+}
+
+func TestCompensateNamespaces(t *testing.T) {
+	c := NewCache(nil)
+
+	c.Compensate("a", "rx", 100)
+	c.Compensate("b", "rx", 10)
+
+	assert.Equal(t, DefaultIncrement+5, c.Compensate("a", "rx", 5))
+	assert.Equal(t, uint64(20), c.Compensate("b", "rx", 20))
+}
+
+func TestCustomIncrement(t *testing.T) {
+	c := NewCache(nil)
+	c.Increment = 1 << 16
+
+	c.Compensate("dev", "rx", 65000)
+	assert.Equal(t, uint64(1<<16+10), c.Compensate("dev", "rx", 10))
+}
+
+func TestThreshold(t *testing.T) {
+	c := NewCache(nil)
+	c.Threshold = RestartThreshold
+
+	c.Compensate("dev", "rx", 4294967000)
+	assert.Equal(t, DefaultIncrement+1000, c.Compensate("dev", "rx", 1000))
+	// restarted, the wraps seen before are dropped
+	assert.Equal(t, uint64(500), c.Compensate("dev", "rx", 500))
+	assert.Equal(t, uint64(600), c.Compensate("dev", "rx", 600))
+}
+
+func TestRebaseAndForget(t *testing.T) {
+	c := NewCache(nil)
+
+	c.Compensate("dev", "rx", 100)
+	c.Compensate("dev", "rx", 50)
+	c.Rebase("dev", "rx")
+	assert.Equal(t, uint64(10), c.Compensate("dev", "rx", 10))
+
+	last, ok := c.Last("dev", "rx")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), last)
+	_, ok = c.Last("dev", "missing")
+	assert.False(t, ok)
+
+	c.Compensate("dev", "tx", 100)
+	c.Forget("dev")
+	assert.Equal(t, uint64(5), c.Compensate("dev", "tx", 5))
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollover")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := state.Bind(&state.DirPersister{Dir: dir}, "counters")
+
+	c := NewCache(p)
+	require.NoError(t, c.Load())
+	c.Compensate("dev", "rx", 100)
+	c.Compensate("dev", "rx", 50)
+	require.NoError(t, c.Save())
+
+	c = NewCache(p)
+	require.NoError(t, c.Load())
+	assert.Equal(t, DefaultIncrement+60, c.Compensate("dev", "rx", 60))
+}
+
+func TestLoadInvalidState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollover")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "counters.json"), []byte("{"), 0644))
+
+	c := NewCache(state.Bind(&state.DirPersister{Dir: dir}, "counters"))
+	assert.Error(t, c.Load())
+}
@@ -0,0 +1,80 @@
+// Package schedule decides which devices an input polls in a collection.
+//
+// Inputs are gathered on the interval of the agent, or of their instance,
+// for all devices at once. Inputs polling many devices use a Scheduler to
+// poll each device on its own, longer interval, to spread the polls of the
+// devices with a random jitter, and to skip a device whose previous poll is
+// still running.
+package schedule
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Scheduler tracks the polls of devices by key. Inputs call Start before
+// polling a device and Done after the poll finished. The zero value polls
+// every device on every collection, unless its previous poll is still
+// running. It is safe for concurrent use.
+type Scheduler struct {
+	// Jitter is the maximum random delay added to the interval of every
+	// poll, so devices polled on the same interval drift apart.
+	Jitter time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*device
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+type device struct {
+	next    time.Time
+	running bool
+}
+
+// Start reports whether the device identified by key is due for a poll, and
+// marks its poll as running if so. A device is due once interval, plus the
+// jitter drawn at its previous poll, has passed since the start of that
+// poll. As collections do not start exactly on time, a device is already
+// due when a tenth of its interval is left. A zero interval makes the device
+// due on every collection, without jitter.
+func (s *Scheduler) Start(key string, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	if s.devices == nil {
+		s.devices = make(map[string]*device)
+	}
+	d, ok := s.devices[key]
+	if !ok {
+		d = &device{}
+		s.devices[key] = d
+	}
+	if d.running || now.Before(d.next) {
+		return false
+	}
+
+	d.running = true
+	d.next = now
+	if interval > 0 {
+		d.next = d.next.Add(interval - interval/10)
+		if s.Jitter > 0 {
+			d.next = d.next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+		}
+	}
+	return true
+}
+
+// Done marks the poll of the device identified by key as finished.
+func (s *Scheduler) Done(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[key]; ok {
+		d.running = false
+	}
+}
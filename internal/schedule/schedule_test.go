@@ -0,0 +1,62 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartInterval(t *testing.T) {
+	now := time.Unix(1476612000, 0)
+	s := &Scheduler{now: func() time.Time { return now }}
+
+	assert.True(t, s.Start("a", time.Minute))
+	s.Done("a")
+	assert.True(t, s.Start("b", 0))
+	s.Done("b")
+
+	// collections every 10s, slightly late or early
+	for _, offset := range []time.Duration{10, 20, 30, 40, 50} {
+		now = time.Unix(1476612000, 0).Add(offset*time.Second + 50*time.Millisecond)
+		assert.False(t, s.Start("a", time.Minute), offset.String())
+		assert.True(t, s.Start("b", 0), offset.String())
+		s.Done("b")
+	}
+	now = time.Unix(1476612000, 0).Add(60*time.Second - 50*time.Millisecond)
+	assert.True(t, s.Start("a", time.Minute))
+	s.Done("a")
+}
+
+func TestStartRunning(t *testing.T) {
+	s := &Scheduler{}
+	assert.True(t, s.Start("a", 0))
+	assert.False(t, s.Start("a", 0))
+	s.Done("a")
+	assert.True(t, s.Start("a", 0))
+}
+
+func TestStartJitter(t *testing.T) {
+	start := time.Unix(1476612000, 0)
+	now := start
+	s := &Scheduler{Jitter: 10 * time.Second, now: func() time.Time { return now }}
+
+	assert.True(t, s.Start("a", time.Minute))
+	s.Done("a")
+
+	now = start.Add(53 * time.Second)
+	assert.False(t, s.Start("a", time.Minute))
+	now = start.Add(64 * time.Second)
+	assert.True(t, s.Start("a", time.Minute))
+}
+
+func TestStartJitterZeroInterval(t *testing.T) {
+	now := time.Unix(1476612000, 0)
+	s := &Scheduler{Jitter: time.Hour, now: func() time.Time { return now }}
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, s.Start("a", 0))
+		s.Done("a")
+		now = now.Add(time.Second)
+	}
+}
@@ -0,0 +1,129 @@
+// Package state lets inputs keep data across restarts of the agent, such as
+// the counter wrap offsets of internal/rollover.
+//
+// Inputs that implement StatefulInput are handed a StatePersister when the
+// agent has a state_dir configured. The persister stores named blobs as
+// files in that directory, prefixed with the name of the plugin instance.
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StatePersister loads and saves named blobs of plugin state.
+type StatePersister interface {
+	// Load returns the blob saved under key, or nil if there is none.
+	Load(key string) ([]byte, error)
+	// Save stores a blob under key, replacing anything saved before.
+	Save(key string, state []byte) error
+}
+
+// StatefulInput is implemented by inputs that keep state across restarts.
+type StatefulInput interface {
+	// SetStatePersister is called once, before the first Gather.
+	SetStatePersister(p StatePersister)
+}
+
+// DirPersister keeps every blob in a file of its own in Dir.
+type DirPersister struct {
+	Dir string
+}
+
+func (d *DirPersister) path(key string) string {
+	return filepath.Join(d.Dir, sanitize(key)+".json")
+}
+
+// Load reads the file of key. A missing file is not an error.
+func (d *DirPersister) Load(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// Save writes the blob to a temporary file in Dir and renames it over the
+// file of key, so a crash never leaves a truncated file behind. Dir is
+// created if needed.
+func (d *DirPersister) Save(key string, state []byte) error {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	path := d.path(key)
+	tmp, err := ioutil.TempFile(d.Dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// sanitize makes key safe to use as a file name.
+func sanitize(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9',
+			r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+type prefixed struct {
+	p      StatePersister
+	prefix string
+}
+
+// WithPrefix returns a persister that stores all keys below prefix, so
+// several plugin instances can share a directory.
+func WithPrefix(p StatePersister, prefix string) StatePersister {
+	return &prefixed{p: p, prefix: prefix}
+}
+
+func (p *prefixed) Load(key string) ([]byte, error) {
+	return p.p.Load(p.prefix + "." + key)
+}
+
+func (p *prefixed) Save(key string, state []byte) error {
+	return p.p.Save(p.prefix+"."+key, state)
+}
+
+// Blob is a single named blob of a StatePersister. It satisfies
+// rollover.Persister.
+type Blob struct {
+	Persister StatePersister
+	Key       string
+}
+
+// Bind returns the blob stored under key in p.
+func Bind(p StatePersister, key string) *Blob {
+	return &Blob{Persister: p, Key: key}
+}
+
+func (b *Blob) Load() ([]byte, error) {
+	state, err := b.Persister.Load(b.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load state %s: %s", b.Key, err)
+	}
+	return state, nil
+}
+
+func (b *Blob) Save(state []byte) error {
+	if err := b.Persister.Save(b.Key, state); err != nil {
+		return fmt.Errorf("unable to save state %s: %s", b.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/rollover"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirPersister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &DirPersister{Dir: filepath.Join(dir, "sub")}
+	b, err := p.Load("missing")
+	require.NoError(t, err)
+	assert.Nil(t, b)
+
+	require.NoError(t, p.Save("ddwrt/counters", []byte("one")))
+	require.NoError(t, p.Save("ddwrt/counters", []byte("two")))
+	b, err = p.Load("ddwrt/counters")
+	require.NoError(t, err)
+	assert.Equal(t, "two", string(b))
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "sub"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "ddwrt_counters.json", files[0].Name())
+}
+
+func TestPrefixAndBind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &DirPersister{Dir: dir}
+	first := Bind(WithPrefix(p, "ddwrt"), "counters")
+	second := Bind(WithPrefix(p, "ddwrt-2"), "counters")
+	require.NoError(t, first.Save([]byte("1")))
+	require.NoError(t, second.Save([]byte("2")))
+
+	b, err := first.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(b))
+	b, err = p.Load("ddwrt-2.counters")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(b))
+
+	// a blob can back a rollover cache
+	var _ rollover.Persister = first
+}
@@ -0,0 +1,146 @@
+// Package upnp calls the SOAP actions of UPnP devices, such as Internet
+// Gateway Devices, and of TR-064 devices, which use the same protocol.
+//
+// ControlURLs reads the control URLs of the services of a device from its
+// device description, and Call invokes an action of one of them.
+package upnp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/influxdata/telegraf/internal/devicehttp"
+)
+
+// CounterActions maps the counter actions of the WANCommonInterfaceConfig
+// service, in both UPnP and TR-064, to the output argument holding the value
+// and the field name.
+var CounterActions = []struct {
+	Action, Argument, Field string
+}{
+	{"GetTotalBytesSent", "NewTotalBytesSent", "bytes_sent"},
+	{"GetTotalBytesReceived", "NewTotalBytesReceived", "bytes_received"},
+	{"GetTotalPacketsSent", "NewTotalPacketsSent", "packets_sent"},
+	{"GetTotalPacketsReceived", "NewTotalPacketsReceived", "packets_received"},
+}
+
+// ControlURLs parses the device description read from location and returns
+// the control URLs of the services of the device and its embedded devices
+// by service type. If a service type is listed more than once, the first one
+// is kept.
+func ControlURLs(description []byte, location string) (map[string]string, error) {
+	var root struct {
+		URLBase string `xml:"URLBase"`
+		Device  device `xml:"device"`
+	}
+	if err := xml.Unmarshal(description, &root); err != nil {
+		return nil, fmt.Errorf("unable to parse device description %s: %s", location, err)
+	}
+
+	base := strings.TrimSpace(root.URLBase)
+	if base == "" {
+		base = location
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URLBase in %s: %s", location, err)
+	}
+
+	services := make(map[string]string)
+	if err := root.Device.collect(baseURL, services); err != nil {
+		return nil, fmt.Errorf("invalid controlURL in %s: %s", location, err)
+	}
+	return services, nil
+}
+
+type device struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []device `xml:"deviceList>device"`
+}
+
+// collect adds the services of the device and its embedded devices to
+// services, with their control URLs resolved against base.
+func (d *device) collect(base *url.URL, services map[string]string) error {
+	for _, s := range d.Services {
+		serviceType := strings.TrimSpace(s.ServiceType)
+		if _, ok := services[serviceType]; ok {
+			continue
+		}
+		ref, err := url.Parse(strings.TrimSpace(s.ControlURL))
+		if err != nil {
+			return err
+		}
+		services[serviceType] = base.ResolveReference(ref).String()
+	}
+	for i := range d.Devices {
+		if err := d.Devices[i].collect(base, services); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call invokes an action without input arguments of the service at control
+// and returns the output arguments of the response by name.
+func Call(client *devicehttp.Client, control, service, action string) (map[string]string, error) {
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%s xmlns:u="%s"></u:%s></s:Body>
+</s:Envelope>`, action, service, action)
+
+	header := http.Header{}
+	header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	header.Set("SOAPAction", `"`+service+"#"+action+`"`)
+	b, err := client.Do("POST", control, header, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := responseValues(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s response from %s: %s", action, control, err)
+	}
+	return values, nil
+}
+
+// responseValues collects the text of the output arguments of a SOAP
+// response, whose names start with "New" in both UPnP and TR-064.
+func responseValues(b []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	var current string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			current = ""
+			if strings.HasPrefix(t.Name.Local, "New") {
+				current = t.Name.Local
+				values[current] = ""
+			}
+		case xml.CharData:
+			if current != "" {
+				values[current] += string(t)
+			}
+		case xml.EndElement:
+			current = ""
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no output arguments found")
+	}
+	for k, v := range values {
+		values[k] = strings.TrimSpace(v)
+	}
+	return values, nil
+}
@@ -0,0 +1,93 @@
+package upnp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const description = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <serviceList>
+      <service>
+        <serviceType>urn:dslforum-org:service:DeviceInfo:1</serviceType>
+        <controlURL>/upnp/control/deviceinfo</controlURL>
+      </service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <serviceList>
+          <service>
+            <serviceType> urn:dslforum-org:service:WANCommonInterfaceConfig:1 </serviceType>
+            <controlURL>ctl/CmnIfCfg</controlURL>
+          </service>
+          <service>
+            <serviceType>urn:dslforum-org:service:DeviceInfo:1</serviceType>
+            <controlURL>/other</controlURL>
+          </service>
+        </serviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+func TestControlURLs(t *testing.T) {
+	services, err := ControlURLs([]byte(description), "http://192.168.1.1:49000/desc/tr64desc.xml")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"urn:dslforum-org:service:DeviceInfo:1":               "http://192.168.1.1:49000/upnp/control/deviceinfo",
+		"urn:dslforum-org:service:WANCommonInterfaceConfig:1": "http://192.168.1.1:49000/desc/ctl/CmnIfCfg",
+	}, services)
+
+	// URLBase takes precedence over the location
+	withBase := `<root><URLBase>http://192.168.1.1:5000/</URLBase>` +
+		`<device><serviceList><service><serviceType>a</serviceType>` +
+		`<controlURL>ctl/a</controlURL></service></serviceList></device></root>`
+	services, err = ControlURLs([]byte(withBase), "http://192.168.1.1:49000/rootDesc.xml")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "http://192.168.1.1:5000/ctl/a"}, services)
+
+	_, err = ControlURLs([]byte("<root>"), "http://192.168.1.1/rootDesc.xml")
+	assert.Error(t, err)
+}
+
+func TestCall(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("SOAPAction") != `"urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1#GetTotalBytesSent"` ||
+			!strings.Contains(string(b), `<u:GetTotalBytesSent xmlns:u="urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1">`) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+<s:Body><u:GetTotalBytesSentResponse xmlns:u="urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1">
+<NewTotalBytesSent> 4294967000 </NewTotalBytesSent><NewEmpty></NewEmpty>
+</u:GetTotalBytesSentResponse></s:Body>
+</s:Envelope>`)
+	}))
+	defer ts.Close()
+
+	client, err := devicehttp.NewClient(devicehttp.Config{})
+	require.NoError(t, err)
+
+	values, err := Call(client, ts.URL, "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1", "GetTotalBytesSent")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"NewTotalBytesSent": "4294967000", "NewEmpty": ""}, values)
+
+	_, err = Call(client, ts.URL, "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1", "GetTotalBytesReceived")
+	assert.Error(t, err)
+}
+
+func TestResponseValuesEmpty(t *testing.T) {
+	_, err := responseValues([]byte(`<s:Envelope><s:Body><u:GetInfoResponse/></s:Body></s:Envelope>`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,427 @@
+package bacnet_ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// objectTypes maps the object type names accepted in the configuration to
+// their BACnetObjectType values.
+var objectTypes = map[string]uint32{
+	"analog-input":           0,
+	"analog-output":          1,
+	"analog-value":           2,
+	"binary-input":           3,
+	"binary-output":          4,
+	"binary-value":           5,
+	"device":                 8,
+	"loop":                   12,
+	"multi-state-input":      13,
+	"multi-state-output":     14,
+	"multi-state-value":      19,
+	"accumulator":            23,
+	"pulse-converter":        24,
+	"integer-value":          45,
+	"large-analog-value":     46,
+	"positive-integer-value": 48,
+}
+
+// properties maps the property names accepted in the configuration to their
+// BACnetPropertyIdentifier values.
+var properties = map[string]uint32{
+	"description":        28,
+	"event-state":        36,
+	"object-name":        77,
+	"out-of-service":     81,
+	"present-value":      85,
+	"reliability":        103,
+	"relinquish-default": 104,
+	"status-flags":       111,
+	"units":              117,
+}
+
+// lookup resolves a configured name, or a number for types and properties
+// missing from the tables.
+func lookup(name string, names map[string]uint32, what string) (uint32, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if v, ok := names[name]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown %s '%s'", what, name)
+	}
+	return uint32(v), nil
+}
+
+// Application tag numbers of the BACnet data types.
+const (
+	tagNull            = 0
+	tagBoolean         = 1
+	tagUnsigned        = 2
+	tagSigned          = 3
+	tagReal            = 4
+	tagDouble          = 5
+	tagOctetString     = 6
+	tagCharacterString = 7
+	tagBitString       = 8
+	tagEnumerated      = 9
+)
+
+const (
+	bvlcType              = 0x81
+	bvlcForwardedNPDU     = 0x04
+	bvlcOriginalUnicast   = 0x0a
+	bvlcOriginalBroadcast = 0x0b
+
+	serviceReadProperty = 0x0c
+)
+
+var errSegmented = errors.New("segmented responses are not supported")
+
+// encodeReadProperty builds a ReadProperty confirmed request, wrapped in
+// an NPDU and a BVLC Original-Unicast-NPDU header.
+func encodeReadProperty(invokeID byte, objectType, instance, property uint32) []byte {
+	b := []byte{
+		bvlcType, bvlcOriginalUnicast, 0, 0,
+		// NPDU version 1, expecting a reply
+		0x01, 0x04,
+		// confirmed request, unsegmented, up to 1476 octets accepted
+		0x00, 0x05, invokeID, serviceReadProperty,
+		// [0] object identifier
+		0x0c, 0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint32(b[11:], objectType<<22|instance&0x3fffff)
+	b = appendContextUnsigned(b, 1, property)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+func appendContextUnsigned(b []byte, tag byte, v uint32) []byte {
+	var value []byte
+	switch {
+	case v < 1<<8:
+		value = []byte{byte(v)}
+	case v < 1<<16:
+		value = []byte{byte(v >> 8), byte(v)}
+	case v < 1<<24:
+		value = []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	default:
+		value = []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	b = append(b, tag<<4|0x08|byte(len(value)))
+	return append(b, value...)
+}
+
+// apdu strips the BVLC and NPDU headers of a packet.
+func apdu(b []byte) ([]byte, error) {
+	if len(b) < 4 || b[0] != bvlcType {
+		return nil, errors.New("not a BACnet/IP packet")
+	}
+	switch b[1] {
+	case bvlcOriginalUnicast, bvlcOriginalBroadcast:
+		b = b[4:]
+	case bvlcForwardedNPDU:
+		if len(b) < 10 {
+			return nil, errors.New("short BVLC header")
+		}
+		b = b[10:]
+	default:
+		return nil, fmt.Errorf("unexpected BVLC function %d", b[1])
+	}
+
+	if len(b) < 2 || b[0] != 0x01 {
+		return nil, errors.New("unsupported NPDU version")
+	}
+	control := b[1]
+	if control&0x80 != 0 {
+		return nil, errors.New("network layer message")
+	}
+	i := 2
+	// skip destination and source specifiers
+	if control&0x20 != 0 {
+		if len(b) < i+3 {
+			return nil, errors.New("short NPDU header")
+		}
+		i += 3 + int(b[i+2])
+	}
+	if control&0x08 != 0 {
+		if len(b) < i+3 {
+			return nil, errors.New("short NPDU header")
+		}
+		i += 3 + int(b[i+2])
+	}
+	if control&0x20 != 0 {
+		// hop count
+		i++
+	}
+	if len(b) < i {
+		return nil, errors.New("short NPDU header")
+	}
+	return b[i:], nil
+}
+
+// BACnetError is returned when a device answers a request with an Error,
+// Reject or Abort PDU.
+type BACnetError struct {
+	Kind  string
+	Class uint32
+	Code  uint32
+}
+
+func (e *BACnetError) Error() string {
+	if e.Kind != "error" {
+		return fmt.Sprintf("request rejected (%s reason %d)", e.Kind, e.Code)
+	}
+	switch {
+	case e.Class == 1 && e.Code == 31:
+		return "unknown object"
+	case e.Class == 2 && e.Code == 32:
+		return "unknown property"
+	}
+	return fmt.Sprintf("error class %d code %d", e.Class, e.Code)
+}
+
+// decodeReadPropertyAck returns the invoke ID of a response to ReadProperty
+// and the first value it carries. Error, Reject and Abort PDUs are returned
+// as a *BACnetError along with their invoke ID.
+func decodeReadPropertyAck(b []byte) (byte, interface{}, error) {
+	b, err := apdu(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(b) < 3 {
+		return 0, nil, errors.New("short APDU")
+	}
+
+	invokeID := b[1]
+	switch b[0] >> 4 {
+	case 3: // complex ack
+		if b[0]&0x08 != 0 {
+			return invokeID, nil, errSegmented
+		}
+		if b[2] != serviceReadProperty {
+			return invokeID, nil, fmt.Errorf("unexpected service %d", b[2])
+		}
+		value, err := decodePropertyValue(b[3:])
+		return invokeID, value, err
+	case 5: // error
+		e := &BACnetError{Kind: "error"}
+		d := decoder{b: b[3:]}
+		e.Class, err = d.enumerated()
+		if err == nil {
+			e.Code, err = d.enumerated()
+		}
+		if err != nil {
+			return invokeID, nil, err
+		}
+		return invokeID, nil, e
+	case 6:
+		return invokeID, nil, &BACnetError{Kind: "reject", Code: uint32(b[2])}
+	case 7:
+		return invokeID, nil, &BACnetError{Kind: "abort", Code: uint32(b[2])}
+	}
+	return invokeID, nil, fmt.Errorf("unexpected PDU type %d", b[0]>>4)
+}
+
+// decodePropertyValue skips the object and property identifiers of a
+// ReadProperty-ACK and decodes the first value between the opening and
+// closing tags of the property value.
+func decodePropertyValue(b []byte) (interface{}, error) {
+	d := decoder{b: b}
+	for {
+		h, err := d.header()
+		if err != nil {
+			return nil, err
+		}
+		if h.context && h.opening && h.number == 3 {
+			break
+		}
+		if h.opening || h.closing {
+			return nil, errors.New("unexpected constructed value")
+		}
+		if _, err := d.take(h.length); err != nil {
+			return nil, err
+		}
+	}
+
+	h, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+	if h.context {
+		return nil, errors.New("unsupported constructed property value")
+	}
+	return d.value(h)
+}
+
+type header struct {
+	number  byte
+	context bool
+	opening bool
+	closing bool
+	// lvt is the raw length/value/type field, which holds the value of
+	// application booleans.
+	lvt    byte
+	length int
+}
+
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || len(d.b) < n {
+		return nil, errors.New("truncated value")
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) header() (header, error) {
+	var h header
+	b, err := d.take(1)
+	if err != nil {
+		return h, err
+	}
+	h.number = b[0] >> 4
+	h.context = b[0]&0x08 != 0
+	h.lvt = b[0] & 0x07
+	if h.number == 0x0f {
+		n, err := d.take(1)
+		if err != nil {
+			return h, err
+		}
+		h.number = n[0]
+	}
+
+	switch {
+	case h.context && h.lvt == 6:
+		h.opening = true
+	case h.context && h.lvt == 7:
+		h.closing = true
+	case !h.context && h.number == tagBoolean:
+	case h.lvt < 5:
+		h.length = int(h.lvt)
+	default:
+		n, err := d.take(1)
+		if err != nil {
+			return h, err
+		}
+		switch n[0] {
+		case 254:
+			l, err := d.take(2)
+			if err != nil {
+				return h, err
+			}
+			h.length = int(binary.BigEndian.Uint16(l))
+		case 255:
+			l, err := d.take(4)
+			if err != nil {
+				return h, err
+			}
+			h.length = int(binary.BigEndian.Uint32(l))
+		default:
+			h.length = int(n[0])
+		}
+	}
+	return h, nil
+}
+
+func (d *decoder) enumerated() (uint32, error) {
+	h, err := d.header()
+	if err != nil {
+		return 0, err
+	}
+	if h.context || h.number != tagEnumerated {
+		return 0, errors.New("expected an enumerated value")
+	}
+	if h.length == 0 || h.length > 4 {
+		return 0, errors.New("invalid enumerated value")
+	}
+	b, err := d.take(h.length)
+	if err != nil {
+		return 0, err
+	}
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v, nil
+}
+
+// value decodes an application tagged value. Unsigned integers and
+// enumerations become int64 unless they exceed its range, bit strings become
+// an integer with the first bit in the least significant position.
+func (d *decoder) value(h header) (interface{}, error) {
+	if h.number == tagBoolean {
+		return h.lvt == 1, nil
+	}
+	b, err := d.take(h.length)
+	if err != nil {
+		return nil, err
+	}
+
+	switch h.number {
+	case tagNull:
+		return nil, nil
+	case tagUnsigned, tagEnumerated:
+		if len(b) == 0 || len(b) > 8 {
+			return nil, errors.New("invalid unsigned value")
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case tagSigned:
+		if len(b) == 0 || len(b) > 8 {
+			return nil, errors.New("invalid signed value")
+		}
+		v := int64(int8(b[0]))
+		for _, c := range b[1:] {
+			v = v<<8 | int64(c)
+		}
+		return v, nil
+	case tagReal:
+		if len(b) != 4 {
+			return nil, errors.New("invalid real value")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case tagDouble:
+		if len(b) != 8 {
+			return nil, errors.New("invalid double value")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case tagCharacterString:
+		if len(b) == 0 {
+			return nil, errors.New("invalid character string")
+		}
+		// only ANSI X3.4 (UTF-8) strings are decoded
+		if b[0] != 0 {
+			return nil, fmt.Errorf("unsupported character set %d", b[0])
+		}
+		return string(b[1:]), nil
+	case tagBitString:
+		if len(b) == 0 || len(b) > 8 {
+			return nil, errors.New("unsupported bit string")
+		}
+		bits := (len(b)-1)*8 - int(b[0])
+		var v int64
+		for i := 0; i < bits; i++ {
+			if b[1+i/8]&(0x80>>uint(i%8)) != 0 {
+				v |= 1 << uint(i)
+			}
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported data type %d", h.number)
+}
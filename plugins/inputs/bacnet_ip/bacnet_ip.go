@@ -0,0 +1,231 @@
+package bacnet_ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const defaultPort = "47808"
+
+var errNoResponse = errors.New("no response")
+
+type BACnetIP struct {
+	Devices []string
+	Timeout internal.Duration
+	Retries int
+	Objects []Object `toml:"object"`
+
+	sync.Mutex
+	invokeID byte
+}
+
+// Object is a property of a BACnet object to read from every device.
+type Object struct {
+	// Name is the field name, by default made of the object type, the
+	// instance and, unless it is the present value, the property.
+	Name     string
+	Type     string
+	Instance uint32
+	Property string
+}
+
+var sampleConfig = `
+  ## Controllers to poll, as "host" or "host:port" (default port 47808)
+  devices = ["192.168.1.50"]
+
+  ## Timeout for each request, and the number of times it is sent again
+  ## when it times out
+  timeout = "2s"
+  retries = 2
+
+  ## Objects to read from every device. The type is the object type name as
+  ## in the BACnet standard (e.g. "analog-input", "binary-value"), or its
+  ## number. The property defaults to "present-value".
+  [[inputs.bacnet_ip.object]]
+    name = "supply_air_temperature"
+    type = "analog-input"
+    instance = 1
+
+  [[inputs.bacnet_ip.object]]
+    name = "fan_status"
+    type = "binary-value"
+    instance = 3
+
+  [[inputs.bacnet_ip.object]]
+    name = "fan_status_flags"
+    type = "binary-value"
+    instance = 3
+    property = "status-flags"
+`
+
+func (b *BACnetIP) SampleConfig() string {
+	return sampleConfig
+}
+
+func (b *BACnetIP) Description() string {
+	return "Read object properties from BACnet/IP building automation controllers"
+}
+
+// request is a configured object resolved to the numbers sent on the wire.
+type request struct {
+	field      string
+	objectType uint32
+	instance   uint32
+	property   uint32
+}
+
+func (b *BACnetIP) requests() ([]request, error) {
+	var requests []request
+	for _, o := range b.Objects {
+		objectType, err := lookup(o.Type, objectTypes, "object type")
+		if err != nil {
+			return nil, err
+		}
+		property := o.Property
+		if property == "" {
+			property = "present-value"
+		}
+		propertyID, err := lookup(property, properties, "property")
+		if err != nil {
+			return nil, err
+		}
+
+		field := o.Name
+		if field == "" {
+			field = fmt.Sprintf("%s_%d", o.Type, o.Instance)
+			if propertyID != properties["present-value"] {
+				field += "_" + property
+			}
+			field = strings.Replace(field, "-", "_", -1)
+		}
+		requests = append(requests, request{
+			field:      field,
+			objectType: objectType,
+			instance:   o.Instance,
+			property:   propertyID,
+		})
+	}
+	return requests, nil
+}
+
+func (b *BACnetIP) Gather(acc telegraf.Accumulator) error {
+	requests, err := b.requests()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(b.Devices))
+	wg.Add(len(b.Devices))
+	for _, device := range b.Devices {
+		go func(device string) {
+			defer wg.Done()
+			errChan.C <- b.gatherDevice(device, requests, acc)
+		}(device)
+	}
+
+	wg.Wait()
+	return errChan.Error()
+}
+
+func (b *BACnetIP) gatherDevice(device string, requests []request, acc telegraf.Accumulator) error {
+	if _, _, err := net.SplitHostPort(device); err != nil {
+		device = net.JoinHostPort(device, defaultPort)
+	}
+	addr, err := net.ResolveUDPAddr("udp", device)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", device, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %s", device, err)
+	}
+	defer conn.Close()
+
+	fields := make(map[string]interface{})
+	var failed []string
+	for _, r := range requests {
+		value, err := b.readProperty(conn, r)
+		if err != nil {
+			if _, ok := err.(net.Error); ok || err == errNoResponse {
+				// don't wait for every other object to time out as well
+				return fmt.Errorf("%s: %s", device, err)
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", r.field, err))
+			continue
+		}
+		if value != nil {
+			fields[r.field] = value
+		}
+	}
+
+	if len(fields) > 0 {
+		acc.AddFields("bacnet_ip", fields, map[string]string{"device": device})
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %s", device, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (b *BACnetIP) nextInvokeID() byte {
+	b.Lock()
+	defer b.Unlock()
+	b.invokeID++
+	return b.invokeID
+}
+
+// readProperty sends a ReadProperty request and waits for the answer,
+// sending the request again after every timeout up to Retries times.
+func (b *BACnetIP) readProperty(conn *net.UDPConn, r request) (interface{}, error) {
+	invokeID := b.nextInvokeID()
+	packet := encodeReadProperty(invokeID, r.objectType, r.instance, r.property)
+
+	timeout := b.Timeout.Duration
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt <= b.Retries; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			id, value, err := decodeReadPropertyAck(buf[:n])
+			if id != invokeID {
+				// a late answer to an earlier request, or an unrelated
+				// broadcast
+				continue
+			}
+			return value, err
+		}
+	}
+	return nil, errNoResponse
+}
+
+func init() {
+	inputs.Add("bacnet_ip", func() telegraf.Input {
+		return &BACnetIP{
+			Timeout: internal.Duration{Duration: 2 * time.Second},
+			Retries: 2,
+		}
+	})
+}
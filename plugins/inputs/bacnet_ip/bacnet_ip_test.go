@@ -0,0 +1,198 @@
+package bacnet_ip
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// packet wraps an APDU in a BVLC and NPDU header.
+func packet(apdu ...byte) []byte {
+	b := append([]byte{bvlcType, bvlcOriginalUnicast, 0, 0, 0x01, 0x00}, apdu...)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// ack builds a ReadProperty-ACK carrying the given application tagged value.
+func ack(invokeID byte, request []byte, value ...byte) []byte {
+	// the object and property identifiers are echoed from the request
+	b := []byte{0x30, invokeID, serviceReadProperty}
+	b = append(b, request[10:]...)
+	b = append(b, 0x3e)
+	b = append(b, value...)
+	b = append(b, 0x3f)
+	return packet(b...)
+}
+
+func TestEncodeReadProperty(t *testing.T) {
+	assert.Equal(t, []byte{
+		0x81, 0x0a, 0x00, 0x11, 0x01, 0x04, 0x00, 0x05, 0x01, 0x0c,
+		0x0c, 0x00, 0x00, 0x00, 0x01, 0x19, 0x55,
+	}, encodeReadProperty(1, 0, 1, 85))
+
+	b := encodeReadProperty(7, 5, 3, 1000)
+	assert.Equal(t, []byte{0x0c, 0x01, 0x40, 0x00, 0x03, 0x1a, 0x03, 0xe8}, b[10:])
+	assert.Equal(t, uint16(len(b)), binary.BigEndian.Uint16(b[2:]))
+}
+
+func TestDecodeValues(t *testing.T) {
+	request := encodeReadProperty(1, 0, 1, 85)
+	tests := []struct {
+		value    []byte
+		expected interface{}
+	}{
+		{[]byte{0x44, 0x41, 0xb4, 0x00, 0x00}, 22.5},
+		{[]byte{0x55, 0x08, 0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18}, 3.141592653589793},
+		{[]byte{0x91, 0x01}, int64(1)},
+		{[]byte{0x22, 0x01, 0x00}, int64(256)},
+		{[]byte{0x31, 0xfe}, int64(-2)},
+		{[]byte{0x11}, true},
+		{[]byte{0x10}, false},
+		// status-flags with fault and out-of-service set
+		{[]byte{0x82, 0x04, 0x50}, int64(10)},
+		{[]byte{0x75, 0x05, 0x00, 'A', 'H', 'U', '1'}, "AHU1"},
+		{[]byte{0x00}, nil},
+	}
+	for _, test := range tests {
+		id, value, err := decodeReadPropertyAck(ack(1, request, test.value...))
+		require.NoError(t, err, "%x", test.value)
+		assert.Equal(t, byte(1), id)
+		assert.Equal(t, test.expected, value, "%x", test.value)
+	}
+
+	_, _, err := decodeReadPropertyAck(ack(1, request, 0x65, 0x02, 0x01, 0x02))
+	assert.Error(t, err)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	id, _, err := decodeReadPropertyAck(packet(0x50, 0x04, 0x0c, 0x91, 0x01, 0x91, 0x1f))
+	assert.Equal(t, byte(4), id)
+	assert.Equal(t, &BACnetError{Kind: "error", Class: 1, Code: 31}, err)
+	assert.EqualError(t, err, "unknown object")
+
+	_, _, err = decodeReadPropertyAck(packet(0x60, 0x04, 0x09))
+	assert.EqualError(t, err, "request rejected (reject reason 9)")
+
+	_, _, err = decodeReadPropertyAck(packet(0x38, 0x04, 0x00, 0x04, 0x0c))
+	assert.Equal(t, errSegmented, err)
+
+	_, _, err = decodeReadPropertyAck([]byte{0x82, 0x0a, 0x00, 0x04})
+	assert.Error(t, err)
+}
+
+func TestDecodeRoutedResponse(t *testing.T) {
+	request := encodeReadProperty(2, 0, 1, 85)
+	b := ack(2, request, 0x44, 0x41, 0xb4, 0x00, 0x00)
+	// insert a source specifier: network 5, MAC address 0x07
+	routed := append([]byte{}, b[:5]...)
+	routed = append(routed, 0x08, 0x00, 0x05, 0x01, 0x07)
+	routed = append(routed, b[6:]...)
+	_, value, err := decodeReadPropertyAck(routed)
+	require.NoError(t, err)
+	assert.Equal(t, 22.5, value)
+}
+
+// fakeDevice answers ReadProperty requests for analog-input 1 and
+// binary-value 3, and drops the first request it receives.
+func fakeDevice(t *testing.T) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, 1500)
+		dropped := false
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !dropped {
+				dropped = true
+				continue
+			}
+			request := append([]byte{}, buf[:n]...)
+			invokeID := request[8]
+			object := binary.BigEndian.Uint32(request[11:])
+			property := request[16]
+
+			var response []byte
+			switch {
+			case object == 0<<22|1 && property == 85:
+				response = ack(invokeID, request, 0x44, 0x41, 0xb4, 0x00, 0x00)
+			case object == 5<<22|3 && property == 85:
+				response = ack(invokeID, request, 0x91, 0x01)
+			case object == 5<<22|3 && property == 111:
+				response = ack(invokeID, request, 0x82, 0x04, 0x00)
+			default:
+				response = packet(0x50, invokeID, 0x0c, 0x91, 0x01, 0x91, 0x1f)
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func TestGather(t *testing.T) {
+	addr, stop := fakeDevice(t)
+	defer stop()
+
+	b := &BACnetIP{
+		Devices: []string{addr},
+		Timeout: internal.Duration{Duration: 100 * time.Millisecond},
+		Retries: 1,
+		Objects: []Object{
+			{Name: "supply_air_temperature", Type: "analog-input", Instance: 1},
+			{Type: "binary-value", Instance: 3},
+			{Type: "5", Instance: 3, Property: "status-flags"},
+			{Name: "missing", Type: "analog-value", Instance: 9},
+		},
+	}
+	var acc testutil.Accumulator
+	err := b.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), addr+": missing: unknown object")
+
+	acc.AssertContainsTaggedFields(t, "bacnet_ip",
+		map[string]interface{}{
+			"supply_air_temperature": 22.5,
+			"binary_value_3":         int64(1),
+			"5_3_status_flags":       int64(0),
+		},
+		map[string]string{"device": addr})
+}
+
+func TestGatherNoResponse(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	b := &BACnetIP{
+		Devices: []string{conn.LocalAddr().String()},
+		Timeout: internal.Duration{Duration: 50 * time.Millisecond},
+		Objects: []Object{
+			{Type: "analog-input", Instance: 1},
+			{Type: "analog-input", Instance: 2},
+		},
+	}
+	var acc testutil.Accumulator
+	start := time.Now()
+	assert.Error(t, b.Gather(&acc))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.False(t, acc.HasMeasurement("bacnet_ip"))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	b := &BACnetIP{
+		Devices: []string{"127.0.0.1"},
+		Objects: []Object{{Type: "analog-thing", Instance: 1}},
+	}
+	var acc testutil.Accumulator
+	assert.EqualError(t, b.Gather(&acc), "unknown object type 'analog-thing'")
+}
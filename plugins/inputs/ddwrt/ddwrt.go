@@ -0,0 +1,406 @@
+package ddwrt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/availability"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/internal/inventory"
+	"github.com/influxdata/telegraf/internal/rollover"
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// Number of values per entry of the active_wireless and dhcp_leases lists
+// of Info.live.htm.
+const (
+	wirelessStride = 9
+	leaseStride    = 5
+)
+
+var (
+	// item matches one {name::value} pair of Info.live.htm.
+	item = regexp.MustCompile(`\{(\w+)::([^}]*)\}`)
+	// quoted matches the elements of a list value such as mem_info.
+	quoted = regexp.MustCompile(`'([^']*)'`)
+
+	uptimeDays  = regexp.MustCompile(`up\s+(\d+)\s+days?`)
+	uptimeHours = regexp.MustCompile(`(\d+):(\d+),`)
+	uptimeMins  = regexp.MustCompile(`(\d+)\s+min`)
+	loadAverage = regexp.MustCompile(`load average:\s*([\d.]+),\s*([\d.]+),\s*([\d.]+)`)
+)
+
+// memFields maps the /proc/meminfo lines of mem_info to field names.
+var memFields = map[string]string{
+	"MemTotal:": "mem_total",
+	"MemFree:":  "mem_free",
+	"Buffers:":  "mem_buffers",
+	"Cached:":   "mem_cached",
+}
+
+// packetFields maps the counters of packet_info to field names.
+var packetFields = map[string]string{
+	"SWRXgoodPacket":  "rx_packets",
+	"SWRXerrorPacket": "rx_errors",
+	"SWTXgoodPacket":  "tx_packets",
+	"SWTXerrorPacket": "tx_errors",
+}
+
+// ifaceFields names the /proc/net/dev columns returned by fetchif.cgi.
+var ifaceFields = []string{
+	"rx_bytes", "rx_packets", "rx_errors", "rx_dropped",
+	"rx_fifo", "rx_frame", "rx_compressed", "rx_multicast",
+	"tx_bytes", "tx_packets", "tx_errors", "tx_dropped",
+	"tx_fifo", "tx_colls", "tx_carrier", "tx_compressed",
+}
+
+type DDWRT struct {
+	Servers    []string
+	Username   string
+	Password   string
+	Interfaces []string
+	Timeout    internal.Duration
+
+	// Path to a YAML or CSV file mapping MAC addresses to extra tags
+	InventoryFile string
+
+	SSLCA              string `toml:"ssl_ca"`
+	SSLCert            string `toml:"ssl_cert"`
+	SSLKey             string `toml:"ssl_key"`
+	InsecureSkipVerify bool
+
+	client    *devicehttp.Client
+	persister state.StatePersister
+	counters  *rollover.Cache
+	inventory *inventory.Inventory
+}
+
+var sampleConfig = `
+  ## Base URLs of the DD-WRT web interfaces
+  servers = ["http://192.168.1.1"]
+
+  ## Credentials of the web interface
+  username = "root"
+  password = "admin"
+
+  ## Interfaces to read traffic counters for, e.g. the WAN port (vlan2 on
+  ## most Broadcom routers), the LAN bridge and the wireless interface
+  interfaces = ["vlan2", "br0"]
+
+  ## Optional YAML or CSV file mapping station MAC addresses to extra tags,
+  ## such as a name or owner, added to ddwrt_wireless_client
+  # inventory_file = "/etc/telegraf/inventory.yaml"
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (d *DDWRT) SampleConfig() string {
+	return sampleConfig
+}
+
+func (d *DDWRT) Description() string {
+	return "Read system, wireless and traffic statistics from DD-WRT routers"
+}
+
+// SetStatePersister keeps the counter wrap offsets in the state directory of
+// the agent, so the totals continue across restarts.
+func (d *DDWRT) SetStatePersister(p state.StatePersister) {
+	d.persister = p
+}
+
+func (d *DDWRT) Gather(acc telegraf.Accumulator) error {
+	if d.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			Username:           d.Username,
+			Password:           d.Password,
+			SSLCA:              d.SSLCA,
+			SSLCert:            d.SSLCert,
+			SSLKey:             d.SSLKey,
+			InsecureSkipVerify: d.InsecureSkipVerify,
+			Timeout:            d.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		d.client = client
+	}
+
+	if d.InventoryFile != "" && d.inventory == nil {
+		inv, err := inventory.Load(d.InventoryFile)
+		if err != nil {
+			return err
+		}
+		d.inventory = inv
+	}
+
+	if d.counters == nil {
+		var persister rollover.Persister
+		if d.persister != nil {
+			persister = state.Bind(d.persister, "counters")
+		}
+		counters := rollover.NewCache(persister)
+		// the counters of the router start over after a reboot
+		counters.Threshold = rollover.RestartThreshold
+		if err := counters.Load(); err != nil {
+			return err
+		}
+		d.counters = counters
+	}
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(d.Servers) + 1)
+	wg.Add(len(d.Servers))
+	for _, server := range d.Servers {
+		go func(server string) {
+			defer wg.Done()
+			errChan.C <- d.gatherServer(server, acc)
+		}(server)
+	}
+
+	wg.Wait()
+	errChan.C <- d.counters.Save()
+	return errChan.Error()
+}
+
+func (d *DDWRT) gatherServer(server string, acc telegraf.Accumulator) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", server, err)
+	}
+	base := strings.TrimRight(server, "/")
+	tags := map[string]string{"server": u.Host}
+
+	err = d.gatherRouter(base, tags, acc)
+	availability.Add(acc, "ddwrt_system", tags, err)
+	return err
+}
+
+func (d *DDWRT) gatherRouter(base string, tags map[string]string, acc telegraf.Accumulator) error {
+	b, err := d.client.Get(base + "/Info.live.htm")
+	if err != nil {
+		return err
+	}
+	info := parseInfo(b)
+
+	fields := make(map[string]interface{})
+
+	if uptime, ok := parseUptime(info["uptime"]); ok {
+		fields["uptime"] = uptime
+	}
+	if m := loadAverage.FindStringSubmatch(info["uptime"]); m != nil {
+		for i, name := range []string{"load1", "load5", "load15"} {
+			if v, err := strconv.ParseFloat(m[i+1], 64); err == nil {
+				fields[name] = v
+			}
+		}
+	}
+
+	mem := listValues(info["mem_info"])
+	for i := 0; i+1 < len(mem); i++ {
+		if name, ok := memFields[mem[i]]; ok {
+			if v, err := strconv.ParseInt(mem[i+1], 10, 64); err == nil {
+				fields[name] = v * 1024
+			}
+		}
+	}
+
+	clients := listValues(info["active_wireless"])
+	fields["wireless_clients"] = len(clients) / wirelessStride
+	fields["dhcp_leases"] = len(listValues(info["dhcp_leases"])) / leaseStride
+	acc.AddFields("ddwrt_system", fields, tags)
+
+	d.gatherWireless(info, clients, tags, acc)
+
+	for _, iface := range d.Interfaces {
+		if err := d.gatherInterface(base, iface, tags, acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DDWRT) gatherWireless(
+	info map[string]string,
+	clients []string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) {
+	fields := make(map[string]interface{})
+	for _, counter := range strings.Split(info["packet_info"], ";") {
+		kv := strings.SplitN(counter, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name, ok := packetFields[strings.TrimSpace(kv[0])]
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseUint(strings.TrimSpace(kv[1]), 10, 64); err == nil {
+			fields[name] = d.counters.Compensate(tags["server"], "wireless."+name, v)
+		}
+	}
+	if channel, err := strconv.ParseInt(info["wl_channel"], 10, 64); err == nil {
+		fields["channel"] = channel
+	}
+	if len(fields) > 0 {
+		acc.AddFields("ddwrt_wireless", fields, tags)
+	}
+
+	// mac, interface, uptime, tx rate, rx rate, signal, noise, snr, quality
+	for i := 0; i+wirelessStride <= len(clients); i += wirelessStride {
+		client := clients[i : i+wirelessStride]
+		ctags := copyTags(tags)
+		ctags["mac"] = client[0]
+		ctags["interface"] = client[1]
+		d.inventory.Tag(ctags, client[0])
+
+		cfields := make(map[string]interface{})
+		for j, name := range []string{"signal", "noise", "snr", "quality"} {
+			if v, err := strconv.ParseInt(client[j+5], 10, 64); err == nil {
+				cfields[name] = v
+			}
+		}
+		if len(cfields) > 0 {
+			acc.AddFields("ddwrt_wireless_client", cfields, ctags)
+		}
+	}
+}
+
+// gatherInterface reads the /proc/net/dev line of an interface from
+// fetchif.cgi, which backs the traffic graphs of the web interface.
+func (d *DDWRT) gatherInterface(
+	base string,
+	iface string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	b, err := d.client.Get(base + "/fetchif.cgi?" + url.QueryEscape(iface))
+	if err != nil {
+		return err
+	}
+
+	counters, ok := parseInterface(b, iface)
+	if !ok {
+		return fmt.Errorf("no counters for interface %s on %s", iface, base)
+	}
+
+	fields := make(map[string]interface{})
+	for i, name := range ifaceFields {
+		if i >= len(counters) {
+			break
+		}
+		fields[name] = d.counters.Compensate(tags["server"], iface+"."+name, counters[i])
+	}
+
+	itags := copyTags(tags)
+	itags["interface"] = iface
+	acc.AddFields("ddwrt_interface", fields, itags)
+	return nil
+}
+
+// parseInfo returns the {name::value} pairs of Info.live.htm.
+func parseInfo(b []byte) map[string]string {
+	info := make(map[string]string)
+	for _, m := range item.FindAllSubmatch(b, -1) {
+		info[string(m[1])] = strings.TrimSpace(string(m[2]))
+	}
+	return info
+}
+
+// listValues returns the quoted elements of a list value, with surrounding
+// whitespace removed.
+func listValues(s string) []string {
+	var values []string
+	for _, m := range quoted.FindAllStringSubmatch(s, -1) {
+		values = append(values, strings.TrimSpace(m[1]))
+	}
+	return values
+}
+
+// parseUptime converts the output of the uptime command, e.g.
+// " 12:34:56 up 1 day,  2:03,  load average: 0.08, 0.03, 0.01", into
+// seconds.
+func parseUptime(s string) (int64, bool) {
+	i := strings.Index(s, " up ")
+	if i < 0 {
+		return 0, false
+	}
+	s = s[i:]
+	if j := strings.Index(s, "load average"); j >= 0 {
+		s = s[:j]
+	}
+
+	var uptime int64
+	found := false
+	if m := uptimeDays.FindStringSubmatch(s); m != nil {
+		days, _ := strconv.ParseInt(m[1], 10, 64)
+		uptime += days * 86400
+		found = true
+	}
+	if m := uptimeHours.FindStringSubmatch(s); m != nil {
+		hours, _ := strconv.ParseInt(m[1], 10, 64)
+		mins, _ := strconv.ParseInt(m[2], 10, 64)
+		uptime += hours*3600 + mins*60
+		found = true
+	} else if m := uptimeMins.FindStringSubmatch(s); m != nil {
+		mins, _ := strconv.ParseInt(m[1], 10, 64)
+		uptime += mins * 60
+		found = true
+	}
+	return uptime, found
+}
+
+// parseInterface finds the counters of iface in a /proc/net/dev style
+// response.
+func parseInterface(b []byte, iface string) ([]uint64, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != iface {
+			continue
+		}
+
+		var counters []uint64
+		for _, field := range strings.Fields(parts[1]) {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			counters = append(counters, v)
+		}
+		return counters, len(counters) > 0
+	}
+	return nil, false
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags)+2)
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+func init() {
+	inputs.Add("ddwrt", func() telegraf.Input {
+		return &DDWRT{}
+	})
+}
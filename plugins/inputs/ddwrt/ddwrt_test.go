@@ -0,0 +1,270 @@
+package ddwrt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const infoLive = `{lan_mac::00:11:22:33:44:55}
+{lan_ip::192.168.1.1}
+{wl_channel::6}
+{wl_radio::Radio is On}
+{packet_info::SWRXgoodPacket=%d;SWRXerrorPacket=2;SWTXgoodPacket=3000;SWTXerrorPacket=0;}
+{mem_info::'MemTotal:','   126844','kB','MemFree:','    86584','kB','MemShared:','0','kB','Buffers:','4048','kB','Cached:','14584','kB'}
+{active_wireless::'AA:BB:CC:DD:EE:FF','eth1','1:02:03','54M','48M','-60','-95','35','700'}
+{dhcp_leases:: 'laptop','192.168.1.100','AA:BB:CC:DD:EE:FF','1 day 00:00:00','100','phone','192.168.1.101','11:22:33:44:55:66','23:59:00','101'}
+{uptime:: 12:34:56 up %s,  load average: 0.08, 0.03, 0.50}
+{ipinfo::&nbsp;IP: 10.0.0.2}`
+
+const fetchIf = `Sun Oct 16 12:34:56 UTC 2016
+  vlan2:%d 1000 1 2 0 0 0 5 2000000 1500 0 0 0 0 0 0`
+
+type fakeRouter struct {
+	uptime    string
+	rxPackets uint64
+	rxBytes   uint64
+}
+
+func (f *fakeRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "root" || pass != "admin" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/Info.live.htm":
+		fmt.Fprintf(w, infoLive, f.rxPackets, f.uptime)
+	case "/fetchif.cgi":
+		if r.URL.RawQuery != "vlan2" {
+			fmt.Fprintln(w, "Sun Oct 16 12:34:56 UTC 2016")
+			return
+		}
+		fmt.Fprintf(w, fetchIf, f.rxBytes)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newDDWRT(server string) *DDWRT {
+	return &DDWRT{
+		Servers:    []string{server},
+		Username:   "root",
+		Password:   "admin",
+		Interfaces: []string{"vlan2"},
+	}
+}
+
+func TestGather(t *testing.T) {
+	router := &fakeRouter{uptime: "1 day,  2:03", rxPackets: 1000, rxBytes: 5000000}
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	d := newDDWRT(ts.URL)
+	var acc testutil.Accumulator
+	require.NoError(t, d.Gather(&acc))
+
+	tags := map[string]string{"server": u.Host}
+	acc.AssertContainsTaggedFields(t, "ddwrt_system",
+		map[string]interface{}{
+			"uptime":           int64(93780),
+			"load1":            0.08,
+			"load5":            0.03,
+			"load15":           0.5,
+			"mem_total":        int64(126844 * 1024),
+			"mem_free":         int64(86584 * 1024),
+			"mem_buffers":      int64(4048 * 1024),
+			"mem_cached":       int64(14584 * 1024),
+			"wireless_clients": 1,
+			"dhcp_leases":      2,
+		}, tags)
+
+	// the system and the up point share their tags
+	var up []interface{}
+	for _, m := range acc.Metrics {
+		if m.Measurement == "ddwrt_system" && m.Fields["up"] != nil {
+			up = append(up, m.Fields["up"])
+		}
+	}
+	assert.Equal(t, []interface{}{1}, up)
+
+	acc.AssertContainsTaggedFields(t, "ddwrt_wireless",
+		map[string]interface{}{
+			"rx_packets": uint64(1000),
+			"rx_errors":  uint64(2),
+			"tx_packets": uint64(3000),
+			"tx_errors":  uint64(0),
+			"channel":    int64(6),
+		}, tags)
+
+	acc.AssertContainsTaggedFields(t, "ddwrt_wireless_client",
+		map[string]interface{}{
+			"signal":  int64(-60),
+			"noise":   int64(-95),
+			"snr":     int64(35),
+			"quality": int64(700),
+		},
+		map[string]string{
+			"server":    u.Host,
+			"mac":       "AA:BB:CC:DD:EE:FF",
+			"interface": "eth1",
+		})
+
+	acc.AssertContainsTaggedFields(t, "ddwrt_interface",
+		map[string]interface{}{
+			"rx_bytes":      uint64(5000000),
+			"rx_packets":    uint64(1000),
+			"rx_errors":     uint64(1),
+			"rx_dropped":    uint64(2),
+			"rx_fifo":       uint64(0),
+			"rx_frame":      uint64(0),
+			"rx_compressed": uint64(0),
+			"rx_multicast":  uint64(5),
+			"tx_bytes":      uint64(2000000),
+			"tx_packets":    uint64(1500),
+			"tx_errors":     uint64(0),
+			"tx_dropped":    uint64(0),
+			"tx_fifo":       uint64(0),
+			"tx_colls":      uint64(0),
+			"tx_carrier":    uint64(0),
+			"tx_compressed": uint64(0),
+		},
+		map[string]string{"server": u.Host, "interface": "vlan2"})
+}
+
+func TestCounterWrap(t *testing.T) {
+	router := &fakeRouter{uptime: "5 min", rxPackets: 4294967000, rxBytes: 4294967000}
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ddwrt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := newDDWRT(ts.URL)
+	d.SetStatePersister(&state.DirPersister{Dir: dir})
+	var acc testutil.Accumulator
+	require.NoError(t, d.Gather(&acc))
+
+	router.uptime = "10 min"
+	router.rxBytes = 1000
+	router.rxPackets = 10
+	acc = testutil.Accumulator{}
+	require.NoError(t, d.Gather(&acc))
+
+	rx, ok := acc.Get("ddwrt_interface")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4294967296+1000), rx.Fields["rx_bytes"])
+	wl, ok := acc.Get("ddwrt_wireless")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4294967296+10), wl.Fields["rx_packets"])
+
+	// a new instance continues from the state file
+	d = newDDWRT(ts.URL)
+	d.SetStatePersister(&state.DirPersister{Dir: dir})
+	acc = testutil.Accumulator{}
+	require.NoError(t, d.Gather(&acc))
+	rx, ok = acc.Get("ddwrt_interface")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4294967296+1000), rx.Fields["rx_bytes"])
+
+	// after a reboot the counters start over
+	router.uptime = "1 min"
+	router.rxBytes = 500
+	acc = testutil.Accumulator{}
+	require.NoError(t, d.Gather(&acc))
+	rx, ok = acc.Get("ddwrt_interface")
+	require.True(t, ok)
+	assert.Equal(t, uint64(500), rx.Fields["rx_bytes"])
+}
+
+func TestRebootWhileStopped(t *testing.T) {
+	router := &fakeRouter{uptime: "3 days", rxPackets: 100000, rxBytes: 2000000000}
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ddwrt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := newDDWRT(ts.URL)
+	d.SetStatePersister(&state.DirPersister{Dir: dir})
+	var acc testutil.Accumulator
+	require.NoError(t, d.Gather(&acc))
+
+	// the router reboots before the next start of the agent
+	router.uptime = "2 min"
+	router.rxBytes = 5000
+	router.rxPackets = 20
+	d = newDDWRT(ts.URL)
+	d.SetStatePersister(&state.DirPersister{Dir: dir})
+	acc = testutil.Accumulator{}
+	require.NoError(t, d.Gather(&acc))
+
+	rx, ok := acc.Get("ddwrt_interface")
+	require.True(t, ok)
+	assert.Equal(t, uint64(5000), rx.Fields["rx_bytes"])
+	wl, ok := acc.Get("ddwrt_wireless")
+	require.True(t, ok)
+	assert.Equal(t, uint64(20), wl.Fields["rx_packets"])
+}
+
+func TestMissingInterface(t *testing.T) {
+	ts := httptest.NewServer(&fakeRouter{uptime: "5 min"})
+	defer ts.Close()
+
+	d := newDDWRT(ts.URL)
+	d.Interfaces = []string{"eth9"}
+	var acc testutil.Accumulator
+	assert.Error(t, d.Gather(&acc))
+	assert.True(t, acc.HasMeasurement("ddwrt_system"))
+}
+
+func TestUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(&fakeRouter{uptime: "5 min"})
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	d := newDDWRT(ts.URL)
+	d.Password = "wrong"
+	var acc testutil.Accumulator
+	assert.Error(t, d.Gather(&acc))
+	assert.Equal(t, 1, len(acc.Metrics))
+	acc.AssertContainsTaggedFields(t, "ddwrt_system",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": u.Host, "reason": "unauthorized"})
+}
+
+func TestParseUptime(t *testing.T) {
+	tests := []struct {
+		in     string
+		uptime int64
+	}{
+		{" 12:34:56 up 5 min,  load average: 0.00, 0.00, 0.00", 300},
+		{" 12:34:56 up  2:03,  load average: 0.00, 0.00, 0.00", 7380},
+		{" 12:34:56 up 3 days, 12 min,  load average: 0.00, 0.00, 0.00", 259920},
+		{" 12:34:56 up 1 day,  2:03,  load average: 0.00, 0.00, 0.00", 93780},
+	}
+	for _, tt := range tests {
+		uptime, ok := parseUptime(tt.in)
+		assert.True(t, ok, tt.in)
+		assert.Equal(t, tt.uptime, uptime, tt.in)
+	}
+
+	_, ok := parseUptime("")
+	assert.False(t, ok)
+}
@@ -0,0 +1,363 @@
+package dlms_cosem
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// aarq requests an association with logical name referencing and no
+// security, proposing the get service and a maximum PDU size of 1200.
+var aarq = []byte{
+	0x60, 0x1d,
+	// application context name: LN referencing, no ciphering
+	0xa1, 0x09, 0x06, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x01, 0x01,
+	// user information: xDLMS InitiateRequest
+	0xbe, 0x10, 0x04, 0x0e,
+	0x01, 0x00, 0x00, 0x00, 0x06, 0x5f, 0x1f, 0x04, 0x00, 0x00, 0x7e, 0x1f,
+	0x04, 0xb0,
+}
+
+// rlrq releases the association with reason normal.
+var rlrq = []byte{0x62, 0x03, 0x80, 0x01, 0x00}
+
+const (
+	tagAARE        = 0x61
+	tagGetRequest  = 0xc0
+	tagGetResponse = 0xc4
+
+	// invokeIDAndPriority asks for a confirmed, high priority request
+	// with invoke id 1.
+	invokeIDAndPriority = 0xc1
+)
+
+// Obis is an OBIS code identifying a COSEM object.
+type Obis [6]byte
+
+// ParseObis accepts the usual notations of OBIS codes, "1-0:1.8.0.255",
+// "1.0.1.8.0.255" or "1-0:1.8.0" (the last group defaulting to 255).
+func ParseObis(s string) (Obis, error) {
+	var o Obis
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '-' || r == ':' || r == '.' || r == '*'
+	})
+	if len(parts) == 5 {
+		parts = append(parts, "255")
+	}
+	if len(parts) != 6 {
+		return o, fmt.Errorf("invalid OBIS code '%s'", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return o, fmt.Errorf("invalid OBIS code '%s'", s)
+		}
+		o[i] = byte(v)
+	}
+	return o, nil
+}
+
+func (o Obis) String() string {
+	return fmt.Sprintf("%d-%d:%d.%d.%d.%d", o[0], o[1], o[2], o[3], o[4], o[5])
+}
+
+// wrapper frames APDUs with the DLMS/COSEM TCP wrapper header of IEC
+// 62056-47.
+type wrapper struct {
+	conn   io.ReadWriter
+	client uint16
+	server uint16
+}
+
+func (w *wrapper) send(apdu []byte) error {
+	b := make([]byte, 8, 8+len(apdu))
+	binary.BigEndian.PutUint16(b[0:], 1)
+	binary.BigEndian.PutUint16(b[2:], w.client)
+	binary.BigEndian.PutUint16(b[4:], w.server)
+	binary.BigEndian.PutUint16(b[6:], uint16(len(apdu)))
+	_, err := w.conn.Write(append(b, apdu...))
+	return err
+}
+
+func (w *wrapper) receive() ([]byte, error) {
+	h := make([]byte, 8)
+	if _, err := io.ReadFull(w.conn, h); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(h) != 1 {
+		return nil, errors.New("unsupported wrapper version")
+	}
+	b := make([]byte, binary.BigEndian.Uint16(h[6:]))
+	if _, err := io.ReadFull(w.conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (w *wrapper) request(apdu []byte) ([]byte, error) {
+	if err := w.send(apdu); err != nil {
+		return nil, err
+	}
+	return w.receive()
+}
+
+// associate opens an association and checks the meter accepted it.
+func (w *wrapper) associate() error {
+	b, err := w.request(aarq)
+	if err != nil {
+		return err
+	}
+	if len(b) < 2 || b[0] != tagAARE {
+		return errors.New("unexpected response to association request")
+	}
+
+	result, diagnostic := -1, -1
+	d := &decoder{b: b[2:]}
+	for len(d.b) >= 2 {
+		tag := d.b[0]
+		d.b = d.b[1:]
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		v, err := d.take(n)
+		if err != nil {
+			return err
+		}
+		switch {
+		// result: INTEGER
+		case tag == 0xa2 && len(v) == 3:
+			result = int(v[2])
+		// result source diagnostic: CHOICE of INTEGER
+		case tag == 0xa3 && len(v) == 5:
+			diagnostic = int(v[4])
+		}
+	}
+	if result != 0 {
+		return fmt.Errorf("association rejected (result %d, diagnostic %d)",
+			result, diagnostic)
+	}
+	return nil
+}
+
+// release ends the association.
+func (w *wrapper) release() error {
+	_, err := w.request(rlrq)
+	return err
+}
+
+// DataAccessError is returned when the meter refuses to return an attribute.
+type DataAccessError byte
+
+func (e DataAccessError) Error() string {
+	switch e {
+	case 3:
+		return "read denied"
+	case 4:
+		return "object undefined"
+	case 9:
+		return "object unavailable"
+	case 11:
+		return "scope of access violated"
+	}
+	return fmt.Sprintf("data access error %d", byte(e))
+}
+
+// get reads an attribute of a COSEM object.
+func (w *wrapper) get(classID uint16, obis Obis, attribute byte) (interface{}, error) {
+	req := []byte{tagGetRequest, 0x01, invokeIDAndPriority, 0, 0}
+	binary.BigEndian.PutUint16(req[3:], classID)
+	req = append(req, obis[:]...)
+	req = append(req, attribute, 0x00)
+
+	b, err := w.request(req)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGetResponse(b)
+}
+
+func decodeGetResponse(b []byte) (interface{}, error) {
+	if len(b) < 4 || b[0] != tagGetResponse {
+		return nil, errors.New("unexpected response to get request")
+	}
+	if b[1] != 0x01 {
+		return nil, errors.New("block transfer is not supported")
+	}
+	switch b[3] {
+	case 0x00:
+		d := &decoder{b: b[4:]}
+		return d.data()
+	case 0x01:
+		if len(b) < 5 {
+			return nil, errors.New("short get response")
+		}
+		return nil, DataAccessError(b[4])
+	}
+	return nil, errors.New("invalid get response")
+}
+
+// decoder reads A-XDR encoded data.
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || len(d.b) < n {
+		return nil, errors.New("truncated data")
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) length() (int, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, err
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), nil
+	}
+	n := int(b[0] & 0x7f)
+	if n > 4 {
+		return 0, errors.New("invalid length")
+	}
+	b, err = d.take(n)
+	if err != nil {
+		return 0, err
+	}
+	var l int
+	for _, c := range b {
+		l = l<<8 | int(c)
+	}
+	return l, nil
+}
+
+// data decodes a value. Integers become int64 (uint64 only if they exceed
+// its range), octet strings become a string if printable and hex otherwise,
+// arrays and structures become []interface{}.
+func (d *decoder) data() (interface{}, error) {
+	t, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t[0] {
+	case 0x00:
+		return nil, nil
+	case 0x01, 0x02:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for i := 0; i < n; i++ {
+			v, err := d.data()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case 0x03:
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case 0x05:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 0x06:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint32(b)), nil
+	case 0x09, 0x0a, 0x0c:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 0x09 && !printable(b) {
+			return hex.EncodeToString(b), nil
+		}
+		return string(b), nil
+	case 0x0f:
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int8(b[0])), nil
+	case 0x10:
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 0x11, 0x16:
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(b[0]), nil
+	case 0x12:
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint16(b)), nil
+	case 0x14:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 0x15:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		v := binary.BigEndian.Uint64(b)
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 0x17:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0x18:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("unsupported data type %d", t[0])
+}
+
+func printable(b []byte) bool {
+	for _, c := range b {
+		if c > unicode.MaxASCII || !unicode.IsPrint(rune(c)) {
+			return false
+		}
+	}
+	return true
+}
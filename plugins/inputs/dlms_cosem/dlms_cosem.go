@@ -0,0 +1,256 @@
+package dlms_cosem
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const defaultPort = "4059"
+
+// COSEM interface classes with a scaler_unit attribute.
+const (
+	classRegister         = 3
+	classExtendedRegister = 4
+)
+
+type DLMSCOSEM struct {
+	Meters        []string
+	ClientAddress int `toml:"client_address"`
+	ServerAddress int `toml:"server_address"`
+	Timeout       internal.Duration
+	Registers     []Register `toml:"register"`
+
+	sync.Mutex
+	// scalers caches the scaler of registers by meter and OBIS code, it
+	// only changes with the configuration of the meter.
+	scalers map[string]float64
+}
+
+// Register is a COSEM object whose value is read from every meter.
+type Register struct {
+	// Name is the field name, by default the OBIS code.
+	Name    string
+	Obis    string
+	ClassID int `toml:"class_id"`
+}
+
+var sampleConfig = `
+  ## Meters to poll through the DLMS/COSEM TCP wrapper, as "host" or
+  ## "host:port" (default port 4059)
+  meters = ["192.168.1.60"]
+
+  ## Wrapper port of the client and the logical device in the meter. The
+  ## public client (16) usually reads the billing registers without
+  ## authentication.
+  # client_address = 16
+  # server_address = 1
+
+  ## Timeout for connecting to and reading from each meter
+  timeout = "5s"
+
+  ## Objects to read, by OBIS code. class_id is the COSEM interface class,
+  ## 3 (register) by default. The values of registers (3) and extended
+  ## registers (4) are scaled to their unit.
+  [[inputs.dlms_cosem.register]]
+    name = "energy_import"
+    obis = "1-0:1.8.0.255"
+
+  [[inputs.dlms_cosem.register]]
+    name = "power_import"
+    obis = "1-0:1.7.0.255"
+
+  [[inputs.dlms_cosem.register]]
+    name = "serial_number"
+    obis = "0-0:96.1.0.255"
+    class_id = 1
+`
+
+func (d *DLMSCOSEM) SampleConfig() string {
+	return sampleConfig
+}
+
+func (d *DLMSCOSEM) Description() string {
+	return "Read registers from DLMS/COSEM smart meters by OBIS code"
+}
+
+// register is a configured register with its OBIS code parsed.
+type register struct {
+	field   string
+	obis    Obis
+	classID uint16
+}
+
+func (d *DLMSCOSEM) registers() ([]register, error) {
+	var registers []register
+	for _, r := range d.Registers {
+		obis, err := ParseObis(r.Obis)
+		if err != nil {
+			return nil, err
+		}
+		classID := r.ClassID
+		if classID == 0 {
+			classID = classRegister
+		}
+		field := r.Name
+		if field == "" {
+			field = strings.Replace(obis.String(), "-", "_", -1)
+			field = strings.NewReplacer(":", "_", ".", "_").Replace(field)
+		}
+		registers = append(registers, register{
+			field:   field,
+			obis:    obis,
+			classID: uint16(classID),
+		})
+	}
+	return registers, nil
+}
+
+func (d *DLMSCOSEM) Gather(acc telegraf.Accumulator) error {
+	registers, err := d.registers()
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	if d.scalers == nil {
+		d.scalers = make(map[string]float64)
+	}
+	d.Unlock()
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(d.Meters))
+	wg.Add(len(d.Meters))
+	for _, meter := range d.Meters {
+		go func(meter string) {
+			defer wg.Done()
+			errChan.C <- d.gatherMeter(meter, registers, acc)
+		}(meter)
+	}
+
+	wg.Wait()
+	return errChan.Error()
+}
+
+func (d *DLMSCOSEM) gatherMeter(meter string, registers []register, acc telegraf.Accumulator) error {
+	if _, _, err := net.SplitHostPort(meter); err != nil {
+		meter = net.JoinHostPort(meter, defaultPort)
+	}
+
+	timeout := d.Timeout.Duration
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", meter, timeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %s", meter, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	w := &wrapper{
+		conn:   conn,
+		client: uint16(d.ClientAddress),
+		server: uint16(d.ServerAddress),
+	}
+	if w.client == 0 {
+		w.client = 16
+	}
+	if w.server == 0 {
+		w.server = 1
+	}
+	if err := w.associate(); err != nil {
+		return fmt.Errorf("%s: %s", meter, err)
+	}
+
+	fields := make(map[string]interface{})
+	var failed []string
+	for _, r := range registers {
+		value, err := d.read(w, meter, r)
+		if err != nil {
+			if _, ok := err.(DataAccessError); !ok {
+				return fmt.Errorf("%s: %s: %s", meter, r.obis, err)
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", r.obis, err))
+			continue
+		}
+		if value != nil {
+			fields[r.field] = value
+		}
+	}
+	w.release()
+
+	if len(fields) > 0 {
+		acc.AddFields("dlms_cosem", fields, map[string]string{"meter": meter})
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %s", meter, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// read returns the value of a register, scaled to its unit for registers
+// and extended registers.
+func (d *DLMSCOSEM) read(w *wrapper, meter string, r register) (interface{}, error) {
+	value, err := w.get(r.classID, r.obis, 2)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.([]interface{}); ok {
+		return nil, fmt.Errorf("structured values are not supported")
+	}
+	if r.classID != classRegister && r.classID != classExtendedRegister {
+		return value, nil
+	}
+
+	key := meter + "/" + r.obis.String()
+	d.Lock()
+	scaler, ok := d.scalers[key]
+	d.Unlock()
+	if !ok {
+		scalerUnit, err := w.get(r.classID, r.obis, 3)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := scalerUnit.([]interface{})
+		if !ok || len(s) != 2 {
+			return nil, fmt.Errorf("invalid scaler_unit")
+		}
+		exponent, ok := s[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("invalid scaler_unit")
+		}
+		scaler = math.Pow10(int(exponent))
+		d.Lock()
+		d.scalers[key] = scaler
+		d.Unlock()
+	}
+
+	switch v := value.(type) {
+	case int64:
+		return float64(v) * scaler, nil
+	case uint64:
+		return float64(v) * scaler, nil
+	case float64:
+		return v * scaler, nil
+	}
+	return value, nil
+}
+
+func init() {
+	inputs.Add("dlms_cosem", func() telegraf.Input {
+		return &DLMSCOSEM{
+			ClientAddress: 16,
+			ServerAddress: 1,
+			Timeout:       internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package dlms_cosem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aare accepts an association.
+var aare = []byte{
+	0x61, 0x29,
+	0xa1, 0x09, 0x06, 0x07, 0x60, 0x85, 0x74, 0x05, 0x08, 0x01, 0x01,
+	0xa2, 0x03, 0x02, 0x01, 0x00,
+	0xa3, 0x05, 0xa1, 0x03, 0x02, 0x01, 0x00,
+	0xbe, 0x10, 0x04, 0x0e, 0x08, 0x00, 0x06, 0x5f, 0x1f, 0x04, 0x00, 0x00,
+	0x10, 0x1d, 0x04, 0x00, 0x00, 0x07,
+}
+
+// meterObjects are the attributes of the fake meter, by class, OBIS code
+// and attribute, with their encoded values.
+var meterObjects = map[string][]byte{
+	// energy import: 12345678 with scaler -1 and unit Wh
+	"3/1.0.1.8.0.255/2": {0x06, 0x00, 0xbc, 0x61, 0x4e},
+	"3/1.0.1.8.0.255/3": {0x02, 0x02, 0x0f, 0xff, 0x16, 0x1e},
+	// power import: 1500 with scaler 0 and unit W
+	"3/1.0.1.7.0.255/2": {0x12, 0x05, 0xdc},
+	"3/1.0.1.7.0.255/3": {0x02, 0x02, 0x0f, 0x00, 0x16, 0x1b},
+	// serial number
+	"1/0.0.96.1.0.255/2": {0x09, 0x08, '1', 'E', 'S', 'Y', '1', '2', '3', '4'},
+}
+
+func fakeMeter(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveMeter(t, conn)
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func serveMeter(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	w := &wrapper{conn: conn, client: 1, server: 16}
+	for {
+		b, err := w.receive()
+		if err != nil {
+			return
+		}
+		switch b[0] {
+		case 0x60:
+			assert.Equal(t, aarq, b)
+			w.send(aare)
+		case 0x62:
+			w.send([]byte{0x63, 0x03, 0x80, 0x01, 0x00})
+			return
+		case tagGetRequest:
+			classID := binary.BigEndian.Uint16(b[3:])
+			var obis Obis
+			copy(obis[:], b[5:11])
+			key := fmt.Sprintf("%d/%d.%d.%d.%d.%d.%d/%d", classID,
+				obis[0], obis[1], obis[2], obis[3], obis[4], obis[5], b[11])
+			resp := []byte{tagGetResponse, 0x01, b[2]}
+			if v, ok := meterObjects[key]; ok {
+				resp = append(append(resp, 0x00), v...)
+			} else {
+				resp = append(resp, 0x01, 0x04)
+			}
+			w.send(resp)
+		}
+	}
+}
+
+func TestParseObis(t *testing.T) {
+	for _, s := range []string{"1-0:1.8.0.255", "1.0.1.8.0.255", "1-0:1.8.0", "1-0:1.8.0*255"} {
+		o, err := ParseObis(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, Obis{1, 0, 1, 8, 0, 255}, o)
+	}
+	assert.Equal(t, "1-0:1.8.0.255", Obis{1, 0, 1, 8, 0, 255}.String())
+
+	_, err := ParseObis("1-0:1.8")
+	assert.Error(t, err)
+	_, err = ParseObis("1-0:1.8.0.256")
+	assert.Error(t, err)
+}
+
+func TestDecodeData(t *testing.T) {
+	tests := []struct {
+		b        []byte
+		expected interface{}
+	}{
+		{[]byte{0x05, 0xff, 0xff, 0xff, 0xfe}, int64(-2)},
+		{[]byte{0x10, 0xff, 0x38}, int64(-200)},
+		{[]byte{0x15, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(1<<64 - 1)},
+		{[]byte{0x17, 0x41, 0xb4, 0x00, 0x00}, 22.5},
+		{[]byte{0x03, 0x01}, true},
+		{[]byte{0x09, 0x03, 0x01, 0x02, 0xff}, "0102ff"},
+		{[]byte{0x0a, 0x02, 'o', 'k'}, "ok"},
+		{[]byte{0x00}, nil},
+		{[]byte{0x02, 0x02, 0x0f, 0xfd, 0x16, 0x1e}, []interface{}{int64(-3), int64(30)}},
+	}
+	for _, test := range tests {
+		d := &decoder{b: test.b}
+		v, err := d.data()
+		require.NoError(t, err, "%x", test.b)
+		assert.Equal(t, test.expected, v, "%x", test.b)
+	}
+
+	d := &decoder{b: []byte{0x06, 0x00}}
+	_, err := d.data()
+	assert.Error(t, err)
+
+	_, err = decodeGetResponse([]byte{0xc4, 0x01, 0xc1, 0x01, 0x03})
+	assert.Equal(t, DataAccessError(3), err)
+	_, err = decodeGetResponse([]byte{0xc4, 0x02, 0xc1, 0x00})
+	assert.EqualError(t, err, "block transfer is not supported")
+}
+
+func TestGather(t *testing.T) {
+	addr, stop := fakeMeter(t)
+	defer stop()
+
+	d := &DLMSCOSEM{
+		Meters:  []string{addr},
+		Timeout: internal.Duration{Duration: time.Second},
+		Registers: []Register{
+			{Name: "energy_import", Obis: "1-0:1.8.0.255"},
+			{Obis: "1-0:1.7.0"},
+			{Name: "serial_number", Obis: "0-0:96.1.0.255", ClassID: 1},
+			{Name: "missing", Obis: "1-0:2.8.0.255"},
+		},
+	}
+	var acc testutil.Accumulator
+	err := d.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1-0:2.8.0.255: object undefined")
+
+	acc.AssertContainsTaggedFields(t, "dlms_cosem",
+		map[string]interface{}{
+			"energy_import": 1234567.8,
+			"1_0_1_7_0_255": float64(1500),
+			"serial_number": "1ESY1234",
+		},
+		map[string]string{"meter": addr})
+
+	// the scalers are only read once
+	assert.Len(t, d.scalers, 2)
+	acc = testutil.Accumulator{}
+	d.Gather(&acc)
+	assert.True(t, acc.HasMeasurement("dlms_cosem"))
+}
+
+func TestAssociationRejected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		w := &wrapper{conn: conn, client: 1, server: 16}
+		w.receive()
+		rejected := append([]byte{}, aare...)
+		// permanent rejection, authentication required
+		rejected[17], rejected[24] = 0x01, 0x0d
+		w.send(rejected)
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	d := &DLMSCOSEM{
+		Meters:    []string{l.Addr().String()},
+		Registers: []Register{{Obis: "1-0:1.8.0"}},
+	}
+	var acc testutil.Accumulator
+	err = d.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "association rejected (result 1, diagnostic 13)")
+}
@@ -0,0 +1,332 @@
+package dnp3
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Application layer function codes.
+const (
+	funcConfirm             = 0x00
+	funcRead                = 0x01
+	funcResponse            = 0x81
+	funcUnsolicitedResponse = 0x82
+)
+
+// Application control bits.
+const (
+	appFir = 0x80
+	appFin = 0x40
+	appCon = 0x20
+	appUns = 0x10
+)
+
+// readClasses builds a read request for the given class data objects
+// (group 60), 0 requesting the static data of all points.
+func readClasses(seq byte, classes []int) []byte {
+	b := []byte{appFir | appFin | seq&0x0f, funcRead}
+	for _, class := range classes {
+		b = append(b, 60, byte(class+1), 0x06)
+	}
+	return b
+}
+
+// confirm acknowledges a response fragment.
+func confirm(control byte) []byte {
+	return []byte{appFir | appFin | control&(appUns|0x0f), funcConfirm}
+}
+
+// Point is the value of a point reported by an outstation.
+type Point struct {
+	Type  string
+	Index uint32
+	Value interface{}
+	// Flags holds the quality flags, for objects that have them.
+	Flags    byte
+	HasFlags bool
+}
+
+// response is an application fragment sent by an outstation.
+type response struct {
+	control  byte
+	function byte
+	iin      uint16
+	points   []Point
+}
+
+// objectType describes how to decode an object of a group and variation.
+// Objects of an empty kind are skipped.
+type objectType struct {
+	kind string
+	// size of the object in octets, or, if bits is set, in bits
+	size   int
+	bits   bool
+	decode func(b []byte) (interface{}, byte, bool)
+}
+
+func state(b []byte) (interface{}, byte, bool) { return b[0]&0x80 != 0, b[0], true }
+func doubleBit(b []byte) (interface{}, byte, bool) {
+	return int64(b[0] >> 6), b[0], true
+}
+func flagsInt32(b []byte) (interface{}, byte, bool) {
+	return int64(int32(binary.LittleEndian.Uint32(b[1:]))), b[0], true
+}
+func flagsInt16(b []byte) (interface{}, byte, bool) {
+	return int64(int16(binary.LittleEndian.Uint16(b[1:]))), b[0], true
+}
+func flagsUint32(b []byte) (interface{}, byte, bool) {
+	return int64(binary.LittleEndian.Uint32(b[1:])), b[0], true
+}
+func flagsUint16(b []byte) (interface{}, byte, bool) {
+	return int64(binary.LittleEndian.Uint16(b[1:])), b[0], true
+}
+func flagsFloat32(b []byte) (interface{}, byte, bool) {
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[1:]))), b[0], true
+}
+func flagsFloat64(b []byte) (interface{}, byte, bool) {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[1:])), b[0], true
+}
+func int32Value(b []byte) (interface{}, byte, bool) {
+	return int64(int32(binary.LittleEndian.Uint32(b))), 0, false
+}
+func int16Value(b []byte) (interface{}, byte, bool) {
+	return int64(int16(binary.LittleEndian.Uint16(b))), 0, false
+}
+func uint32Value(b []byte) (interface{}, byte, bool) {
+	return int64(binary.LittleEndian.Uint32(b)), 0, false
+}
+func uint16Value(b []byte) (interface{}, byte, bool) {
+	return int64(binary.LittleEndian.Uint16(b)), 0, false
+}
+
+// objectTypes lists the supported objects by group and variation. Events
+// carry the same value as their static counterpart, optionally followed by
+// a time that is not decoded.
+var objectTypes = map[[2]byte]objectType{
+	// binary inputs and events
+	{1, 1}: {kind: "binary_input", size: 1, bits: true},
+	{1, 2}: {"binary_input", 1, false, state},
+	{2, 1}: {"binary_input", 1, false, state},
+	{2, 2}: {"binary_input", 7, false, state},
+	{2, 3}: {"binary_input", 3, false, state},
+	// double-bit binary inputs and events
+	{3, 1}: {kind: "double_bit_input", size: 2, bits: true},
+	{3, 2}: {"double_bit_input", 1, false, doubleBit},
+	{4, 1}: {"double_bit_input", 1, false, doubleBit},
+	{4, 2}: {"double_bit_input", 7, false, doubleBit},
+	{4, 3}: {"double_bit_input", 3, false, doubleBit},
+	// binary output status and events
+	{10, 1}: {kind: "binary_output", size: 1, bits: true},
+	{10, 2}: {"binary_output", 1, false, state},
+	{11, 1}: {"binary_output", 1, false, state},
+	{11, 2}: {"binary_output", 7, false, state},
+	// counters and events
+	{20, 1}: {"counter", 5, false, flagsUint32},
+	{20, 2}: {"counter", 3, false, flagsUint16},
+	{20, 5}: {"counter", 4, false, uint32Value},
+	{20, 6}: {"counter", 2, false, uint16Value},
+	{22, 1}: {"counter", 5, false, flagsUint32},
+	{22, 2}: {"counter", 3, false, flagsUint16},
+	{22, 5}: {"counter", 11, false, flagsUint32},
+	{22, 6}: {"counter", 9, false, flagsUint16},
+	// frozen counters and events
+	{21, 1}:  {"frozen_counter", 5, false, flagsUint32},
+	{21, 2}:  {"frozen_counter", 3, false, flagsUint16},
+	{21, 5}:  {"frozen_counter", 11, false, flagsUint32},
+	{21, 6}:  {"frozen_counter", 9, false, flagsUint16},
+	{21, 9}:  {"frozen_counter", 4, false, uint32Value},
+	{21, 10}: {"frozen_counter", 2, false, uint16Value},
+	{23, 1}:  {"frozen_counter", 5, false, flagsUint32},
+	{23, 2}:  {"frozen_counter", 3, false, flagsUint16},
+	{23, 5}:  {"frozen_counter", 11, false, flagsUint32},
+	{23, 6}:  {"frozen_counter", 9, false, flagsUint16},
+	// analog inputs and events
+	{30, 1}: {"analog_input", 5, false, flagsInt32},
+	{30, 2}: {"analog_input", 3, false, flagsInt16},
+	{30, 3}: {"analog_input", 4, false, int32Value},
+	{30, 4}: {"analog_input", 2, false, int16Value},
+	{30, 5}: {"analog_input", 5, false, flagsFloat32},
+	{30, 6}: {"analog_input", 9, false, flagsFloat64},
+	{32, 1}: {"analog_input", 5, false, flagsInt32},
+	{32, 2}: {"analog_input", 3, false, flagsInt16},
+	{32, 3}: {"analog_input", 11, false, flagsInt32},
+	{32, 4}: {"analog_input", 9, false, flagsInt16},
+	{32, 5}: {"analog_input", 5, false, flagsFloat32},
+	{32, 6}: {"analog_input", 9, false, flagsFloat64},
+	{32, 7}: {"analog_input", 11, false, flagsFloat32},
+	{32, 8}: {"analog_input", 15, false, flagsFloat64},
+	// analog output status and events
+	{40, 1}: {"analog_output", 5, false, flagsInt32},
+	{40, 2}: {"analog_output", 3, false, flagsInt16},
+	{40, 3}: {"analog_output", 5, false, flagsFloat32},
+	{40, 4}: {"analog_output", 9, false, flagsFloat64},
+	{42, 1}: {"analog_output", 5, false, flagsInt32},
+	{42, 2}: {"analog_output", 3, false, flagsInt16},
+	{42, 3}: {"analog_output", 11, false, flagsInt32},
+	{42, 4}: {"analog_output", 9, false, flagsInt16},
+	{42, 5}: {"analog_output", 5, false, flagsFloat32},
+	{42, 6}: {"analog_output", 9, false, flagsFloat64},
+	{42, 7}: {"analog_output", 11, false, flagsFloat32},
+	{42, 8}: {"analog_output", 15, false, flagsFloat64},
+	// times and internal indications
+	{50, 1}: {kind: "", size: 6},
+	{51, 1}: {kind: "", size: 6},
+	{51, 2}: {kind: "", size: 6},
+	{52, 1}: {kind: "", size: 2},
+	{52, 2}: {kind: "", size: 2},
+	{80, 1}: {kind: "", size: 1, bits: true},
+}
+
+// parseResponse decodes a response fragment.
+func parseResponse(b []byte) (*response, error) {
+	if len(b) < 4 {
+		return nil, errors.New("short application fragment")
+	}
+	r := &response{
+		control:  b[0],
+		function: b[1],
+		iin:      binary.BigEndian.Uint16(b[2:]),
+	}
+	if r.function != funcResponse && r.function != funcUnsolicitedResponse {
+		return nil, fmt.Errorf("unexpected function code 0x%02x", r.function)
+	}
+
+	d := &decoder{b: b[4:]}
+	for len(d.b) > 0 {
+		points, err := d.objects()
+		if err != nil {
+			return r, err
+		}
+		r.points = append(r.points, points...)
+	}
+	return r, nil
+}
+
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || len(d.b) < n {
+		return nil, errors.New("truncated object")
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) uint(size int) (uint32, error) {
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint32
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[i])
+	}
+	return v, nil
+}
+
+// objects decodes an object header and the objects following it.
+func (d *decoder) objects() ([]Point, error) {
+	h, err := d.take(3)
+	if err != nil {
+		return nil, err
+	}
+	group, variation, qualifier := h[0], h[1], h[2]
+	t, ok := objectTypes[[2]byte{group, variation}]
+	if !ok {
+		return nil, fmt.Errorf("unsupported object g%dv%d", group, variation)
+	}
+
+	var start, count uint32
+	prefix := 0
+	switch qualifier {
+	case 0x00, 0x01:
+		size := int(qualifier) + 1
+		if start, err = d.uint(size); err != nil {
+			return nil, err
+		}
+		stop, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		if stop < start {
+			return nil, errors.New("invalid range")
+		}
+		count = stop - start + 1
+	case 0x07, 0x08:
+		if count, err = d.uint(int(qualifier) - 6); err != nil {
+			return nil, err
+		}
+	case 0x17, 0x28, 0x39:
+		prefix = int(qualifier >> 4)
+		if prefix == 3 {
+			prefix = 4
+		}
+		if count, err = d.uint(prefix); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported qualifier 0x%02x", qualifier)
+	}
+
+	if t.bits {
+		if prefix > 0 {
+			return nil, fmt.Errorf("unsupported qualifier 0x%02x for g%dv%d",
+				qualifier, group, variation)
+		}
+		b, err := d.take((int(count)*t.size + 7) / 8)
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == "" {
+			return nil, nil
+		}
+		points := make([]Point, 0, count)
+		for i := 0; i < int(count); i++ {
+			bit := uint(i * t.size)
+			v := b[bit/8] >> (bit % 8) & (1<<uint(t.size) - 1)
+			var value interface{}
+			if t.size == 1 {
+				value = v == 1
+			} else {
+				value = int64(v)
+			}
+			points = append(points, Point{
+				Type:  t.kind,
+				Index: start + uint32(i),
+				Value: value,
+			})
+		}
+		return points, nil
+	}
+
+	var points []Point
+	for i := uint32(0); i < count; i++ {
+		index := start + i
+		if prefix > 0 {
+			if index, err = d.uint(prefix); err != nil {
+				return nil, err
+			}
+		}
+		b, err := d.take(t.size)
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == "" {
+			continue
+		}
+		value, flags, hasFlags := t.decode(b)
+		points = append(points, Point{
+			Type:     t.kind,
+			Index:    index,
+			Value:    value,
+			Flags:    flags,
+			HasFlags: hasFlags,
+		})
+	}
+	return points, nil
+}
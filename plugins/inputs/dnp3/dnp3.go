@@ -0,0 +1,247 @@
+package dnp3
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const defaultPort = "20000"
+
+type DNP3 struct {
+	MasterAddress     int `toml:"master_address"`
+	Timeout           internal.Duration
+	IntegrityInterval internal.Duration `toml:"integrity_interval"`
+	EventClasses      []int             `toml:"event_classes"`
+	Outstations       []Outstation      `toml:"outstation"`
+
+	sync.Mutex
+	states map[string]*outstationState
+}
+
+// Outstation is a device polled by the plugin.
+type Outstation struct {
+	Address     string
+	LinkAddress int `toml:"link_address"`
+}
+
+type pointKey struct {
+	kind  string
+	index uint32
+}
+
+// outstationState is what the master knows about an outstation: the last
+// value of every point, kept up to date by event polls between integrity
+// polls.
+type outstationState struct {
+	sync.Mutex
+	points        map[pointKey]Point
+	lastIntegrity time.Time
+	iin           uint16
+}
+
+var sampleConfig = `
+  ## Link address of the master
+  master_address = 1
+
+  ## Timeout for connecting and for every response
+  timeout = "5s"
+
+  ## Interval of integrity polls, which read the static data (class 0) of
+  ## all points. In between, only the events of the event classes are read.
+  integrity_interval = "1h"
+  event_classes = [1, 2, 3]
+
+  ## Outstations to poll, as "host" or "host:port" (default port 20000)
+  ## with their link address
+  [[inputs.dnp3.outstation]]
+    address = "192.168.1.80"
+    link_address = 10
+`
+
+func (d *DNP3) SampleConfig() string {
+	return sampleConfig
+}
+
+func (d *DNP3) Description() string {
+	return "Poll binary, analog and counter points from DNP3 outstations"
+}
+
+func (d *DNP3) Gather(acc telegraf.Accumulator) error {
+	d.Lock()
+	if d.states == nil {
+		d.states = make(map[string]*outstationState)
+	}
+	d.Unlock()
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(d.Outstations))
+	wg.Add(len(d.Outstations))
+	for _, o := range d.Outstations {
+		go func(o Outstation) {
+			defer wg.Done()
+			errChan.C <- d.gatherOutstation(o, acc)
+		}(o)
+	}
+
+	wg.Wait()
+	return errChan.Error()
+}
+
+func (d *DNP3) state(address string, linkAddress int) *outstationState {
+	d.Lock()
+	defer d.Unlock()
+	key := address + "/" + strconv.Itoa(linkAddress)
+	s, ok := d.states[key]
+	if !ok {
+		s = &outstationState{points: make(map[pointKey]Point)}
+		d.states[key] = s
+	}
+	return s
+}
+
+func (d *DNP3) gatherOutstation(o Outstation, acc telegraf.Accumulator) error {
+	address := o.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	s := d.state(address, o.LinkAddress)
+	s.Lock()
+	defer s.Unlock()
+
+	integrity := s.lastIntegrity.IsZero() || len(d.EventClasses) == 0 ||
+		time.Since(s.lastIntegrity) >= d.IntegrityInterval.Duration
+	classes := d.EventClasses
+	if integrity {
+		classes = append(append([]int(nil), d.EventClasses...), 0)
+	}
+
+	if err := d.poll(address, o.LinkAddress, classes, s); err != nil {
+		return fmt.Errorf("%s: %s", address, err)
+	}
+	if integrity {
+		s.lastIntegrity = time.Now()
+	}
+
+	linkAddress := strconv.Itoa(o.LinkAddress)
+	acc.AddFields("dnp3_outstation", map[string]interface{}{
+		"iin":    int64(s.iin),
+		"points": len(s.points),
+	}, map[string]string{"outstation": address, "link_address": linkAddress})
+
+	for _, p := range s.points {
+		fields := map[string]interface{}{"value": p.Value}
+		if p.HasFlags {
+			fields["flags"] = int64(p.Flags)
+			fields["online"] = p.Flags&0x01 != 0
+		}
+		acc.AddFields("dnp3", fields, map[string]string{
+			"outstation":   address,
+			"link_address": linkAddress,
+			"type":         p.Type,
+			"index":        strconv.FormatUint(uint64(p.Index), 10),
+		})
+	}
+	return nil
+}
+
+// poll reads the given classes from an outstation, confirming the response
+// fragments that ask for it, and merges the points into the state.
+func (d *DNP3) poll(address string, linkAddress int, classes []int, s *outstationState) error {
+	timeout := d.Timeout.Duration
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect: %s", err)
+	}
+	defer conn.Close()
+
+	t := &transport{link: &link{
+		rw:     conn,
+		master: uint16(d.MasterAddress),
+		remote: uint16(linkAddress),
+	}}
+
+	// every poll opens a new connection, so the sequence numbers start
+	// over
+	const seq = 0
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := t.send(readClasses(seq, classes)); err != nil {
+		return err
+	}
+
+	var failed []string
+	expected := byte(seq)
+	for {
+		conn.SetDeadline(time.Now().Add(timeout))
+		fragment, err := t.receive()
+		if err != nil {
+			return err
+		}
+		r, err := parseResponse(fragment)
+		if r == nil {
+			return err
+		}
+		if err != nil {
+			// the points decoded before the unsupported object are
+			// still good
+			failed = append(failed, err.Error())
+		}
+
+		if r.control&appCon != 0 {
+			if err := t.send(confirm(r.control)); err != nil {
+				return err
+			}
+		}
+		for _, p := range r.points {
+			if strings.HasPrefix(p.Type, "analog") {
+				p.Value = toFloat(p.Value)
+			}
+			s.points[pointKey{p.Type, p.Index}] = p
+		}
+
+		if r.function == funcUnsolicitedResponse || r.control&0x0f != expected {
+			continue
+		}
+		expected = (expected + 1) & 0x0f
+		s.iin = r.iin
+		if r.control&appFin != 0 {
+			break
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// toFloat converts analog values to float64, so the field type does not
+// depend on the variation the outstation reports.
+func toFloat(v interface{}) interface{} {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v
+}
+
+func init() {
+	inputs.Add("dnp3", func() telegraf.Input {
+		return &DNP3{
+			MasterAddress:     1,
+			Timeout:           internal.Duration{Duration: 5 * time.Second},
+			IntegrityInterval: internal.Duration{Duration: time.Hour},
+			EventClasses:      []int{1, 2, 3},
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package dnp3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRC(t *testing.T) {
+	// link status request from the DNP3 specification examples
+	assert.Equal(t, uint16(0x21e9), crc([]byte{0x05, 0x64, 0x05, 0xc0, 0x01, 0x00, 0x00, 0x04}))
+}
+
+func TestLinkRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	master := &link{rw: &buf, master: 1, remote: 10}
+	segment := make([]byte, 40)
+	for i := range segment {
+		segment[i] = byte(i)
+	}
+	require.NoError(t, master.send(segment))
+	// header, two full blocks and one of 8 octets, each with a CRC
+	assert.Equal(t, 10+18+18+10, buf.Len())
+
+	// a frame from the outstation reads back the same
+	outstation := &link{master: 10, remote: 1}
+	frame := outstation.frame(linkPrm|linkUnconfirmedUserData, segment)
+	master.rw = bytes.NewBuffer(frame)
+	b, err := master.receive()
+	require.NoError(t, err)
+	assert.Equal(t, segment, b)
+
+	frame[12]++
+	master.rw = bytes.NewBuffer(frame)
+	_, err = master.receive()
+	assert.EqualError(t, err, "invalid link data CRC")
+}
+
+func TestParseResponse(t *testing.T) {
+	r, err := parseResponse([]byte{
+		0xe0, 0x81, 0x00, 0x00,
+		// binary inputs 0-9, packed
+		0x01, 0x01, 0x00, 0x00, 0x09, 0x05, 0x02,
+		// double-bit input 0, on
+		0x03, 0x02, 0x00, 0x00, 0x00, 0x81,
+		// analog input 3, 16-bit without flags
+		0x1e, 0x04, 0x00, 0x03, 0x03, 0xfe, 0xff,
+		// counter event at index 0x0102, 32-bit with flags
+		0x16, 0x01, 0x28, 0x01, 0x00, 0x02, 0x01, 0x01, 0x10, 0x27, 0x00, 0x00,
+		// time and date, skipped
+		0x32, 0x01, 0x07, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	require.NoError(t, err)
+	require.Len(t, r.points, 13)
+	assert.Equal(t, Point{Type: "binary_input", Index: 0, Value: true}, r.points[0])
+	assert.Equal(t, Point{Type: "binary_input", Index: 2, Value: true}, r.points[2])
+	assert.Equal(t, Point{Type: "binary_input", Index: 9, Value: true}, r.points[9])
+	assert.Equal(t, Point{Type: "double_bit_input", Index: 0, Value: int64(2), Flags: 0x81, HasFlags: true}, r.points[10])
+	assert.Equal(t, Point{Type: "analog_input", Index: 3, Value: int64(-2)}, r.points[11])
+	assert.Equal(t, Point{Type: "counter", Index: 0x0102, Value: int64(10000), Flags: 0x01, HasFlags: true}, r.points[12])
+
+	r, err = parseResponse([]byte{0xc0, 0x81, 0x00, 0x00, 0x1e, 0x01, 0x00, 0x00, 0x00, 0x01, 0x10, 0x00, 0x00, 0x00, 0x63, 0x01, 0x00, 0x00, 0x00})
+	assert.EqualError(t, err, "unsupported object g99v1")
+	require.Len(t, r.points, 1)
+}
+
+// fakeOutstation answers every read request with the next of its
+// responses, each a list of fragments, and records the requests and
+// confirmations it receives. Every handled poll is signalled on handled.
+// With links set, requests are answered with the next response for the link
+// address they are sent to instead, emulating several outstations behind
+// one gateway.
+type fakeOutstation struct {
+	sync.Mutex
+	listener  net.Listener
+	responses [][][]byte
+	links     map[uint16][][][]byte
+	requests  [][]byte
+	handled   chan struct{}
+}
+
+func (f *fakeOutstation) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.handle(conn)
+		conn.Close()
+		f.handled <- struct{}{}
+	}
+}
+
+// readMasterFrame reads a frame of the master and returns its destination
+// and its user data without the transport header.
+func readMasterFrame(r io.Reader) (uint16, []byte, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	destination := binary.LittleEndian.Uint16(header[4:])
+	n := int(header[2]) - 5
+	var data []byte
+	for n > 0 {
+		size := 16
+		if n < size {
+			size = n
+		}
+		block := make([]byte, size+2)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return 0, nil, err
+		}
+		data = append(data, block[:size]...)
+		n -= size
+	}
+	return destination, data[1:], nil
+}
+
+func (f *fakeOutstation) handle(conn net.Conn) {
+	l := &link{rw: conn, master: 10, remote: 1}
+	var seq byte
+	send := func(fragment []byte) {
+		segment := append([]byte{0xc0 | seq&0x3f}, fragment...)
+		seq++
+		conn.Write(l.frame(linkPrm|linkUnconfirmedUserData, segment))
+	}
+
+	destination, request, err := readMasterFrame(conn)
+	if err != nil {
+		return
+	}
+	f.Lock()
+	f.requests = append(f.requests, request)
+	var fragments [][]byte
+	if f.links != nil {
+		fragments = f.links[destination][0]
+		f.links[destination] = f.links[destination][1:]
+	} else {
+		fragments = f.responses[0]
+		f.responses = f.responses[1:]
+	}
+	f.Unlock()
+
+	for _, fragment := range fragments {
+		send(fragment)
+		if fragment[0]&appCon == 0 {
+			continue
+		}
+		_, confirmation, err := readMasterFrame(conn)
+		if err != nil {
+			return
+		}
+		f.Lock()
+		f.requests = append(f.requests, confirmation)
+		f.Unlock()
+	}
+}
+
+func float32Bytes(v float32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+	return b
+}
+
+func TestGather(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	integrity := []byte{
+		0xe0, 0x81, 0x00, 0x00,
+		// binary inputs 0-1 with flags
+		0x01, 0x02, 0x00, 0x00, 0x01, 0x81, 0x01,
+		// analog input 0, 32-bit
+		0x1e, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0xd2, 0x04, 0x00, 0x00,
+		// counter 0 without flags
+		0x14, 0x05, 0x00, 0x00, 0x00, 0x63, 0x00, 0x00, 0x00,
+		// analog input event at index 1, float
+		0x20, 0x05, 0x28, 0x01, 0x00, 0x01, 0x00, 0x01,
+	}
+	integrity = append(integrity, float32Bytes(12.5)...)
+
+	f := &fakeOutstation{
+		listener: l,
+		handled:  make(chan struct{}, 2),
+		responses: [][][]byte{
+			{integrity},
+			{
+				// unsolicited null response
+				{0xf0, 0x82, 0x80, 0x00},
+				// binary input event at index 1 with time
+				{0xa0, 0x81, 0x00, 0x00,
+					0x02, 0x02, 0x17, 0x01, 0x01, 0x81, 0, 0, 0, 0, 0, 0},
+				// analog input event at index 0, counter event at index 0
+				{0x61, 0x81, 0x00, 0x00,
+					0x20, 0x01, 0x17, 0x01, 0x00, 0x01, 0xdc, 0x05, 0x00, 0x00,
+					0x16, 0x01, 0x17, 0x01, 0x00, 0x01, 0x64, 0x00, 0x00, 0x00},
+			},
+		},
+	}
+	go f.serve()
+
+	d := &DNP3{
+		MasterAddress:     1,
+		Timeout:           internal.Duration{Duration: time.Second},
+		IntegrityInterval: internal.Duration{Duration: time.Hour},
+		EventClasses:      []int{1, 2, 3},
+		Outstations:       []Outstation{{Address: l.Addr().String(), LinkAddress: 10}},
+	}
+	addr := l.Addr().String()
+
+	var acc testutil.Accumulator
+	require.NoError(t, d.Gather(&acc))
+	<-f.handled
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": true, "flags": int64(0x81), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "binary_input", "index": "0"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": false, "flags": int64(0x01), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "binary_input", "index": "1"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": float64(1234), "flags": int64(0x01), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "analog_input", "index": "0"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": 12.5, "flags": int64(0x01), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "analog_input", "index": "1"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": int64(99)},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "counter", "index": "0"})
+	acc.AssertContainsTaggedFields(t, "dnp3_outstation",
+		map[string]interface{}{"iin": int64(0), "points": 5},
+		map[string]string{"outstation": addr, "link_address": "10"})
+
+	// the second poll only reads events
+	acc = testutil.Accumulator{}
+	require.NoError(t, d.Gather(&acc))
+	<-f.handled
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": true, "flags": int64(0x81), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "binary_input", "index": "1"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": float64(1500), "flags": int64(0x01), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "analog_input", "index": "0"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": int64(100), "flags": int64(0x01), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "counter", "index": "0"})
+	acc.AssertContainsTaggedFields(t, "dnp3",
+		map[string]interface{}{"value": 12.5, "flags": int64(0x01), "online": true},
+		map[string]string{"outstation": addr, "link_address": "10", "type": "analog_input", "index": "1"})
+
+	f.Lock()
+	defer f.Unlock()
+	assert.Equal(t, [][]byte{
+		// integrity poll of classes 1, 2, 3 and 0
+		{0xc0, 0x01, 60, 2, 6, 60, 3, 6, 60, 4, 6, 60, 1, 6},
+		{0xc0, 0x00},
+		// event poll, confirming the unsolicited response on the way
+		{0xc0, 0x01, 60, 2, 6, 60, 3, 6, 60, 4, 6},
+		{0xd0, 0x00},
+		{0xc0, 0x00},
+		{0xc1, 0x00},
+	}, f.requests)
+}
+
+func TestGatherLinkAddresses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// analog input 0, 16-bit with flags
+	analog := func(v byte) [][][]byte {
+		return [][][]byte{{{0xc0, 0x81, 0x00, 0x00, 0x1e, 0x02, 0x00, 0x00, 0x00, 0x01, v, 0x00}}}
+	}
+	f := &fakeOutstation{
+		listener: l,
+		handled:  make(chan struct{}, 2),
+		links:    map[uint16][][][]byte{10: analog(10), 11: analog(11)},
+	}
+	go f.serve()
+
+	addr := l.Addr().String()
+	d := &DNP3{
+		MasterAddress: 1,
+		Timeout:       internal.Duration{Duration: time.Second},
+		Outstations: []Outstation{
+			{Address: addr, LinkAddress: 10},
+			{Address: addr, LinkAddress: 11},
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, d.Gather(&acc))
+	<-f.handled
+	<-f.handled
+	for _, link := range []string{"10", "11"} {
+		value, _ := strconv.ParseFloat(link, 64)
+		acc.AssertContainsTaggedFields(t, "dnp3",
+			map[string]interface{}{"value": value, "flags": int64(0x01), "online": true},
+			map[string]string{"outstation": addr, "link_address": link, "type": "analog_input", "index": "0"})
+		acc.AssertContainsTaggedFields(t, "dnp3_outstation",
+			map[string]interface{}{"iin": int64(0), "points": 1},
+			map[string]string{"outstation": addr, "link_address": link})
+	}
+}
@@ -0,0 +1,204 @@
+package dnp3
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Link layer function codes.
+const (
+	linkAck                 = 0x00
+	linkConfirmedUserData   = 0x03
+	linkUnconfirmedUserData = 0x04
+	linkRequestStatus       = 0x09
+	linkStatus              = 0x0b
+
+	linkDir = 0x80
+	linkPrm = 0x40
+)
+
+// maxSegment is the largest transport segment carried by a link frame.
+const maxSegment = 250
+
+// crc computes the DNP3 CRC of a block.
+func crc(b []byte) uint16 {
+	var c uint16
+	for _, x := range b {
+		c ^= uint16(x)
+		for i := 0; i < 8; i++ {
+			if c&1 != 0 {
+				c = c>>1 ^ 0xa6bc
+			} else {
+				c >>= 1
+			}
+		}
+	}
+	return ^c
+}
+
+func appendCRC(b []byte, block []byte) []byte {
+	b = append(b, block...)
+	c := crc(block)
+	return append(b, byte(c), byte(c>>8))
+}
+
+// link frames transport segments between a master and an outstation.
+type link struct {
+	rw     io.ReadWriter
+	master uint16
+	remote uint16
+}
+
+// frame builds a link frame, with a CRC after the header and every 16
+// octets of user data.
+func (l *link) frame(control byte, data []byte) []byte {
+	header := make([]byte, 8)
+	header[0], header[1] = 0x05, 0x64
+	header[2] = byte(5 + len(data))
+	header[3] = control
+	binary.LittleEndian.PutUint16(header[4:], l.remote)
+	binary.LittleEndian.PutUint16(header[6:], l.master)
+
+	b := appendCRC(nil, header)
+	for len(data) > 0 {
+		n := 16
+		if len(data) < n {
+			n = len(data)
+		}
+		b = appendCRC(b, data[:n])
+		data = data[n:]
+	}
+	return b
+}
+
+// send writes a transport segment in an unconfirmed user data frame.
+func (l *link) send(segment []byte) error {
+	_, err := l.rw.Write(l.frame(linkDir|linkPrm|linkUnconfirmedUserData, segment))
+	return err
+}
+
+// receive returns the next transport segment addressed to the master,
+// answering link layer requests of the outstation on the way.
+func (l *link) receive() ([]byte, error) {
+	for {
+		header := make([]byte, 10)
+		if _, err := io.ReadFull(l.rw, header); err != nil {
+			return nil, err
+		}
+		if header[0] != 0x05 || header[1] != 0x64 {
+			return nil, errors.New("invalid link frame start")
+		}
+		if crc(header[:8]) != binary.LittleEndian.Uint16(header[8:]) {
+			return nil, errors.New("invalid link header CRC")
+		}
+		if header[2] < 5 {
+			return nil, errors.New("invalid link frame length")
+		}
+
+		n := int(header[2]) - 5
+		var data []byte
+		for n > 0 {
+			size := 16
+			if n < size {
+				size = n
+			}
+			block := make([]byte, size+2)
+			if _, err := io.ReadFull(l.rw, block); err != nil {
+				return nil, err
+			}
+			if crc(block[:size]) != binary.LittleEndian.Uint16(block[size:]) {
+				return nil, errors.New("invalid link data CRC")
+			}
+			data = append(data, block[:size]...)
+			n -= size
+		}
+
+		control := header[3]
+		destination := binary.LittleEndian.Uint16(header[4:])
+		if control&linkDir != 0 || destination != l.master {
+			continue
+		}
+		if control&linkPrm == 0 {
+			// acknowledgements of the secondary station
+			continue
+		}
+
+		switch control & 0x0f {
+		case linkUnconfirmedUserData:
+			return data, nil
+		case linkConfirmedUserData:
+			if _, err := l.rw.Write(l.frame(linkDir|linkAck, nil)); err != nil {
+				return nil, err
+			}
+			return data, nil
+		case linkRequestStatus:
+			if _, err := l.rw.Write(l.frame(linkDir|linkStatus, nil)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// transport splits application fragments into transport segments and
+// reassembles them.
+type transport struct {
+	link *link
+	seq  byte
+}
+
+func (t *transport) send(fragment []byte) error {
+	first := true
+	for {
+		n := maxSegment - 1
+		if len(fragment) < n {
+			n = len(fragment)
+		}
+		h := t.seq & 0x3f
+		if first {
+			h |= 0x40
+		}
+		if n == len(fragment) {
+			h |= 0x80
+		}
+		t.seq++
+		if err := t.link.send(append([]byte{h}, fragment[:n]...)); err != nil {
+			return err
+		}
+		fragment = fragment[n:]
+		first = false
+		if len(fragment) == 0 {
+			return nil
+		}
+	}
+}
+
+func (t *transport) receive() ([]byte, error) {
+	var fragment []byte
+	started := false
+	var next byte
+	for {
+		segment, err := t.link.receive()
+		if err != nil {
+			return nil, err
+		}
+		if len(segment) < 1 {
+			continue
+		}
+		h := segment[0]
+		if h&0x40 != 0 {
+			// a first segment discards an incomplete fragment
+			fragment = nil
+			started = true
+		} else if !started || h&0x3f != next {
+			fragment = nil
+			started = false
+			continue
+		}
+		next = (h + 1) & 0x3f
+		fragment = append(fragment, segment[1:]...)
+		if h&0x80 != 0 {
+			return fragment, nil
+		}
+	}
+}
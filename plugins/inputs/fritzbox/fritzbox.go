@@ -0,0 +1,384 @@
+package fritzbox
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/availability"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/internal/poller"
+	"github.com/influxdata/telegraf/internal/rollover"
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/internal/upnp"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// TR-064 service types read by the plugin.
+const (
+	deviceInfoService   = "urn:dslforum-org:service:DeviceInfo:1"
+	wanCommonService    = "urn:dslforum-org:service:WANCommonInterfaceConfig:1"
+	dslInterfaceService = "urn:dslforum-org:service:WANDSLInterfaceConfig:1"
+	// the WLAN services are numbered by radio, 1 for 2.4 GHz, 2 for 5 GHz
+	// and the next one for the guest network
+	wlanServicePrefix = "urn:dslforum-org:service:WLANConfiguration:"
+)
+
+// descriptionPath is the path of the TR-064 device description.
+const descriptionPath = "/tr64desc.xml"
+
+// dslFields maps the elements of the WANDSLInterfaceConfig GetInfo response
+// to field names. Noise margins and attenuations are reported by the box in
+// tenths of a dB.
+var dslFields = []struct {
+	element, field string
+	tenths         bool
+}{
+	{"NewUpstreamCurrRate", "upstream_rate", false},
+	{"NewDownstreamCurrRate", "downstream_rate", false},
+	{"NewUpstreamMaxRate", "upstream_max_rate", false},
+	{"NewDownstreamMaxRate", "downstream_max_rate", false},
+	{"NewUpstreamNoiseMargin", "upstream_noise_margin", true},
+	{"NewDownstreamNoiseMargin", "downstream_noise_margin", true},
+	{"NewUpstreamAttenuation", "upstream_attenuation", true},
+	{"NewDownstreamAttenuation", "downstream_attenuation", true},
+}
+
+// dslErrorFields maps the elements of the WANDSLInterfaceConfig
+// GetStatisticsTotal response to field names.
+var dslErrorFields = map[string]string{
+	"NewFECErrors":           "fec_errors",
+	"NewCRCErrors":           "crc_errors",
+	"NewErroredSecs":         "errored_seconds",
+	"NewSeverelyErroredSecs": "severely_errored_seconds",
+}
+
+type FritzBox struct {
+	Servers  []string
+	Username string
+	Password string
+	Timeout  internal.Duration
+
+	SSLCA              string `toml:"ssl_ca"`
+	SSLCert            string `toml:"ssl_cert"`
+	SSLKey             string `toml:"ssl_key"`
+	InsecureSkipVerify bool
+
+	client    *devicehttp.Client
+	persister state.StatePersister
+	counters  *rollover.Cache
+
+	mu sync.Mutex
+	// services holds the control URLs by service type, by server
+	services map[string]map[string]string
+}
+
+var sampleConfig = `
+  ## TR-064 URLs of the boxes, port 49000 for HTTP or 49443 for HTTPS
+  servers = ["http://fritz.box:49000"]
+
+  ## Credentials of a FRITZ!Box user with the "FRITZ!Box Settings" right
+  username = "telegraf"
+  password = ""
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification, the boxes use a self-signed
+  ## certificate
+  # insecure_skip_verify = false
+`
+
+func (f *FritzBox) SampleConfig() string {
+	return sampleConfig
+}
+
+func (f *FritzBox) Description() string {
+	return "Read WAN, DSL and wireless statistics from AVM FRITZ!Box routers over TR-064"
+}
+
+// SetStatePersister keeps the counter wrap offsets in the state directory of
+// the agent, so the totals continue across restarts.
+func (f *FritzBox) SetStatePersister(p state.StatePersister) {
+	f.persister = p
+}
+
+func (f *FritzBox) Gather(acc telegraf.Accumulator) error {
+	if f.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			Username:           f.Username,
+			Password:           f.Password,
+			AuthMode:           devicehttp.AuthDigest,
+			SSLCA:              f.SSLCA,
+			SSLCert:            f.SSLCert,
+			SSLKey:             f.SSLKey,
+			InsecureSkipVerify: f.InsecureSkipVerify,
+			Timeout:            f.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		f.client = client
+	}
+
+	if f.counters == nil {
+		var persister rollover.Persister
+		if f.persister != nil {
+			persister = state.Bind(f.persister, "counters")
+		}
+		counters := rollover.NewCache(persister)
+		// the counters of the box start over after a reboot
+		counters.Threshold = rollover.RestartThreshold
+		if err := counters.Load(); err != nil {
+			return err
+		}
+		f.counters = counters
+		f.services = make(map[string]map[string]string)
+	}
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(f.Servers) + 1)
+	wg.Add(len(f.Servers))
+	for _, server := range f.Servers {
+		go func(server string) {
+			defer wg.Done()
+			err := f.gatherServer(server, acc)
+			if err != nil {
+				// the firmware may have been updated, read the
+				// description again next time
+				f.mu.Lock()
+				delete(f.services, server)
+				f.mu.Unlock()
+			}
+			availability.Add(acc, "fritzbox",
+				map[string]string{"server": poller.ServerHost(server)}, err)
+			errChan.C <- err
+		}(server)
+	}
+
+	wg.Wait()
+	errChan.C <- f.counters.Save()
+	return errChan.Error()
+}
+
+func (f *FritzBox) gatherServer(server string, acc telegraf.Accumulator) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", server, err)
+	}
+	services, err := f.controlURLs(server)
+	if err != nil {
+		return err
+	}
+	tags := map[string]string{"server": u.Host}
+
+	info, err := f.call(services, deviceInfoService, "GetInfo")
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{})
+	if uptime, err := strconv.ParseInt(info["NewUpTime"], 10, 64); err == nil {
+		fields["uptime"] = uptime
+	}
+	if v := info["NewSoftwareVersion"]; v != "" {
+		fields["firmware"] = v
+	}
+	deviceTags := copyTags(tags)
+	if v := info["NewModelName"]; v != "" {
+		deviceTags["model"] = v
+	}
+	acc.AddFields("fritzbox", fields, deviceTags)
+
+	if err := f.gatherWAN(services, tags, acc); err != nil {
+		return err
+	}
+	if _, ok := services[dslInterfaceService]; ok {
+		if err := f.gatherDSL(services, tags, acc); err != nil {
+			return err
+		}
+	}
+	return f.gatherWLAN(services, tags, acc)
+}
+
+func (f *FritzBox) gatherWAN(
+	services map[string]string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	fields := make(map[string]interface{})
+	for _, c := range upnp.CounterActions {
+		values, err := f.call(services, wanCommonService, c.Action)
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseUint(values[c.Argument], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s from %s: %q", c.Argument, tags["server"], values[c.Argument])
+		}
+		fields[c.Field] = f.counters.Compensate(tags["server"], c.Field, v)
+	}
+
+	link, err := f.call(services, wanCommonService, "GetCommonLinkProperties")
+	if err != nil {
+		return err
+	}
+	if v, err := strconv.ParseInt(link["NewLayer1UpstreamMaxBitRate"], 10, 64); err == nil {
+		fields["upstream_max_bitrate"] = v
+	}
+	if v, err := strconv.ParseInt(link["NewLayer1DownstreamMaxBitRate"], 10, 64); err == nil {
+		fields["downstream_max_bitrate"] = v
+	}
+	if status := link["NewPhysicalLinkStatus"]; status != "" {
+		fields["link_up"] = status == "Up"
+	}
+
+	wanTags := copyTags(tags)
+	if v := link["NewWANAccessType"]; v != "" {
+		wanTags["access_type"] = v
+	}
+	acc.AddFields("fritzbox_wan", fields, wanTags)
+	return nil
+}
+
+func (f *FritzBox) gatherDSL(
+	services map[string]string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	info, err := f.call(services, dslInterfaceService, "GetInfo")
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{})
+	for _, d := range dslFields {
+		v, err := strconv.ParseInt(info[d.element], 10, 64)
+		if err != nil {
+			continue
+		}
+		if d.tenths {
+			fields[d.field] = float64(v) / 10
+		} else {
+			fields[d.field] = v
+		}
+	}
+	if status := info["NewStatus"]; status != "" {
+		fields["link_up"] = status == "Up"
+	}
+
+	stats, err := f.call(services, dslInterfaceService, "GetStatisticsTotal")
+	if err != nil {
+		return err
+	}
+	for element, field := range dslErrorFields {
+		if v, err := strconv.ParseInt(stats[element], 10, 64); err == nil {
+			fields[field] = v
+		}
+	}
+
+	acc.AddFields("fritzbox_dsl", fields, tags)
+	return nil
+}
+
+func (f *FritzBox) gatherWLAN(
+	services map[string]string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	var wlans []string
+	for service := range services {
+		if strings.HasPrefix(service, wlanServicePrefix) {
+			wlans = append(wlans, service)
+		}
+	}
+	sort.Strings(wlans)
+
+	for _, service := range wlans {
+		info, err := f.call(services, service, "GetInfo")
+		if err != nil {
+			return err
+		}
+		associations, err := f.call(services, service, "GetTotalAssociations")
+		if err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{
+			"enabled": info["NewEnable"] == "1",
+		}
+		if v, err := strconv.ParseInt(associations["NewTotalAssociations"], 10, 64); err == nil {
+			fields["clients"] = v
+		}
+		if v, err := strconv.ParseInt(info["NewChannel"], 10, 64); err == nil {
+			fields["channel"] = v
+		}
+
+		wlanTags := copyTags(tags)
+		wlanTags["wlan"] = strings.TrimPrefix(service, wlanServicePrefix)
+		if v := info["NewSSID"]; v != "" {
+			wlanTags["ssid"] = v
+		}
+		acc.AddFields("fritzbox_wlan", fields, wlanTags)
+	}
+	return nil
+}
+
+// controlURLs returns the control URLs of the services of a box by service
+// type, reading its device description on first use.
+func (f *FritzBox) controlURLs(server string) (map[string]string, error) {
+	f.mu.Lock()
+	services, ok := f.services[server]
+	f.mu.Unlock()
+	if ok {
+		return services, nil
+	}
+
+	location := strings.TrimRight(server, "/") + descriptionPath
+	b, err := f.client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	services, err = upnp.ControlURLs(b, location)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range []string{deviceInfoService, wanCommonService} {
+		if _, ok := services[service]; !ok {
+			return nil, fmt.Errorf("%s has no %s service", location, service)
+		}
+	}
+
+	f.mu.Lock()
+	f.services[server] = services
+	f.mu.Unlock()
+	return services, nil
+}
+
+// call invokes an action of one of the services of a box and returns the
+// output arguments of the response.
+func (f *FritzBox) call(services map[string]string, service, action string) (map[string]string, error) {
+	return upnp.Call(f.client, services[service], service, action)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	return t
+}
+
+func init() {
+	inputs.Add("fritzbox", func() telegraf.Input {
+		return &FritzBox{}
+	})
+}
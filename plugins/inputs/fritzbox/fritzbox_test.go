@@ -0,0 +1,305 @@
+package fritzbox
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tr64desc = `<?xml version="1.0"?>
+<root xmlns="urn:dslforum-org:device-1-0">
+  <device>
+    <deviceType>urn:dslforum-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service>
+        <serviceType>urn:dslforum-org:service:DeviceInfo:1</serviceType>
+        <controlURL>/upnp/control/deviceinfo</controlURL>
+      </service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:dslforum-org:device:LANDevice:1</deviceType>
+        <serviceList>
+          <service>
+            <serviceType>urn:dslforum-org:service:WLANConfiguration:1</serviceType>
+            <controlURL>/upnp/control/wlanconfig1</controlURL>
+          </service>
+          <service>
+            <serviceType>urn:dslforum-org:service:WLANConfiguration:2</serviceType>
+            <controlURL>/upnp/control/wlanconfig2</controlURL>
+          </service>
+        </serviceList>
+      </device>
+      <device>
+        <deviceType>urn:dslforum-org:device:WANDevice:1</deviceType>
+        <serviceList>
+          <service>
+            <serviceType>urn:dslforum-org:service:WANCommonInterfaceConfig:1</serviceType>
+            <controlURL>/upnp/control/wancommonifconfig1</controlURL>
+          </service>
+          <service>
+            <serviceType>urn:dslforum-org:service:WANDSLInterfaceConfig:1</serviceType>
+            <controlURL>/upnp/control/wandslifconfig1</controlURL>
+          </service>
+        </serviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+// responses holds the output arguments of the actions by control path.
+var responses = map[string]map[string]string{
+	"/upnp/control/deviceinfo": {
+		"GetInfo": "<NewModelName>FRITZ!Box 7590</NewModelName>" +
+			"<NewSoftwareVersion>154.07.29</NewSoftwareVersion>" +
+			"<NewUpTime>%d</NewUpTime>",
+	},
+	"/upnp/control/wancommonifconfig1": {
+		"GetTotalBytesSent":       "<NewTotalBytesSent>%d</NewTotalBytesSent>",
+		"GetTotalBytesReceived":   "<NewTotalBytesReceived>3221225472</NewTotalBytesReceived>",
+		"GetTotalPacketsSent":     "<NewTotalPacketsSent>18723411</NewTotalPacketsSent>",
+		"GetTotalPacketsReceived": "<NewTotalPacketsReceived>41023887</NewTotalPacketsReceived>",
+		"GetCommonLinkProperties": "<NewWANAccessType>DSL</NewWANAccessType>" +
+			"<NewLayer1UpstreamMaxBitRate>46720000</NewLayer1UpstreamMaxBitRate>" +
+			"<NewLayer1DownstreamMaxBitRate>116796000</NewLayer1DownstreamMaxBitRate>" +
+			"<NewPhysicalLinkStatus>Up</NewPhysicalLinkStatus>",
+	},
+	"/upnp/control/wandslifconfig1": {
+		"GetInfo": "<NewEnable>1</NewEnable><NewStatus>Up</NewStatus>" +
+			"<NewUpstreamCurrRate>40000</NewUpstreamCurrRate>" +
+			"<NewDownstreamCurrRate>100000</NewDownstreamCurrRate>" +
+			"<NewUpstreamMaxRate>46720</NewUpstreamMaxRate>" +
+			"<NewDownstreamMaxRate>116796</NewDownstreamMaxRate>" +
+			"<NewUpstreamNoiseMargin>90</NewUpstreamNoiseMargin>" +
+			"<NewDownstreamNoiseMargin>110</NewDownstreamNoiseMargin>" +
+			"<NewUpstreamAttenuation>120</NewUpstreamAttenuation>" +
+			"<NewDownstreamAttenuation>135</NewDownstreamAttenuation>",
+		"GetStatisticsTotal": "<NewFECErrors>0</NewFECErrors><NewCRCErrors>25</NewCRCErrors>" +
+			"<NewErroredSecs>12</NewErroredSecs><NewSeverelyErroredSecs>1</NewSeverelyErroredSecs>",
+	},
+	"/upnp/control/wlanconfig1": {
+		"GetInfo": "<NewEnable>1</NewEnable><NewStatus>Up</NewStatus>" +
+			"<NewChannel>6</NewChannel><NewSSID>home</NewSSID>",
+		"GetTotalAssociations": "<NewTotalAssociations>4</NewTotalAssociations>",
+	},
+	"/upnp/control/wlanconfig2": {
+		"GetInfo": "<NewEnable>0</NewEnable><NewStatus>Disabled</NewStatus>" +
+			"<NewChannel>36</NewChannel><NewSSID>home</NewSSID>",
+		"GetTotalAssociations": "<NewTotalAssociations>0</NewTotalAssociations>",
+	},
+}
+
+const responseTemplate = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body>
+</s:Envelope>`
+
+// fakeBox answers the description and the digest authenticated SOAP requests
+// of a FRITZ!Box.
+type fakeBox struct {
+	sync.Mutex
+	uptime    int64
+	bytesSent int64
+}
+
+func (b *fakeBox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == descriptionPath {
+		fmt.Fprint(w, tr64desc)
+		return
+	}
+	actions, ok := responses[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ha1 := fmt.Sprintf("%x", md5.Sum([]byte("telegraf:F!Box SOAP-Auth:secret")))
+	ha2 := fmt.Sprintf("%x", md5.Sum([]byte(r.Method+":"+r.URL.RequestURI())))
+	response := fmt.Sprintf("%x", md5.Sum([]byte(ha1+":nonce1:"+ha2)))
+	if !strings.Contains(r.Header.Get("Authorization"), `response="`+response+`"`) {
+		w.Header().Set("WWW-Authenticate", `Digest realm="F!Box SOAP-Auth", nonce="nonce1"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	soapAction := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	i := strings.Index(soapAction, "#")
+	service, action := soapAction[:i], soapAction[i+1:]
+	body, _ := ioutil.ReadAll(r.Body)
+	output, ok := actions[action]
+	if !ok || !strings.Contains(string(body), "<u:"+action+` xmlns:u="`+service+`"`) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	switch action {
+	case "GetInfo":
+		if strings.Contains(output, "%d") {
+			output = fmt.Sprintf(output, b.uptime)
+		}
+	case "GetTotalBytesSent":
+		output = fmt.Sprintf(output, b.bytesSent)
+	}
+	fmt.Fprintf(w, responseTemplate, action, service, output, action)
+}
+
+func TestGather(t *testing.T) {
+	box := &fakeBox{uptime: 86400, bytesSent: 4000000000}
+	ts := httptest.NewServer(box)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	f := &FritzBox{Servers: []string{ts.URL}, Username: "telegraf", Password: "secret"}
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+
+	acc.AssertContainsTaggedFields(t, "fritzbox",
+		map[string]interface{}{"uptime": int64(86400), "firmware": "154.07.29"},
+		map[string]string{"server": u.Host, "model": "FRITZ!Box 7590"})
+	acc.AssertContainsTaggedFields(t, "fritzbox",
+		map[string]interface{}{"up": 1},
+		map[string]string{"server": u.Host})
+	acc.AssertContainsTaggedFields(t, "fritzbox_wan",
+		map[string]interface{}{
+			"bytes_sent":             uint64(4000000000),
+			"bytes_received":         uint64(3221225472),
+			"packets_sent":           uint64(18723411),
+			"packets_received":       uint64(41023887),
+			"upstream_max_bitrate":   int64(46720000),
+			"downstream_max_bitrate": int64(116796000),
+			"link_up":                true,
+		},
+		map[string]string{"server": u.Host, "access_type": "DSL"})
+	acc.AssertContainsTaggedFields(t, "fritzbox_dsl",
+		map[string]interface{}{
+			"upstream_rate":            int64(40000),
+			"downstream_rate":          int64(100000),
+			"upstream_max_rate":        int64(46720),
+			"downstream_max_rate":      int64(116796),
+			"upstream_noise_margin":    9.0,
+			"downstream_noise_margin":  11.0,
+			"upstream_attenuation":     12.0,
+			"downstream_attenuation":   13.5,
+			"link_up":                  true,
+			"fec_errors":               int64(0),
+			"crc_errors":               int64(25),
+			"errored_seconds":          int64(12),
+			"severely_errored_seconds": int64(1),
+		},
+		map[string]string{"server": u.Host})
+	acc.AssertContainsTaggedFields(t, "fritzbox_wlan",
+		map[string]interface{}{"enabled": true, "clients": int64(4), "channel": int64(6)},
+		map[string]string{"server": u.Host, "wlan": "1", "ssid": "home"})
+	acc.AssertContainsTaggedFields(t, "fritzbox_wlan",
+		map[string]interface{}{"enabled": false, "clients": int64(0), "channel": int64(36)},
+		map[string]string{"server": u.Host, "wlan": "2", "ssid": "home"})
+}
+
+func TestGatherCounterWrap(t *testing.T) {
+	box := &fakeBox{uptime: 86400, bytesSent: 4000000000}
+	ts := httptest.NewServer(box)
+	defer ts.Close()
+
+	f := &FritzBox{Servers: []string{ts.URL}, Username: "telegraf", Password: "secret"}
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+
+	// the 32-bit counter wraps
+	box.Lock()
+	box.uptime = 86460
+	box.bytesSent = 1000
+	box.Unlock()
+	acc = testutil.Accumulator{}
+	require.NoError(t, f.Gather(&acc))
+	m, ok := acc.Get("fritzbox_wan")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1<<32+1000), m.Fields["bytes_sent"])
+
+	// the box restarts
+	box.Lock()
+	box.uptime = 60
+	box.bytesSent = 500
+	box.Unlock()
+	acc = testutil.Accumulator{}
+	require.NoError(t, f.Gather(&acc))
+	m, ok = acc.Get("fritzbox_wan")
+	require.True(t, ok)
+	assert.Equal(t, uint64(500), m.Fields["bytes_sent"])
+}
+
+func TestGatherRestartWhileStopped(t *testing.T) {
+	box := &fakeBox{uptime: 86400, bytesSent: 2000000000}
+	ts := httptest.NewServer(box)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fritzbox")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := &FritzBox{Servers: []string{ts.URL}, Username: "telegraf", Password: "secret"}
+	f.SetStatePersister(&state.DirPersister{Dir: dir})
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+
+	// the box restarts before the next start of the agent
+	box.Lock()
+	box.uptime = 60
+	box.bytesSent = 500
+	box.Unlock()
+	f = &FritzBox{Servers: []string{ts.URL}, Username: "telegraf", Password: "secret"}
+	f.SetStatePersister(&state.DirPersister{Dir: dir})
+	acc = testutil.Accumulator{}
+	require.NoError(t, f.Gather(&acc))
+	m, ok := acc.Get("fritzbox_wan")
+	require.True(t, ok)
+	assert.Equal(t, uint64(500), m.Fields["bytes_sent"])
+}
+
+func TestGatherWrongPassword(t *testing.T) {
+	ts := httptest.NewServer(&fakeBox{})
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	f := &FritzBox{Servers: []string{ts.URL}, Username: "telegraf", Password: "wrong"}
+	var acc testutil.Accumulator
+	err = f.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+	acc.AssertContainsTaggedFields(t, "fritzbox",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": u.Host, "reason": "unauthorized"})
+}
+
+func TestGatherNoDSL(t *testing.T) {
+	desc := strings.Replace(tr64desc, "WANDSLInterfaceConfig", "WANEthernetLinkConfig", 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == descriptionPath {
+			fmt.Fprint(w, desc)
+			return
+		}
+		(&fakeBox{uptime: 60}).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	f := &FritzBox{Servers: []string{ts.URL}, Username: "telegraf", Password: "secret"}
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+	assert.True(t, acc.HasMeasurement("fritzbox_wan"))
+	assert.False(t, acc.HasMeasurement("fritzbox_dsl"))
+}
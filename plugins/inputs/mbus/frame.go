@@ -0,0 +1,468 @@
+package mbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	ack        = 0xe5
+	startShort = 0x10
+	startLong  = 0x68
+	stop       = 0x16
+
+	controlSndUd  = 0x53
+	controlReqUd2 = 0x5b
+
+	ciSelect = 0x52
+
+	// addressSelected is the primary address of the device selected by
+	// its secondary address.
+	addressSelected = 0xfd
+)
+
+// shortFrame builds a frame with just a control and an address field.
+func shortFrame(control, address byte) []byte {
+	return []byte{startShort, control, address, control + address, stop}
+}
+
+// longFrame builds a frame carrying data.
+func longFrame(control, address, ci byte, data []byte) []byte {
+	l := byte(3 + len(data))
+	b := []byte{startLong, l, l, startLong, control, address, ci}
+	b = append(b, data...)
+	var sum byte
+	for _, c := range b[4:] {
+		sum += c
+	}
+	return append(b, sum, stop)
+}
+
+// selectFrame selects a device by its secondary address: the BCD
+// identification number, the manufacturer, version and medium. 0xf nibbles
+// and 0xff bytes are wildcards.
+func selectFrame(id uint32, manufacturer uint16, version, medium byte) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, id)
+	binary.LittleEndian.PutUint16(data[4:], manufacturer)
+	data[6] = version
+	data[7] = medium
+	return longFrame(controlSndUd, addressSelected, ciSelect, data)
+}
+
+// readFrame reads a single character acknowledgement, returned as nil, or
+// a long frame, returned from its control field up to the checksum.
+func readFrame(r io.Reader) ([]byte, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case ack:
+		return nil, nil
+	case startLong:
+	default:
+		return nil, fmt.Errorf("unexpected start character 0x%02x", b[0])
+	}
+
+	h := make([]byte, 3)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, err
+	}
+	if h[0] != h[1] || h[2] != startLong || h[0] < 3 {
+		return nil, errors.New("invalid frame header")
+	}
+	b = make([]byte, int(h[0])+2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	var sum byte
+	for _, c := range b[:h[0]] {
+		sum += c
+	}
+	if b[h[0]] != sum || b[h[0]+1] != stop {
+		return nil, errors.New("invalid frame checksum")
+	}
+	return b[:h[0]], nil
+}
+
+// Header is the fixed part of a variable data response.
+type Header struct {
+	ID           uint32
+	Manufacturer string
+	Version      byte
+	Medium       byte
+	AccessNumber byte
+	Status       byte
+}
+
+// Record is a data record of a variable data response, with the value
+// converted to the base unit in the field name.
+type Record struct {
+	Name  string
+	Value interface{}
+}
+
+// media names the medium codes of EN 13757-3.
+var media = map[byte]string{
+	0x00: "other",
+	0x01: "oil",
+	0x02: "electricity",
+	0x03: "gas",
+	0x04: "heat",
+	0x05: "steam",
+	0x06: "warm_water",
+	0x07: "water",
+	0x08: "heat_cost_allocator",
+	0x0a: "cooling_outlet",
+	0x0b: "cooling_inlet",
+	0x0c: "heat_inlet",
+	0x0d: "heat_cooling",
+	0x15: "hot_water",
+	0x16: "cold_water",
+	0x1a: "smoke_detector",
+}
+
+// decodeResponse decodes a RSP_UD frame with a variable data structure.
+func decodeResponse(frame []byte) (*Header, []Record, error) {
+	if len(frame) < 3 {
+		return nil, nil, errors.New("short frame")
+	}
+	ci := frame[2]
+	data := frame[3:]
+
+	h := &Header{}
+	switch ci {
+	case 0x72, 0x76:
+		// long header, with the identification of the device
+		if len(data) < 12 {
+			return nil, nil, errors.New("short header")
+		}
+		id, err := bcd(data[0:4])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid identification number: %s", err)
+		}
+		h.ID = uint32(id)
+		h.Manufacturer = manufacturer(binary.LittleEndian.Uint16(data[4:]))
+		h.Version = data[6]
+		h.Medium = data[7]
+		h.AccessNumber = data[8]
+		h.Status = data[9]
+		data = data[12:]
+	case 0x7a:
+		if len(data) < 4 {
+			return nil, nil, errors.New("short header")
+		}
+		h.AccessNumber = data[0]
+		h.Status = data[1]
+		data = data[4:]
+	default:
+		return nil, nil, fmt.Errorf("unsupported CI field 0x%02x", ci)
+	}
+
+	records, err := decodeRecords(data)
+	return h, records, err
+}
+
+// manufacturer decodes the three letter manufacturer code.
+func manufacturer(m uint16) string {
+	return string([]byte{
+		byte(m>>10&0x1f) + 64,
+		byte(m>>5&0x1f) + 64,
+		byte(m&0x1f) + 64,
+	})
+}
+
+// bcd decodes a little endian BCD number. A high nibble of 0xf in the most
+// significant byte marks a negative number.
+func bcd(b []byte) (int64, error) {
+	var v int64
+	negative := false
+	for i := len(b) - 1; i >= 0; i-- {
+		hi, lo := b[i]>>4, b[i]&0x0f
+		if i == len(b)-1 && hi == 0x0f {
+			negative = true
+			hi = 0
+		}
+		if hi > 9 || lo > 9 {
+			return 0, fmt.Errorf("invalid BCD digits %x", b)
+		}
+		v = v*100 + int64(hi)*10 + int64(lo)
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+// dataLengths are the lengths of the data field codings of the DIF.
+var dataLengths = [16]int{0, 1, 2, 3, 4, 4, 6, 8, 0, 1, 2, 3, 4, -1, 6, 0}
+
+var functions = []string{"", "_max", "_min", "_error"}
+
+// decodeRecords decodes the data records of a variable data structure.
+// Records whose value or unit are not supported are skipped.
+func decodeRecords(b []byte) ([]Record, error) {
+	var records []Record
+	seen := make(map[string]int)
+	for len(b) > 0 {
+		dif := b[0]
+		b = b[1:]
+		if dif == 0x2f {
+			// idle filler
+			continue
+		}
+		if dif&0x0f == 0x0f {
+			// manufacturer specific data up to the end of the frame
+			return records, nil
+		}
+
+		// the storage number and tariff are spread over the DIF and its
+		// extensions
+		storage := int(dif >> 6 & 0x01)
+		tariff := 0
+		for i, extended := uint(0), dif&0x80 != 0; extended; i++ {
+			if len(b) == 0 {
+				return nil, errors.New("truncated data record")
+			}
+			dife := b[0]
+			b = b[1:]
+			storage |= int(dife&0x0f) << (1 + 4*i)
+			tariff |= int(dife>>4&0x03) << (2 * i)
+			extended = dife&0x80 != 0
+		}
+
+		if len(b) == 0 {
+			return nil, errors.New("truncated data record")
+		}
+		var vifs []byte
+		for {
+			vifs = append(vifs, b[0])
+			b = b[1:]
+			if vifs[len(vifs)-1]&0x80 == 0 {
+				break
+			}
+			if len(b) == 0 {
+				return nil, errors.New("truncated data record")
+			}
+		}
+		// plain text units carry their unit before the data
+		if vifs[0]&0x7f == 0x7c {
+			if len(b) == 0 || len(b) < 1+int(b[0]) {
+				return nil, errors.New("truncated data record")
+			}
+			b = b[1+int(b[0]):]
+		}
+
+		coding := dif & 0x0f
+		length := dataLengths[coding]
+		if length < 0 {
+			// variable length, only strings are supported
+			if len(b) == 0 {
+				return nil, errors.New("truncated data record")
+			}
+			if b[0] > 0xbf {
+				return nil, fmt.Errorf("unsupported variable length 0x%02x", b[0])
+			}
+			length = 1 + int(b[0])
+		}
+		if len(b) < length {
+			return nil, errors.New("truncated data record")
+		}
+		raw := b[:length]
+		b = b[length:]
+
+		name, scale, ok := unit(vifs)
+		if !ok || coding == 0x00 || coding == 0x08 || coding == 0x0d {
+			continue
+		}
+		value, ok := decodeValue(coding, raw)
+		if !ok {
+			continue
+		}
+
+		name += functions[dif>>4&0x03]
+		if storage > 0 {
+			name += fmt.Sprintf("_storage%d", storage)
+		}
+		if tariff > 0 {
+			name += fmt.Sprintf("_tariff%d", tariff)
+		}
+		seen[name]++
+		if seen[name] > 1 {
+			name += fmt.Sprintf("_%d", seen[name])
+		}
+
+		if scale != 0 {
+			value = toFloat(value) * scale
+		}
+		records = append(records, Record{Name: name, Value: value})
+	}
+	return records, nil
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return math.NaN()
+}
+
+// decodeValue decodes integers and BCD numbers to int64 and reals to
+// float64.
+func decodeValue(coding byte, b []byte) (interface{}, bool) {
+	switch coding {
+	case 0x05:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), true
+	case 0x09, 0x0a, 0x0b, 0x0c, 0x0e:
+		v, err := bcd(b)
+		return v, err == nil
+	}
+	// little endian two's complement integer
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(v<<shift) >> shift, true
+}
+
+// unit names the quantity of a VIF and returns the factor converting the
+// value to the unit in the name, or zero for values without a unit, which
+// are kept as integers. Combinable extensions (VIFE) of primary VIFs are
+// ignored.
+func unit(vifs []byte) (string, float64, bool) {
+	vif := vifs[0] & 0x7f
+	n := int(vif & 0x07)
+	nn := int(vif & 0x03)
+
+	switch {
+	case vifs[0] == 0xfb && len(vifs) > 1:
+		return extensionFB(vifs[1] & 0x7f)
+	case vifs[0] == 0xfd && len(vifs) > 1:
+		return extensionFD(vifs[1] & 0x7f)
+	case vif <= 0x07:
+		return "energy_wh", math.Pow10(n - 3), true
+	case vif <= 0x0f:
+		return "energy_j", math.Pow10(n), true
+	case vif <= 0x17:
+		return "volume_m3", math.Pow10(n - 6), true
+	case vif <= 0x1f:
+		return "mass_kg", math.Pow10(n - 3), true
+	case vif <= 0x23:
+		return "on_time_s", durations[nn], true
+	case vif <= 0x27:
+		return "operating_time_s", durations[nn], true
+	case vif <= 0x2f:
+		return "power_w", math.Pow10(n - 3), true
+	case vif <= 0x37:
+		return "power_jh", math.Pow10(n), true
+	case vif <= 0x3f:
+		return "volume_flow_m3h", math.Pow10(n - 6), true
+	case vif <= 0x47:
+		return "volume_flow_m3h", math.Pow10(n-7) * 60, true
+	case vif <= 0x4f:
+		return "volume_flow_m3h", math.Pow10(n-9) * 3600, true
+	case vif <= 0x57:
+		return "mass_flow_kgh", math.Pow10(n - 3), true
+	case vif <= 0x5b:
+		return "flow_temperature_c", math.Pow10(nn - 3), true
+	case vif <= 0x5f:
+		return "return_temperature_c", math.Pow10(nn - 3), true
+	case vif <= 0x63:
+		return "temperature_difference_k", math.Pow10(nn - 3), true
+	case vif <= 0x67:
+		return "external_temperature_c", math.Pow10(nn - 3), true
+	case vif <= 0x6b:
+		return "pressure_bar", math.Pow10(nn - 3), true
+	case vif == 0x6e:
+		return "hca_units", 0, true
+	case vif == 0x78:
+		return "fabrication_number", 0, true
+	}
+	return "", 0, false
+}
+
+// durations converts the time units of on time and operating time to
+// seconds.
+var durations = [4]float64{1, 60, 3600, 86400}
+
+func extensionFB(vife byte) (string, float64, bool) {
+	n := int(vife & 0x01)
+	switch {
+	case vife <= 0x01:
+		// MWh
+		return "energy_wh", math.Pow10(n + 5), true
+	case vife >= 0x08 && vife <= 0x09:
+		// GJ
+		return "energy_j", math.Pow10(n + 8), true
+	case vife >= 0x10 && vife <= 0x11:
+		return "volume_m3", math.Pow10(n + 2), true
+	case vife >= 0x28 && vife <= 0x29:
+		// MW
+		return "power_w", math.Pow10(n + 5), true
+	}
+	return "", 0, false
+}
+
+func extensionFD(vife byte) (string, float64, bool) {
+	switch {
+	case vife >= 0x40 && vife <= 0x4f:
+		return "voltage_v", math.Pow10(int(vife&0x0f) - 9), true
+	case vife >= 0x50 && vife <= 0x5f:
+		return "current_a", math.Pow10(int(vife&0x0f) - 12), true
+	case vife == 0x17:
+		return "error_flags", 0, true
+	}
+	return "", 0, false
+}
+
+// parseSecondary parses a secondary address: the eight digit
+// identification number, optionally followed by the manufacturer code as
+// four hex digits, the version and the medium as two hex digits each. F
+// digits are wildcards.
+func parseSecondary(s string) (id uint32, man uint16, version, medium byte, err error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) != 8 && len(s) != 16 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid secondary address '%s'", s)
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return 0, 0, 0, 0, fmt.Errorf("invalid secondary address '%s'", s)
+		}
+	}
+	for _, c := range s[:8] {
+		if c > '9' && c != 'F' {
+			return 0, 0, 0, 0, fmt.Errorf("invalid secondary address '%s'", s)
+		}
+	}
+
+	hex := func(s string) uint64 {
+		var v uint64
+		for _, c := range s {
+			d := uint64(c - '0')
+			if c >= 'A' {
+				d = uint64(c-'A') + 10
+			}
+			v = v<<4 | d
+		}
+		return v
+	}
+	// the identification number is BCD, its hex notation reads the same
+	id = uint32(hex(s[:8]))
+	man, version, medium = 0xffff, 0xff, 0xff
+	if len(s) == 16 {
+		man = uint16(hex(s[8:12]))
+		version = byte(hex(s[12:14]))
+		medium = byte(hex(s[14:16]))
+	}
+	return id, man, version, medium, nil
+}
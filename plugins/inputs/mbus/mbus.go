@@ -0,0 +1,159 @@
+package mbus
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+type MBus struct {
+	Gateway string
+	Devices []string
+	Timeout internal.Duration
+
+	// the gateway serializes access to the bus
+	sync.Mutex
+}
+
+var sampleConfig = `
+  ## Transparent TCP gateway to the M-Bus, as "host:port"
+  gateway = "192.168.1.70:10001"
+
+  ## Devices to read, by primary address (1-250) or by secondary address:
+  ## the eight digit identification number, optionally followed by the
+  ## manufacturer code as four hex digits, the version and the medium as two
+  ## hex digits each. F digits are wildcards.
+  devices = ["1", "12345678"]
+
+  ## Timeout for each request. Slow bus speeds need a higher value.
+  timeout = "3s"
+`
+
+func (m *MBus) SampleConfig() string {
+	return sampleConfig
+}
+
+func (m *MBus) Description() string {
+	return "Read heat, water and energy meters through an M-Bus TCP gateway"
+}
+
+func (m *MBus) Gather(acc telegraf.Accumulator) error {
+	m.Lock()
+	defer m.Unlock()
+
+	timeout := m.Timeout.Duration
+	if timeout == 0 {
+		timeout = 3 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", m.Gateway, timeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %s", m.Gateway, err)
+	}
+	defer func() { conn.Close() }()
+
+	var failed []string
+	for _, device := range m.Devices {
+		if err := m.gatherDevice(conn, timeout, device, acc); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", device, err))
+			if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+				continue
+			}
+			// drop the late answer of the device with the connection
+			conn.Close()
+			c, err := net.DialTimeout("tcp", m.Gateway, timeout)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("unable to reconnect: %s", err))
+				break
+			}
+			conn = c
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %s", m.Gateway, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (m *MBus) gatherDevice(
+	conn net.Conn,
+	timeout time.Duration,
+	device string,
+	acc telegraf.Accumulator,
+) error {
+	request := func(frame []byte) ([]byte, error) {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(frame); err != nil {
+			return nil, err
+		}
+		return readFrame(conn)
+	}
+
+	address := byte(addressSelected)
+	if primary, err := strconv.ParseUint(device, 10, 8); err == nil && len(device) < 8 {
+		if primary > 250 {
+			return fmt.Errorf("invalid primary address")
+		}
+		address = byte(primary)
+	} else {
+		id, man, version, medium, err := parseSecondary(device)
+		if err != nil {
+			return err
+		}
+		if resp, err := request(selectFrame(id, man, version, medium)); err != nil {
+			return err
+		} else if resp != nil {
+			return fmt.Errorf("unexpected response to selection")
+		}
+	}
+
+	resp, err := request(shortFrame(controlReqUd2, address))
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return fmt.Errorf("unexpected acknowledgement")
+	}
+
+	header, records, err := decodeResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	tags := map[string]string{
+		"gateway": m.Gateway,
+		"address": device,
+	}
+	if header.ID != 0 || header.Manufacturer != "" {
+		tags["id"] = fmt.Sprintf("%08d", header.ID)
+		tags["manufacturer"] = header.Manufacturer
+		if name, ok := media[header.Medium]; ok {
+			tags["medium"] = name
+		} else {
+			tags["medium"] = fmt.Sprintf("0x%02x", header.Medium)
+		}
+	}
+
+	fields := map[string]interface{}{
+		"status": int64(header.Status),
+	}
+	for _, r := range records {
+		fields[r.Name] = r.Value
+	}
+	acc.AddFields("mbus", fields, tags)
+	return nil
+}
+
+func init() {
+	inputs.Add("mbus", func() telegraf.Input {
+		return &MBus{
+			Timeout: internal.Duration{Duration: 3 * time.Second},
+		}
+	})
+}
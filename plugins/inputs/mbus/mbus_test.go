@@ -0,0 +1,198 @@
+package mbus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// heatMeterData is the variable data structure of a heat meter with the
+// identification number 12345678 from KAM.
+var heatMeterData = []byte{
+	0x78, 0x56, 0x34, 0x12, 0x2d, 0x2c, 0x1b, 0x04, 0x05, 0x00, 0x00, 0x00,
+	// energy, 1234 kWh
+	0x04, 0x06, 0xd2, 0x04, 0x00, 0x00,
+	// volume, 567.89 m3
+	0x04, 0x14, 0xd5, 0xdd, 0x00, 0x00,
+	// flow and return temperature, 70 and 40 C
+	0x02, 0x5b, 0x46, 0x00,
+	0x02, 0x5f, 0x28, 0x00,
+	// volume flow, 1.5 m3/h
+	0x04, 0x3b, 0xdc, 0x05, 0x00, 0x00,
+	// power, 12000 W
+	0x04, 0x2b, 0xe0, 0x2e, 0x00, 0x00,
+	// energy at the due date (storage 1), 1000 kWh
+	0x44, 0x06, 0xe8, 0x03, 0x00, 0x00,
+	// energy in tariff 1, 500 kWh
+	0x84, 0x10, 0x06, 0xf4, 0x01, 0x00, 0x00,
+	// fabrication number, 8 digit BCD
+	0x0c, 0x78, 0x11, 0x22, 0x33, 0x44,
+	// idle filler
+	0x2f,
+	// manufacturer specific data
+	0x0f, 0x01, 0x02, 0x03,
+}
+
+var heatMeterFields = map[string]interface{}{
+	"status":               int64(0),
+	"energy_wh":            float64(1234000),
+	"volume_m3":            567.89,
+	"flow_temperature_c":   float64(70),
+	"return_temperature_c": float64(40),
+	"volume_flow_m3h":      1.5,
+	"power_w":              float64(12000),
+	"energy_wh_storage1":   float64(1000000),
+	"energy_wh_tariff1":    float64(500000),
+	"fabrication_number":   int64(44332211),
+}
+
+func TestFrames(t *testing.T) {
+	assert.Equal(t, []byte{0x10, 0x5b, 0x05, 0x60, 0x16}, shortFrame(controlReqUd2, 5))
+	assert.Equal(t, []byte{
+		0x68, 0x0b, 0x0b, 0x68, 0x53, 0xfd, 0x52,
+		0x78, 0x56, 0x34, 0x12, 0xff, 0xff, 0xff, 0xff, 0xb2, 0x16,
+	}, selectFrame(0x12345678, 0xffff, 0xff, 0xff))
+
+	frame := longFrame(0x08, 0x05, 0x72, heatMeterData)
+	b, err := readFrame(bytes.NewReader(frame))
+	require.NoError(t, err)
+	assert.Equal(t, frame[4:len(frame)-2], b)
+
+	frame[len(frame)-2]++
+	_, err = readFrame(bytes.NewReader(frame))
+	assert.EqualError(t, err, "invalid frame checksum")
+
+	b, err = readFrame(bytes.NewReader([]byte{ack}))
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+}
+
+func TestDecodeResponse(t *testing.T) {
+	frame := longFrame(0x08, 0x05, 0x72, heatMeterData)
+	header, records, err := decodeResponse(frame[4 : len(frame)-2])
+	require.NoError(t, err)
+	assert.Equal(t, &Header{
+		ID:           12345678,
+		Manufacturer: "KAM",
+		Version:      0x1b,
+		Medium:       0x04,
+		AccessNumber: 0x05,
+	}, header)
+
+	fields := map[string]interface{}{"status": int64(0)}
+	for _, r := range records {
+		fields[r.Name] = r.Value
+	}
+	for k, v := range heatMeterFields {
+		if f, ok := v.(float64); ok {
+			assert.InDelta(t, f, fields[k], 1e-9, k)
+		} else {
+			assert.Equal(t, v, fields[k], k)
+		}
+	}
+	assert.Len(t, fields, len(heatMeterFields))
+}
+
+func TestBCD(t *testing.T) {
+	v, err := bcd([]byte{0x78, 0x56, 0x34, 0x12})
+	require.NoError(t, err)
+	assert.Equal(t, int64(12345678), v)
+
+	v, err = bcd([]byte{0x25, 0xf0})
+	require.NoError(t, err)
+	assert.Equal(t, int64(-25), v)
+
+	_, err = bcd([]byte{0x1a})
+	assert.Error(t, err)
+}
+
+func TestParseSecondary(t *testing.T) {
+	id, man, version, medium, err := parseSecondary("12345678")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x12345678), id)
+	assert.Equal(t, uint16(0xffff), man)
+	assert.Equal(t, byte(0xff), version)
+	assert.Equal(t, byte(0xff), medium)
+
+	id, man, version, medium, err = parseSecondary("1234ffff2c2d1b04")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234ffff), id)
+	assert.Equal(t, uint16(0x2c2d), man)
+	assert.Equal(t, byte(0x1b), version)
+	assert.Equal(t, byte(0x04), medium)
+
+	for _, s := range []string{"1234567", "1234567A", "12345678zzzz1b04"} {
+		_, _, _, _, err = parseSecondary(s)
+		assert.Error(t, err, s)
+	}
+}
+
+// fakeGateway answers for the heat meter at primary address 5, which can
+// also be selected by its secondary address. Other devices do not answer.
+func fakeGateway(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				selected := false
+				buf := make([]byte, 64)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					b := buf[:n]
+					switch {
+					case b[0] == startLong && b[5] == addressSelected:
+						selected = bytes.Equal(b[7:11], []byte{0x78, 0x56, 0x34, 0x12})
+						if selected {
+							conn.Write([]byte{ack})
+						}
+					case b[0] == startShort && (b[2] == 5 || b[2] == addressSelected && selected):
+						conn.Write(longFrame(0x08, 0x05, 0x72, heatMeterData))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestGather(t *testing.T) {
+	addr, stop := fakeGateway(t)
+	defer stop()
+
+	m := &MBus{
+		Gateway: addr,
+		Devices: []string{"5", "12345678", "7"},
+		Timeout: internal.Duration{Duration: 100 * time.Millisecond},
+	}
+	var acc testutil.Accumulator
+	err := m.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "7: ")
+
+	for _, address := range []string{"5", "12345678"} {
+		acc.AssertContainsTaggedFields(t, "mbus", heatMeterFields,
+			map[string]string{
+				"gateway":      addr,
+				"address":      address,
+				"id":           "12345678",
+				"manufacturer": "KAM",
+				"medium":       "heat",
+			})
+	}
+}
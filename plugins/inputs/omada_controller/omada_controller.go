@@ -0,0 +1,374 @@
+package omada_controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// pageSize is the number of rows requested per page of paginated lists.
+const pageSize = 1000
+
+// errLoginRequired is the error code returned once the session expired.
+const errLoginRequired = -1200
+
+type OmadaController struct {
+	URL      string
+	Username string
+	Password string
+	Sites    []string
+	Timeout  internal.Duration
+
+	SSLCA              string `toml:"ssl_ca"`
+	SSLCert            string `toml:"ssl_cert"`
+	SSLKey             string `toml:"ssl_key"`
+	InsecureSkipVerify bool
+
+	client       *devicehttp.Client
+	controllerID string
+	token        string
+}
+
+var sampleConfig = `
+  ## URL of the Omada controller (software controller or OC200/OC300)
+  url = "https://127.0.0.1:8043"
+
+  ## Credentials of a controller user; a viewer account is sufficient
+  username = "telegraf"
+  password = ""
+
+  ## Names of the sites to gather, all sites if empty
+  # sites = ["Default"]
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification; the controller uses a
+  ## self-signed certificate by default
+  insecure_skip_verify = true
+`
+
+func (o *OmadaController) SampleConfig() string {
+	return sampleConfig
+}
+
+func (o *OmadaController) Description() string {
+	return "Read device, client and SSID statistics from TP-Link Omada SDN controllers"
+}
+
+// envelope wraps every response of the controller API.
+type envelope struct {
+	ErrorCode int             `json:"errorCode"`
+	Msg       string          `json:"msg"`
+	Result    json.RawMessage `json:"result"`
+}
+
+type page struct {
+	TotalRows int             `json:"totalRows"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type site struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type device struct {
+	Type      string  `json:"type"`
+	MAC       string  `json:"mac"`
+	Name      string  `json:"name"`
+	Model     string  `json:"model"`
+	Status    int64   `json:"status"`
+	CPUUtil   float64 `json:"cpuUtil"`
+	MemUtil   float64 `json:"memUtil"`
+	Uptime    int64   `json:"uptimeLong"`
+	ClientNum int64   `json:"clientNum"`
+	Download  int64   `json:"download"`
+	Upload    int64   `json:"upload"`
+}
+
+type station struct {
+	MAC         string `json:"mac"`
+	Wireless    bool   `json:"wireless"`
+	SSID        string `json:"ssid"`
+	TrafficDown int64  `json:"trafficDown"`
+	TrafficUp   int64  `json:"trafficUp"`
+}
+
+func (o *OmadaController) Gather(acc telegraf.Accumulator) error {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", o.URL, err)
+	}
+
+	if o.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			SSLCA:              o.SSLCA,
+			SSLCert:            o.SSLCert,
+			SSLKey:             o.SSLKey,
+			InsecureSkipVerify: o.InsecureSkipVerify,
+			Timeout:            o.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		// the session is kept in the TPOMADA_SESSIONID cookie
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		client.HTTPClient.Jar = jar
+		o.client = client
+	}
+
+	if o.controllerID == "" {
+		var info struct {
+			ControllerID string `json:"omadacId"`
+		}
+		if err := o.call("GET", "/api/info", nil, &info); err != nil {
+			return err
+		}
+		o.controllerID = info.ControllerID
+	}
+
+	var sites []site
+	if err := o.list("/sites", &sites); err != nil {
+		return err
+	}
+
+	for _, s := range sites {
+		if !o.wantSite(s.Name) {
+			continue
+		}
+		tags := map[string]string{"server": u.Host, "site": s.Name}
+		if err := o.gatherSite(s.ID, tags, acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OmadaController) wantSite(name string) bool {
+	if len(o.Sites) == 0 {
+		return true
+	}
+	for _, s := range o.Sites {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OmadaController) gatherSite(
+	id string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	var devices []device
+	if err := o.get("/sites/"+id+"/devices", &devices); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		dtags := copyTags(tags)
+		dtags["mac"] = d.MAC
+		dtags["type"] = d.Type
+		if d.Name != "" {
+			dtags["name"] = d.Name
+		}
+		if d.Model != "" {
+			dtags["model"] = d.Model
+		}
+		acc.AddFields("omada_device", map[string]interface{}{
+			"status":   d.Status,
+			"cpu_util": d.CPUUtil,
+			"mem_util": d.MemUtil,
+			"uptime":   d.Uptime,
+			"clients":  d.ClientNum,
+			"rx_bytes": d.Download,
+			"tx_bytes": d.Upload,
+		}, dtags)
+	}
+
+	var clients []station
+	if err := o.list("/sites/"+id+"/clients?filters.active=true", &clients); err != nil {
+		return err
+	}
+
+	ssids := make(map[string]map[string]interface{})
+	wired := 0
+	for _, c := range clients {
+		if !c.Wireless {
+			wired++
+			continue
+		}
+		fields, ok := ssids[c.SSID]
+		if !ok {
+			fields = map[string]interface{}{
+				"clients":  0,
+				"rx_bytes": int64(0),
+				"tx_bytes": int64(0),
+			}
+			ssids[c.SSID] = fields
+		}
+		fields["clients"] = fields["clients"].(int) + 1
+		// traffic is reported from the point of view of the client
+		fields["rx_bytes"] = fields["rx_bytes"].(int64) + c.TrafficUp
+		fields["tx_bytes"] = fields["tx_bytes"].(int64) + c.TrafficDown
+	}
+	for ssid, fields := range ssids {
+		stags := copyTags(tags)
+		stags["ssid"] = ssid
+		acc.AddFields("omada_ssid", fields, stags)
+	}
+
+	acc.AddFields("omada_site", map[string]interface{}{
+		"devices":          len(devices),
+		"clients":          len(clients),
+		"wireless_clients": len(clients) - wired,
+		"wired_clients":    wired,
+	}, tags)
+	return nil
+}
+
+// list fetches every page of a paginated list below the controller API and
+// decodes the rows into v, which must point to a slice.
+func (o *OmadaController) list(path string, v interface{}) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	var rows []json.RawMessage
+	for n := 1; ; n++ {
+		var p page
+		err := o.get(fmt.Sprintf("%s%scurrentPage=%d&currentPageSize=%d",
+			path, sep, n, pageSize), &p)
+		if err != nil {
+			return err
+		}
+
+		var data []json.RawMessage
+		if len(p.Data) > 0 {
+			if err := json.Unmarshal(p.Data, &data); err != nil {
+				return fmt.Errorf("unable to parse %s: %s", path, err)
+			}
+		}
+		rows = append(rows, data...)
+		if len(data) == 0 || len(rows) >= p.TotalRows {
+			break
+		}
+	}
+
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// get calls the controller API, logging in first if there is no session yet
+// or the session expired.
+func (o *OmadaController) get(path string, v interface{}) error {
+	if o.token == "" {
+		if err := o.login(); err != nil {
+			return err
+		}
+	}
+
+	path = "/" + o.controllerID + "/api/v2" + path
+	err := o.call("GET", path, nil, v)
+	if aerr, ok := err.(*apiError); ok && aerr.Code == errLoginRequired {
+		if err := o.login(); err != nil {
+			return err
+		}
+		err = o.call("GET", path, nil, v)
+	}
+	return err
+}
+
+func (o *OmadaController) login() error {
+	o.token = ""
+	body, err := json.Marshal(map[string]string{
+		"username": o.Username,
+		"password": o.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	path := "/" + o.controllerID + "/api/v2/login"
+	if err := o.call("POST", path, body, &result); err != nil {
+		return fmt.Errorf("login to %s failed: %s", o.URL, err)
+	}
+	o.token = result.Token
+	return nil
+}
+
+type apiError struct {
+	Code int
+	Msg  string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("controller returned error %d: %s", e.Code, e.Msg)
+}
+
+func (o *OmadaController) call(method, path string, body []byte, v interface{}) error {
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	if o.token != "" {
+		header.Set("Csrf-Token", o.token)
+	}
+
+	b, err := o.client.Do(method, strings.TrimRight(o.URL, "/")+path, header, body)
+	if err != nil {
+		return err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return fmt.Errorf("unable to parse response of %s: %s", path, err)
+	}
+	if env.ErrorCode != 0 {
+		return &apiError{Code: env.ErrorCode, Msg: env.Msg}
+	}
+	if len(env.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(env.Result, v); err != nil {
+		return fmt.Errorf("unable to parse response of %s: %s", path, err)
+	}
+	return nil
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags)+4)
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+func init() {
+	inputs.Add("omada_controller", func() telegraf.Input {
+		return &OmadaController{}
+	})
+}
@@ -0,0 +1,195 @@
+package omada_controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sitesReply = `{"totalRows":2,"currentPage":1,"data":[
+		{"id":"s1","name":"Default"},{"id":"s2","name":"Office"}]}`
+	devicesReply = `[{"type":"ap","mac":"AA-BB-CC-00-00-01","name":"Hall",
+		"model":"EAP245","status":14,"cpuUtil":7,"memUtil":55.5,
+		"uptimeLong":3600,"clientNum":2,"download":1000,"upload":2000}]`
+	clientsPage1 = `{"totalRows":3,"currentPage":1,"data":[
+		{"mac":"11-11-11-11-11-11","wireless":true,"ssid":"home",
+		 "trafficDown":100,"trafficUp":10},
+		{"mac":"22-22-22-22-22-22","wireless":true,"ssid":"home",
+		 "trafficDown":200,"trafficUp":20}]}`
+	clientsPage2 = `{"totalRows":3,"currentPage":2,"data":[
+		{"mac":"33-33-33-33-33-33","wireless":false,
+		 "trafficDown":300,"trafficUp":30}]}`
+)
+
+type fakeController struct {
+	logins  int
+	expired bool
+}
+
+func (f *fakeController) reply(w http.ResponseWriter, result string) {
+	fmt.Fprintf(w, `{"errorCode":0,"msg":"Success.","result":%s}`, result)
+}
+
+func (f *fakeController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/info" {
+		f.reply(w, `{"omadacId":"cid","controllerVer":"5.9.31"}`)
+		return
+	}
+
+	if r.URL.Path == "/cid/api/v2/login" {
+		var creds map[string]string
+		json.NewDecoder(r.Body).Decode(&creds)
+		if r.Method != "POST" || creds["username"] != "admin" ||
+			creds["password"] != "secret" {
+			fmt.Fprint(w, `{"errorCode":-30109,"msg":"Invalid username or password."}`)
+			return
+		}
+		f.logins++
+		http.SetCookie(w, &http.Cookie{Name: "TPOMADA_SESSIONID", Value: "session"})
+		f.reply(w, `{"roleType":0,"token":"tok"}`)
+		return
+	}
+
+	cookie, err := r.Cookie("TPOMADA_SESSIONID")
+	if f.expired || err != nil || cookie.Value != "session" ||
+		r.Header.Get("Csrf-Token") != "tok" {
+		f.expired = false
+		fmt.Fprint(w, `{"errorCode":-1200,"msg":"Failed to login."}`)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/cid/api/v2") {
+	case "/sites":
+		f.reply(w, sitesReply)
+	case "/sites/s1/devices":
+		f.reply(w, devicesReply)
+	case "/sites/s2/devices":
+		f.reply(w, `[]`)
+	case "/sites/s1/clients":
+		if r.URL.Query().Get("currentPage") == "2" {
+			f.reply(w, clientsPage2)
+		} else {
+			f.reply(w, clientsPage1)
+		}
+	case "/sites/s2/clients":
+		f.reply(w, `{"totalRows":0,"currentPage":1,"data":[]}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newOmada(u string) *OmadaController {
+	return &OmadaController{
+		URL:      u,
+		Username: "admin",
+		Password: "secret",
+	}
+}
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{})
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	o := newOmada(ts.URL)
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+
+	acc.AssertContainsTaggedFields(t, "omada_device",
+		map[string]interface{}{
+			"status":   int64(14),
+			"cpu_util": 7.0,
+			"mem_util": 55.5,
+			"uptime":   int64(3600),
+			"clients":  int64(2),
+			"rx_bytes": int64(1000),
+			"tx_bytes": int64(2000),
+		},
+		map[string]string{
+			"server": u.Host,
+			"site":   "Default",
+			"mac":    "AA-BB-CC-00-00-01",
+			"type":   "ap",
+			"name":   "Hall",
+			"model":  "EAP245",
+		})
+
+	acc.AssertContainsTaggedFields(t, "omada_ssid",
+		map[string]interface{}{
+			"clients":  2,
+			"rx_bytes": int64(30),
+			"tx_bytes": int64(300),
+		},
+		map[string]string{"server": u.Host, "site": "Default", "ssid": "home"})
+
+	acc.AssertContainsTaggedFields(t, "omada_site",
+		map[string]interface{}{
+			"devices":          1,
+			"clients":          3,
+			"wireless_clients": 2,
+			"wired_clients":    1,
+		},
+		map[string]string{"server": u.Host, "site": "Default"})
+	acc.AssertContainsTaggedFields(t, "omada_site",
+		map[string]interface{}{
+			"devices":          0,
+			"clients":          0,
+			"wireless_clients": 0,
+			"wired_clients":    0,
+		},
+		map[string]string{"server": u.Host, "site": "Office"})
+}
+
+func TestSiteFilter(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{})
+	defer ts.Close()
+
+	o := newOmada(ts.URL)
+	o.Sites = []string{"Office"}
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+
+	assert.False(t, acc.HasMeasurement("omada_device"))
+	assert.Equal(t, 1, len(acc.Metrics))
+	assert.Equal(t, "Office", acc.Metrics[0].Tags["site"])
+}
+
+func TestRelogin(t *testing.T) {
+	controller := &fakeController{}
+	ts := httptest.NewServer(controller)
+	defer ts.Close()
+
+	o := newOmada(ts.URL)
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+	require.NoError(t, o.Gather(&acc))
+	assert.Equal(t, 1, controller.logins)
+
+	controller.expired = true
+	require.NoError(t, o.Gather(&acc))
+	assert.Equal(t, 2, controller.logins)
+}
+
+func TestLoginFailure(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{})
+	defer ts.Close()
+
+	o := newOmada(ts.URL)
+	o.Password = "wrong"
+	var acc testutil.Accumulator
+	err := o.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid username or password")
+	assert.Equal(t, 0, len(acc.Metrics))
+}
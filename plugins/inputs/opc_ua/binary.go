@@ -0,0 +1,625 @@
+package opc_ua
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NodeID identifies a node in the address space of a server.
+type NodeID struct {
+	Namespace uint16
+	// Type is 'i' for numeric, 's' for string, 'g' for GUID and 'b' for
+	// opaque identifiers.
+	Type    byte
+	Numeric uint32
+	// Value holds the string, the GUID in its binary encoding or the
+	// opaque identifier.
+	Value []byte
+}
+
+// ParseNodeID parses the string notation of a node id, such as
+// "ns=2;s=Channel1.Device1.Tag1" or "i=2258".
+func ParseNodeID(s string) (NodeID, error) {
+	var n NodeID
+	id := s
+	if strings.HasPrefix(id, "ns=") {
+		i := strings.Index(id, ";")
+		if i < 0 {
+			return n, fmt.Errorf("invalid node id '%s'", s)
+		}
+		ns, err := strconv.ParseUint(id[3:i], 10, 16)
+		if err != nil {
+			return n, fmt.Errorf("invalid namespace in node id '%s'", s)
+		}
+		n.Namespace = uint16(ns)
+		id = id[i+1:]
+	}
+	if len(id) < 2 || id[1] != '=' {
+		return n, fmt.Errorf("invalid node id '%s'", s)
+	}
+
+	n.Type = id[0]
+	v := id[2:]
+	switch n.Type {
+	case 'i':
+		i, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return n, fmt.Errorf("invalid numeric identifier in node id '%s'", s)
+		}
+		n.Numeric = uint32(i)
+	case 's':
+		n.Value = []byte(v)
+	case 'g':
+		g, err := hex.DecodeString(strings.Replace(v, "-", "", -1))
+		if err != nil || len(g) != 16 || len(v) != 36 {
+			return n, fmt.Errorf("invalid GUID in node id '%s'", s)
+		}
+		// the first three groups are encoded in little-endian order
+		n.Value = []byte{
+			g[3], g[2], g[1], g[0], g[5], g[4], g[7], g[6],
+			g[8], g[9], g[10], g[11], g[12], g[13], g[14], g[15],
+		}
+	case 'b':
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return n, fmt.Errorf("invalid opaque identifier in node id '%s'", s)
+		}
+		n.Value = b
+	default:
+		return n, fmt.Errorf("invalid identifier type in node id '%s'", s)
+	}
+	return n, nil
+}
+
+// numericNodeID returns the node id of a standard node.
+func numericNodeID(id uint32) NodeID {
+	return NodeID{Type: 'i', Numeric: id}
+}
+
+// Built-in types of variants.
+const (
+	typeBoolean         = 1
+	typeSByte           = 2
+	typeByte            = 3
+	typeInt16           = 4
+	typeUInt16          = 5
+	typeInt32           = 6
+	typeUInt32          = 7
+	typeInt64           = 8
+	typeUInt64          = 9
+	typeFloat           = 10
+	typeDouble          = 11
+	typeString          = 12
+	typeDateTime        = 13
+	typeGUID            = 14
+	typeByteString      = 15
+	typeXMLElement      = 16
+	typeNodeID          = 17
+	typeExpandedNodeID  = 18
+	typeStatusCode      = 19
+	typeQualifiedName   = 20
+	typeLocalizedText   = 21
+	typeExtensionObject = 22
+)
+
+// epoch is the start of the DateTime type, in 100 nanosecond intervals
+// before the Unix epoch.
+const epoch = 116444736000000000
+
+// encoder appends values in the OPC UA binary encoding.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) byte(v byte) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.b = append(e.b, byte(v), byte(v>>8))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.b = append(e.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (e *encoder) int32(v int32) {
+	e.uint32(uint32(v))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.uint32(uint32(v))
+	e.uint32(uint32(v >> 32))
+}
+
+func (e *encoder) float64(v float64) {
+	e.uint64(math.Float64bits(v))
+}
+
+// bytes encodes a ByteString, nil being the null value.
+func (e *encoder) bytes(v []byte) {
+	if v == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	e.b = append(e.b, v...)
+}
+
+// string encodes a String, the empty string being the null value.
+func (e *encoder) string(v string) {
+	if v == "" {
+		e.int32(-1)
+		return
+	}
+	e.bytes([]byte(v))
+}
+
+func (e *encoder) strings(v []string) {
+	if v == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	for _, s := range v {
+		e.string(s)
+	}
+}
+
+func (e *encoder) dateTime(t time.Time) {
+	if t.IsZero() {
+		e.uint64(0)
+		return
+	}
+	e.uint64(uint64(t.UnixNano()/100 + epoch))
+}
+
+func (e *encoder) nodeID(n NodeID) {
+	switch n.Type {
+	case 'i', 0:
+		switch {
+		case n.Namespace == 0 && n.Numeric < 256:
+			e.b = append(e.b, 0x00, byte(n.Numeric))
+		case n.Namespace < 256 && n.Numeric < 65536:
+			e.b = append(e.b, 0x01, byte(n.Namespace))
+			e.uint16(uint16(n.Numeric))
+		default:
+			e.byte(0x02)
+			e.uint16(n.Namespace)
+			e.uint32(n.Numeric)
+		}
+	case 's':
+		e.byte(0x03)
+		e.uint16(n.Namespace)
+		e.bytes(n.Value)
+	case 'g':
+		e.byte(0x04)
+		e.uint16(n.Namespace)
+		e.b = append(e.b, n.Value...)
+	case 'b':
+		e.byte(0x05)
+		e.uint16(n.Namespace)
+		e.bytes(n.Value)
+	}
+}
+
+func (e *encoder) localizedText(text string) {
+	if text == "" {
+		e.byte(0)
+		return
+	}
+	e.byte(0x02)
+	e.string(text)
+}
+
+// extensionObject encodes a structure with its binary encoding id.
+func (e *encoder) extensionObject(typeID uint32, body []byte) {
+	if body == nil {
+		e.nodeID(NodeID{})
+		e.byte(0)
+		return
+	}
+	e.nodeID(numericNodeID(typeID))
+	e.byte(0x01)
+	e.bytes(body)
+}
+
+// decoder reads values in the OPC UA binary encoding. The first error is
+// kept and makes all further reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+var errTruncated = errors.New("truncated message")
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errTruncated
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *decoder) float64() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	b := d.take(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// arrayLength reads the length of an array, treating a null array as
+// empty.
+func (d *decoder) arrayLength() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.b) && d.err == nil {
+		// every element takes at least one octet
+		d.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) strings() []string {
+	n := d.arrayLength()
+	var v []string
+	for i := 0; i < n && d.err == nil; i++ {
+		v = append(v, d.string())
+	}
+	return v
+}
+
+func (d *decoder) dateTime() time.Time {
+	v := int64(d.uint64())
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, (v-epoch)*100)
+}
+
+func (d *decoder) nodeID() NodeID {
+	var n NodeID
+	encoding := d.byte()
+	switch encoding & 0x3f {
+	case 0x00:
+		n.Type = 'i'
+		n.Numeric = uint32(d.byte())
+	case 0x01:
+		n.Type = 'i'
+		n.Namespace = uint16(d.byte())
+		n.Numeric = uint32(d.uint16())
+	case 0x02:
+		n.Type = 'i'
+		n.Namespace = d.uint16()
+		n.Numeric = d.uint32()
+	case 0x03:
+		n.Type = 's'
+		n.Namespace = d.uint16()
+		n.Value = d.bytes()
+	case 0x04:
+		n.Type = 'g'
+		n.Namespace = d.uint16()
+		n.Value = append([]byte(nil), d.take(16)...)
+	case 0x05:
+		n.Type = 'b'
+		n.Namespace = d.uint16()
+		n.Value = d.bytes()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("invalid node id encoding 0x%02x", encoding)
+		}
+	}
+	// the namespace URI and server index of expanded node ids
+	if encoding&0x80 != 0 {
+		d.string()
+	}
+	if encoding&0x40 != 0 {
+		d.uint32()
+	}
+	return n
+}
+
+func (d *decoder) localizedText() string {
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		d.string()
+	}
+	if mask&0x02 != 0 {
+		return d.string()
+	}
+	return ""
+}
+
+func (d *decoder) diagnosticInfo() {
+	mask := d.byte()
+	for _, bit := range []byte{0x01, 0x02, 0x04, 0x08} {
+		if mask&bit != 0 {
+			d.int32()
+		}
+	}
+	if mask&0x10 != 0 {
+		d.string()
+	}
+	if mask&0x20 != 0 {
+		d.uint32()
+	}
+	if mask&0x40 != 0 && d.err == nil {
+		d.diagnosticInfo()
+	}
+}
+
+func (d *decoder) diagnosticInfos() {
+	n := d.arrayLength()
+	for i := 0; i < n && d.err == nil; i++ {
+		d.diagnosticInfo()
+	}
+}
+
+// extensionObject reads an extension object and returns the id of its
+// type and its body.
+func (d *decoder) extensionObject() (NodeID, []byte) {
+	typeID := d.nodeID()
+	switch d.byte() {
+	case 0x01, 0x02:
+		return typeID, d.bytes()
+	}
+	return typeID, nil
+}
+
+// variant reads a variant. Scalars are returned as Go values and arrays as
+// []interface{}; values of other types are returned as an error.
+func (d *decoder) variant() (interface{}, error) {
+	mask := d.byte()
+	t := mask & 0x3f
+	if mask&0x80 == 0 {
+		return d.scalar(t)
+	}
+
+	n := d.arrayLength()
+	values := make([]interface{}, 0, n)
+	var err error
+	for i := 0; i < n && d.err == nil; i++ {
+		var v interface{}
+		if v, err = d.scalar(t); err != nil {
+			break
+		}
+		values = append(values, v)
+	}
+	if mask&0x40 != 0 {
+		dims := d.arrayLength()
+		for i := 0; i < dims; i++ {
+			d.int32()
+		}
+	}
+	return values, err
+}
+
+func (d *decoder) scalar(t byte) (interface{}, error) {
+	switch t {
+	case 0:
+		return nil, nil
+	case typeBoolean:
+		return d.bool(), nil
+	case typeSByte:
+		return int64(int8(d.byte())), nil
+	case typeByte:
+		return int64(d.byte()), nil
+	case typeInt16:
+		return int64(int16(d.uint16())), nil
+	case typeUInt16:
+		return int64(d.uint16()), nil
+	case typeInt32:
+		return int64(d.int32()), nil
+	case typeUInt32:
+		return int64(d.uint32()), nil
+	case typeInt64:
+		return int64(d.uint64()), nil
+	case typeUInt64:
+		return d.uint64(), nil
+	case typeFloat:
+		return float64(math.Float32frombits(d.uint32())), nil
+	case typeDouble:
+		return d.float64(), nil
+	case typeString, typeXMLElement:
+		return d.string(), nil
+	case typeDateTime:
+		return d.dateTime().UnixNano(), nil
+	case typeGUID:
+		return hex.EncodeToString(d.take(16)), nil
+	case typeByteString:
+		return hex.EncodeToString(d.bytes()), nil
+	case typeNodeID, typeExpandedNodeID:
+		d.nodeID()
+		return nil, fmt.Errorf("unsupported variant type %d", t)
+	case typeExtensionObject:
+		d.extensionObject()
+		return nil, errors.New("structures are not supported")
+	case typeStatusCode:
+		return int64(d.uint32()), nil
+	case typeQualifiedName:
+		d.uint16()
+		return d.string(), nil
+	case typeLocalizedText:
+		return d.localizedText(), nil
+	}
+	// the remaining types cannot be skipped without decoding them
+	d.err = fmt.Errorf("unsupported variant type %d", t)
+	return nil, d.err
+}
+
+// DataValue is the value of an attribute with its status.
+type DataValue struct {
+	Value  interface{}
+	Status StatusCode
+	// Err is set if the value is of an unsupported type.
+	Err             error
+	SourceTimestamp time.Time
+}
+
+func (d *decoder) dataValue() DataValue {
+	var v DataValue
+	mask := d.byte()
+	if mask&0x01 != 0 {
+		v.Value, v.Err = d.variant()
+	}
+	if mask&0x02 != 0 {
+		v.Status = StatusCode(d.uint32())
+	}
+	if mask&0x04 != 0 {
+		v.SourceTimestamp = d.dateTime()
+	}
+	if mask&0x08 != 0 {
+		d.dateTime()
+	}
+	if mask&0x10 != 0 {
+		d.uint16()
+	}
+	if mask&0x20 != 0 {
+		d.uint16()
+	}
+	return v
+}
+
+// StatusCode is the result of an operation.
+type StatusCode uint32
+
+// Status codes the plugin handles or reports by name.
+const (
+	StatusBadUnexpectedError           StatusCode = 0x80010000
+	StatusBadInternalError             StatusCode = 0x80020000
+	StatusBadTimeout                   StatusCode = 0x800a0000
+	StatusBadServiceUnsupported        StatusCode = 0x800b0000
+	StatusBadCertificateInvalid        StatusCode = 0x80120000
+	StatusBadSecurityChecksFailed      StatusCode = 0x80130000
+	StatusBadCertificateUntrusted      StatusCode = 0x801a0000
+	StatusBadIdentityTokenInvalid      StatusCode = 0x80200000
+	StatusBadIdentityTokenRejected     StatusCode = 0x80210000
+	StatusBadSecureChannelIDInvalid    StatusCode = 0x80220000
+	StatusBadSessionIDInvalid          StatusCode = 0x80250000
+	StatusBadSessionClosed             StatusCode = 0x80260000
+	StatusBadSessionNotActivated       StatusCode = 0x80270000
+	StatusBadTooManySessions           StatusCode = 0x80560000
+	StatusBadNodeIDInvalid             StatusCode = 0x80330000
+	StatusBadNodeIDUnknown             StatusCode = 0x80340000
+	StatusBadAttributeIDInvalid        StatusCode = 0x80350000
+	StatusBadNotReadable               StatusCode = 0x803a0000
+	StatusBadUserAccessDenied          StatusCode = 0x801f0000
+	StatusBadSecurityPolicyRejected    StatusCode = 0x80550000
+	StatusBadWaitingForInitialData     StatusCode = 0x80320000
+	StatusBadTCPEndpointURLInvalid     StatusCode = 0x80830000
+	StatusBadSecureChannelTokenUnknown StatusCode = 0x80870000
+)
+
+var statusNames = map[StatusCode]string{
+	StatusBadUnexpectedError:           "BadUnexpectedError",
+	StatusBadInternalError:             "BadInternalError",
+	StatusBadTimeout:                   "BadTimeout",
+	StatusBadServiceUnsupported:        "BadServiceUnsupported",
+	StatusBadCertificateInvalid:        "BadCertificateInvalid",
+	StatusBadSecurityChecksFailed:      "BadSecurityChecksFailed",
+	StatusBadCertificateUntrusted:      "BadCertificateUntrusted",
+	StatusBadIdentityTokenInvalid:      "BadIdentityTokenInvalid",
+	StatusBadIdentityTokenRejected:     "BadIdentityTokenRejected",
+	StatusBadSecureChannelIDInvalid:    "BadSecureChannelIdInvalid",
+	StatusBadSessionIDInvalid:          "BadSessionIdInvalid",
+	StatusBadSessionClosed:             "BadSessionClosed",
+	StatusBadSessionNotActivated:       "BadSessionNotActivated",
+	StatusBadTooManySessions:           "BadTooManySessions",
+	StatusBadNodeIDInvalid:             "BadNodeIdInvalid",
+	StatusBadNodeIDUnknown:             "BadNodeIdUnknown",
+	StatusBadAttributeIDInvalid:        "BadAttributeIdInvalid",
+	StatusBadNotReadable:               "BadNotReadable",
+	StatusBadUserAccessDenied:          "BadUserAccessDenied",
+	StatusBadSecurityPolicyRejected:    "BadSecurityPolicyRejected",
+	StatusBadWaitingForInitialData:     "BadWaitingForInitialData",
+	StatusBadTCPEndpointURLInvalid:     "BadTcpEndpointUrlInvalid",
+	StatusBadSecureChannelTokenUnknown: "BadSecureChannelTokenUnknown",
+}
+
+// IsBad reports whether the status is a failure.
+func (s StatusCode) IsBad() bool {
+	return s&0x80000000 != 0
+}
+
+func (s StatusCode) Error() string {
+	if name, ok := statusNames[s&0xffff0000]; ok {
+		return name
+	}
+	return fmt.Sprintf("status 0x%08x", uint32(s))
+}
@@ -0,0 +1,512 @@
+package opc_ua
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Security policies supported by the plugin.
+const (
+	policyNone           = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	policyBasic256Sha256 = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+)
+
+// Message security modes.
+const (
+	modeNone           = 1
+	modeSign           = 2
+	modeSignAndEncrypt = 3
+)
+
+// Key and block sizes of Basic256Sha256.
+const (
+	signatureKeyLength  = 32
+	encryptionKeyLength = 32
+	nonceLength         = 32
+	// RSA-OAEP with SHA-1 takes 42 octets of every block
+	oaepOverhead = 42
+)
+
+// receiveBufferSize is the largest message chunk the client accepts.
+const receiveBufferSize = 65536
+
+// symmetricKeys secure the messages sent by one side of a channel.
+type symmetricKeys struct {
+	signing    []byte
+	encryption []byte
+	iv         []byte
+}
+
+// pSHA256 is the pseudo random function deriving the symmetric keys.
+func pSHA256(secret, seed []byte, length int) []byte {
+	var out []byte
+	a := seed
+	for len(out) < length {
+		h := hmac.New(sha256.New, secret)
+		h.Write(a)
+		a = h.Sum(nil)
+		h = hmac.New(sha256.New, secret)
+		h.Write(a)
+		h.Write(seed)
+		out = h.Sum(out)
+	}
+	return out[:length]
+}
+
+func deriveKeys(secret, seed []byte) symmetricKeys {
+	b := pSHA256(secret, seed, signatureKeyLength+encryptionKeyLength+aes.BlockSize)
+	return symmetricKeys{
+		signing:    b[:signatureKeyLength],
+		encryption: b[signatureKeyLength : signatureKeyLength+encryptionKeyLength],
+		iv:         b[signatureKeyLength+encryptionKeyLength:],
+	}
+}
+
+// secureChannel exchanges messages over OPC UA TCP, signing and encrypting
+// them as the security policy and mode require. It is used from one side of
+// the connection: local is the side using it, remote the peer.
+type secureChannel struct {
+	conn   net.Conn
+	policy string
+	mode   int32
+
+	localKey  *rsa.PrivateKey
+	localCert []byte
+	// remoteCert is the certificate of the peer, in DER.
+	remoteCert []byte
+	remoteKey  *rsa.PublicKey
+
+	channelID uint32
+	tokenID   uint32
+	local     symmetricKeys
+	remote    symmetricKeys
+
+	sequence uint32
+	// sendBufferSize is the largest chunk the peer accepts.
+	sendBufferSize uint32
+}
+
+func (c *secureChannel) secure() bool {
+	return c.policy != policyNone && c.policy != ""
+}
+
+// setRemoteCertificate sets the certificate of the peer, which must have an
+// RSA key.
+func (c *secureChannel) setRemoteCertificate(der []byte) error {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %s", err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("certificate without RSA key")
+	}
+	c.remoteCert = der
+	c.remoteKey = key
+	return nil
+}
+
+// setNonces derives the symmetric keys from the nonces exchanged when the
+// channel was opened or renewed.
+func (c *secureChannel) setNonces(localNonce, remoteNonce []byte) {
+	c.local = deriveKeys(remoteNonce, localNonce)
+	c.remote = deriveKeys(localNonce, remoteNonce)
+}
+
+// writeRaw writes an unsecured message, as used before the channel is
+// opened.
+func (c *secureChannel) writeRaw(msgType string, body []byte) error {
+	b := make([]byte, 8, 8+len(body))
+	copy(b, msgType+"F")
+	binary.LittleEndian.PutUint32(b[4:], uint32(8+len(body)))
+	_, err := c.conn.Write(append(b, body...))
+	return err
+}
+
+// readChunk reads a message chunk and returns its header and the rest.
+func (c *secureChannel) readChunk() ([]byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < 8 || size > receiveBufferSize {
+		return nil, nil, fmt.Errorf("invalid message size %d", size)
+	}
+	rest := make([]byte, size-8)
+	if _, err := io.ReadFull(c.conn, rest); err != nil {
+		return nil, nil, err
+	}
+	if string(header[:3]) == "ERR" {
+		d := &decoder{b: rest}
+		status := StatusCode(d.uint32())
+		if reason := d.string(); reason != "" {
+			return nil, nil, fmt.Errorf("%s: %s", status, reason)
+		}
+		return nil, nil, status
+	}
+	return header, rest, nil
+}
+
+// hello negotiates the buffer sizes with the server.
+func (c *secureChannel) hello(endpoint string) error {
+	e := &encoder{}
+	e.uint32(0)
+	e.uint32(receiveBufferSize)
+	e.uint32(receiveBufferSize)
+	e.uint32(0)
+	e.uint32(0)
+	e.string(endpoint)
+	if err := c.writeRaw("HEL", e.b); err != nil {
+		return err
+	}
+
+	header, body, err := c.readChunk()
+	if err != nil {
+		return err
+	}
+	if string(header[:3]) != "ACK" {
+		return fmt.Errorf("unexpected message type %s", header[:3])
+	}
+	d := &decoder{b: body}
+	d.uint32()
+	c.sendBufferSize = d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	if c.sendBufferSize < 8192 {
+		return fmt.Errorf("invalid receive buffer size %d", c.sendBufferSize)
+	}
+	return nil
+}
+
+// asymmetricHeader encodes the security header of OPN messages.
+func (c *secureChannel) asymmetricHeader() []byte {
+	e := &encoder{}
+	e.string(c.policy)
+	if !c.secure() {
+		e.bytes(nil)
+		e.bytes(nil)
+		return e.b
+	}
+	e.bytes(c.localCert)
+	thumbprint := sha1.Sum(c.remoteCert)
+	e.bytes(thumbprint[:])
+	return e.b
+}
+
+func (c *secureChannel) nextSequence() []byte {
+	c.sequence++
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, c.sequence)
+	return b
+}
+
+// sendAsymmetric sends an OPN message, secured with the certificates. The
+// message always fits into a single chunk.
+func (c *secureChannel) sendAsymmetric(requestID uint32, body []byte) error {
+	securityHeader := c.asymmetricHeader()
+	plain := append(c.nextSequence(), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(plain[4:], requestID)
+	plain = append(plain, body...)
+
+	headerSize := 12 + len(securityHeader)
+	if !c.secure() {
+		return c.writeChunk("OPN", 'F', securityHeader, plain)
+	}
+
+	cipherBlock := c.remoteKey.N.BitLen() / 8
+	plainBlock := cipherBlock - oaepOverhead
+	signatureSize := c.localKey.N.BitLen() / 8
+	extra := cipherBlock > 256
+
+	size := len(plain) + 1 + signatureSize
+	if extra {
+		size++
+	}
+	padding := (plainBlock - size%plainBlock) % plainBlock
+	for i := 0; i < padding+1; i++ {
+		plain = append(plain, byte(padding))
+	}
+	if extra {
+		plain = append(plain, byte(padding>>8))
+	}
+	encrypted := (len(plain) + signatureSize) / plainBlock * cipherBlock
+
+	msg := c.chunkHeader("OPN", 'F', headerSize+encrypted)
+	msg = append(msg, securityHeader...)
+	msg = append(msg, plain...)
+	hash := sha256.Sum256(msg)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.localKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	plain = append(plain, signature...)
+
+	out := append([]byte(nil), msg[:headerSize]...)
+	for i := 0; i < len(plain); i += plainBlock {
+		block, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, c.remoteKey, plain[i:i+plainBlock], nil)
+		if err != nil {
+			return err
+		}
+		out = append(out, block...)
+	}
+	_, err = c.conn.Write(out)
+	return err
+}
+
+func (c *secureChannel) chunkHeader(msgType string, chunkType byte, size int) []byte {
+	b := make([]byte, 12)
+	copy(b, msgType)
+	b[3] = chunkType
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	binary.LittleEndian.PutUint32(b[8:], c.channelID)
+	return b
+}
+
+// writeChunk writes an unsecured chunk.
+func (c *secureChannel) writeChunk(msgType string, chunkType byte, securityHeader, plain []byte) error {
+	msg := c.chunkHeader(msgType, chunkType, 12+len(securityHeader)+len(plain))
+	msg = append(msg, securityHeader...)
+	_, err := c.conn.Write(append(msg, plain...))
+	return err
+}
+
+// maxChunkBody returns the size of the body that fits into a symmetric
+// chunk.
+func (c *secureChannel) maxChunkBody() int {
+	size := int(c.sendBufferSize) - 16
+	switch {
+	case !c.secure():
+		return size - 8
+	case c.mode == modeSign:
+		return size - 8 - sha256.Size
+	}
+	return size/aes.BlockSize*aes.BlockSize - 8 - sha256.Size - 1
+}
+
+// sendSymmetric sends a MSG or CLO message secured with the keys of the
+// channel, split into chunks as needed.
+func (c *secureChannel) sendSymmetric(msgType string, requestID uint32, body []byte) error {
+	max := c.maxChunkBody()
+	for {
+		n := len(body)
+		chunkType := byte('F')
+		if n > max {
+			n = max
+			chunkType = 'C'
+		}
+		if err := c.sendSymmetricChunk(msgType, chunkType, requestID, body[:n]); err != nil {
+			return err
+		}
+		body = body[n:]
+		if chunkType == 'F' {
+			return nil
+		}
+	}
+}
+
+func (c *secureChannel) sendSymmetricChunk(msgType string, chunkType byte, requestID uint32, body []byte) error {
+	securityHeader := make([]byte, 4)
+	binary.LittleEndian.PutUint32(securityHeader, c.tokenID)
+	plain := append(c.nextSequence(), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(plain[4:], requestID)
+	plain = append(plain, body...)
+	if !c.secure() {
+		return c.writeChunk(msgType, chunkType, securityHeader, plain)
+	}
+
+	encrypt := c.mode == modeSignAndEncrypt
+	if encrypt {
+		padding := (aes.BlockSize - (len(plain)+1+sha256.Size)%aes.BlockSize) % aes.BlockSize
+		for i := 0; i < padding+1; i++ {
+			plain = append(plain, byte(padding))
+		}
+	}
+	msg := c.chunkHeader(msgType, chunkType, 16+len(plain)+sha256.Size)
+	msg = append(msg, securityHeader...)
+	msg = append(msg, plain...)
+	h := hmac.New(sha256.New, c.local.signing)
+	h.Write(msg)
+	msg = h.Sum(msg)
+
+	if encrypt {
+		block, err := aes.NewCipher(c.local.encryption)
+		if err != nil {
+			return err
+		}
+		cipher.NewCBCEncrypter(block, c.local.iv).CryptBlocks(msg[16:], msg[16:])
+	}
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// receive reads a message and returns its type, request id and body,
+// reassembled from its chunks.
+func (c *secureChannel) receive() (string, uint32, []byte, error) {
+	var body []byte
+	for {
+		header, rest, err := c.readChunk()
+		if err != nil {
+			return "", 0, nil, err
+		}
+		msgType := string(header[:3])
+		chunk := append(header, rest...)
+
+		var plain []byte
+		switch msgType {
+		case "OPN":
+			plain, err = c.openAsymmetric(chunk)
+		case "MSG", "CLO":
+			plain, err = c.openSymmetric(chunk)
+		default:
+			return "", 0, nil, fmt.Errorf("unexpected message type %s", msgType)
+		}
+		if err != nil {
+			return "", 0, nil, err
+		}
+		if len(plain) < 8 {
+			return "", 0, nil, errTruncated
+		}
+		requestID := binary.LittleEndian.Uint32(plain[4:])
+		body = append(body, plain[8:]...)
+
+		switch header[3] {
+		case 'F':
+			return msgType, requestID, body, nil
+		case 'A':
+			d := &decoder{b: plain[8:]}
+			status := StatusCode(d.uint32())
+			return "", 0, nil, fmt.Errorf("message aborted: %s: %s", status, d.string())
+		}
+		if len(body) > 16*receiveBufferSize {
+			return "", 0, nil, errors.New("message too large")
+		}
+	}
+}
+
+// openAsymmetric decrypts and verifies an OPN chunk and returns the
+// sequence header and the body.
+func (c *secureChannel) openAsymmetric(chunk []byte) ([]byte, error) {
+	d := &decoder{b: chunk[12:]}
+	policy := d.string()
+	senderCert := d.bytes()
+	d.bytes()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if c.policy == "" {
+		// the server side learns the policy from the first message
+		c.policy = policy
+	} else if policy != c.policy {
+		return nil, fmt.Errorf("unexpected security policy %s", policy)
+	}
+	headerSize := len(chunk) - len(d.b)
+	if !c.secure() {
+		return chunk[headerSize:], nil
+	}
+	if c.remoteCert == nil {
+		// the server side learns the certificate of the client here
+		if err := c.setRemoteCertificate(senderCert); err != nil {
+			return nil, err
+		}
+	} else if !bytes.Equal(senderCert, c.remoteCert) {
+		return nil, errors.New("unexpected sender certificate")
+	}
+
+	cipherBlock := c.localKey.N.BitLen() / 8
+	encrypted := chunk[headerSize:]
+	if len(encrypted)%cipherBlock != 0 {
+		return nil, errors.New("invalid encrypted message size")
+	}
+	msg := append([]byte(nil), chunk[:headerSize]...)
+	for i := 0; i < len(encrypted); i += cipherBlock {
+		block, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, c.localKey, encrypted[i:i+cipherBlock], nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt message: %s", err)
+		}
+		msg = append(msg, block...)
+	}
+
+	signatureSize := c.remoteKey.N.BitLen() / 8
+	if len(msg) < headerSize+signatureSize+1 {
+		return nil, errTruncated
+	}
+	signed := msg[:len(msg)-signatureSize]
+	hash := sha256.Sum256(signed)
+	if err := rsa.VerifyPKCS1v15(c.remoteKey, crypto.SHA256, hash[:], msg[len(signed):]); err != nil {
+		return nil, errors.New("invalid message signature")
+	}
+	// the size in the header refers to the encrypted message
+	return unpad(signed[headerSize:], cipherBlock > 256)
+}
+
+// unpad strips the padding of a decrypted message.
+func unpad(b []byte, extra bool) ([]byte, error) {
+	n := int(b[len(b)-1]) + 1
+	if extra {
+		if len(b) < 2 {
+			return nil, errTruncated
+		}
+		n = int(b[len(b)-1])<<8 + int(b[len(b)-2]) + 2
+	}
+	if n > len(b) {
+		return nil, errors.New("invalid padding")
+	}
+	return b[:len(b)-n], nil
+}
+
+// openSymmetric decrypts and verifies a MSG or CLO chunk and returns the
+// sequence header and the body.
+func (c *secureChannel) openSymmetric(chunk []byte) ([]byte, error) {
+	if len(chunk) < 16 {
+		return nil, errTruncated
+	}
+	if channelID := binary.LittleEndian.Uint32(chunk[8:]); channelID != c.channelID {
+		return nil, fmt.Errorf("unexpected secure channel id %d", channelID)
+	}
+	if tokenID := binary.LittleEndian.Uint32(chunk[12:]); tokenID != c.tokenID {
+		return nil, fmt.Errorf("unexpected security token id %d", tokenID)
+	}
+	if !c.secure() {
+		return chunk[16:], nil
+	}
+
+	if c.mode == modeSignAndEncrypt {
+		if (len(chunk)-16)%aes.BlockSize != 0 {
+			return nil, errors.New("invalid encrypted message size")
+		}
+		block, err := aes.NewCipher(c.remote.encryption)
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCBCDecrypter(block, c.remote.iv).CryptBlocks(chunk[16:], chunk[16:])
+	}
+
+	if len(chunk) < 16+sha256.Size+1 {
+		return nil, errTruncated
+	}
+	signed := chunk[:len(chunk)-sha256.Size]
+	h := hmac.New(sha256.New, c.remote.signing)
+	h.Write(signed)
+	if !hmac.Equal(h.Sum(nil), chunk[len(signed):]) {
+		return nil, errors.New("invalid message signature")
+	}
+	if c.mode == modeSignAndEncrypt {
+		return unpad(signed[16:], false)
+	}
+	return signed[16:], nil
+}
@@ -0,0 +1,575 @@
+package opc_ua
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Binary encoding ids of the service requests. The id of the response is
+// always the id of the request plus 3.
+const (
+	serviceGetEndpoints       = 428
+	serviceOpenSecureChannel  = 446
+	serviceCloseSecureChannel = 452
+	serviceCreateSession      = 461
+	serviceActivateSession    = 467
+	serviceCloseSession       = 473
+	serviceRead               = 631
+)
+
+// Binary encoding ids of the user identity tokens.
+const (
+	anonymousIdentityToken = 321
+	userNameIdentityToken  = 324
+)
+
+// User identity token types of the endpoint descriptions.
+const (
+	tokenAnonymous = 0
+	tokenUserName  = 1
+)
+
+const (
+	defaultPort = "4840"
+
+	attributeValue = 13
+	// timestampsNeither asks the server to leave the timestamps out of the
+	// values read
+	timestampsNeither = 3
+
+	algorithmRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algorithmRSAOAEP   = "http://www.w3.org/2001/04/xmlenc#rsa-oaep"
+
+	channelLifetime = time.Hour
+	sessionTimeout  = time.Hour
+)
+
+// userTokenPolicy is a way of authenticating that an endpoint accepts.
+type userTokenPolicy struct {
+	policyID  string
+	tokenType int32
+	policy    string
+}
+
+// endpoint describes a combination of security policy and mode a server
+// offers.
+type endpoint struct {
+	url         string
+	certificate []byte
+	mode        int32
+	policy      string
+	tokens      []userTokenPolicy
+}
+
+func (d *decoder) endpoint() endpoint {
+	var ep endpoint
+	ep.url = d.string()
+	// the application description of the server
+	d.string()
+	d.string()
+	d.localizedText()
+	d.int32()
+	d.string()
+	d.string()
+	d.strings()
+	ep.certificate = d.bytes()
+	ep.mode = d.int32()
+	ep.policy = d.string()
+	n := d.arrayLength()
+	for i := 0; i < n && d.err == nil; i++ {
+		var t userTokenPolicy
+		t.policyID = d.string()
+		t.tokenType = d.int32()
+		d.string()
+		d.string()
+		t.policy = d.string()
+		ep.tokens = append(ep.tokens, t)
+	}
+	d.string()
+	d.byte()
+	return ep
+}
+
+// client is a session with an OPC UA server.
+type client struct {
+	url     string
+	policy  string
+	mode    int32
+	timeout time.Duration
+
+	// certificate and key of the client, required with a security policy
+	certificate []byte
+	key         *rsa.PrivateKey
+	// serverCertificate, if set, is the only certificate accepted from
+	// the server
+	serverCertificate []byte
+	applicationURI    string
+
+	username string
+	password string
+
+	ch            *secureChannel
+	requestID     uint32
+	requestHandle uint32
+	authToken     NodeID
+	tokenRenewal  time.Time
+}
+
+// address returns the host and port of an opc.tcp URL.
+func address(url string) (string, error) {
+	if !strings.HasPrefix(url, "opc.tcp://") {
+		return "", fmt.Errorf("invalid endpoint '%s', expected opc.tcp://host:port", url)
+	}
+	host := strings.TrimPrefix(url, "opc.tcp://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultPort)
+	}
+	return host, nil
+}
+
+// dial connects to the server and exchanges the hello messages.
+func (c *client) dial() (*secureChannel, error) {
+	addr, err := address(c.url)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect: %s", err)
+	}
+	ch := &secureChannel{conn: conn, policy: policyNone, mode: modeNone}
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := ch.hello(c.url); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// connect opens a secure channel and a session. The endpoints are read
+// over an unsecured channel first, to find the certificate of the server
+// and the ways of authenticating.
+func (c *client) connect() error {
+	ch, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.ch = ch
+	if err := c.openChannel(false); err != nil {
+		c.close()
+		return err
+	}
+	ep, err := c.selectEndpoint()
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	if c.policy != policyNone {
+		c.close()
+		if c.ch, err = c.dial(); err != nil {
+			return err
+		}
+		c.ch.policy = c.policy
+		c.ch.mode = c.mode
+		c.ch.localKey = c.key
+		c.ch.localCert = c.certificate
+		if err := c.ch.setRemoteCertificate(ep.certificate); err != nil {
+			c.close()
+			return fmt.Errorf("server %s", err)
+		}
+		if err := c.openChannel(false); err != nil {
+			c.close()
+			return err
+		}
+	}
+
+	if err := c.createSession(ep); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// selectEndpoint returns the endpoint with the configured security.
+func (c *client) selectEndpoint() (*endpoint, error) {
+	d, err := c.call(serviceGetEndpoints, func(e *encoder) {
+		e.string(c.url)
+		e.strings(nil)
+		e.strings(nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	n := d.arrayLength()
+	var endpoints []endpoint
+	for i := 0; i < n && d.err == nil; i++ {
+		endpoints = append(endpoints, d.endpoint())
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	for _, ep := range endpoints {
+		if ep.policy != c.policy || ep.mode != c.mode {
+			continue
+		}
+		if c.serverCertificate != nil && !bytes.Equal(ep.certificate, c.serverCertificate) {
+			return nil, errors.New("server certificate does not match server_certificate")
+		}
+		return &ep, nil
+	}
+	return nil, errors.New("server has no endpoint with the configured security policy and mode")
+}
+
+func (c *client) requestHeader(e *encoder) {
+	c.requestHandle++
+	e.nodeID(c.authToken)
+	e.dateTime(time.Now())
+	e.uint32(c.requestHandle)
+	e.uint32(0)
+	e.string("")
+	e.uint32(uint32(c.timeout / time.Millisecond))
+	e.extensionObject(0, nil)
+}
+
+// response decodes the type and the header of a response, returning the
+// service result if it failed.
+func response(body []byte, typeID uint32) (*decoder, error) {
+	d := &decoder{b: body}
+	id := d.nodeID()
+	d.dateTime()
+	d.uint32()
+	result := StatusCode(d.uint32())
+	d.diagnosticInfo()
+	d.strings()
+	d.extensionObject()
+	if d.err != nil {
+		return nil, d.err
+	}
+	// service faults only have the header
+	if result.IsBad() {
+		return nil, result
+	}
+	if id.Type != 'i' || id.Namespace != 0 || id.Numeric != typeID+3 {
+		return nil, fmt.Errorf("unexpected response type %d", id.Numeric)
+	}
+	return d, nil
+}
+
+// exchange sends a request and waits for its response.
+func (c *client) exchange(msgType string, typeID uint32, write func(e *encoder)) (*decoder, error) {
+	e := &encoder{}
+	e.nodeID(numericNodeID(typeID))
+	c.requestHeader(e)
+	write(e)
+
+	c.requestID++
+	c.ch.conn.SetDeadline(time.Now().Add(c.timeout))
+	var err error
+	if msgType == "OPN" {
+		err = c.ch.sendAsymmetric(c.requestID, e.b)
+	} else {
+		err = c.ch.sendSymmetric(msgType, c.requestID, e.b)
+	}
+	if err != nil || msgType == "CLO" {
+		return nil, err
+	}
+
+	_, requestID, body, err := c.ch.receive()
+	if err != nil {
+		return nil, err
+	}
+	if requestID != c.requestID {
+		return nil, fmt.Errorf("unexpected response to request %d", requestID)
+	}
+	return response(body, typeID)
+}
+
+// call invokes a service, renewing the security token of the channel
+// first when it is about to expire.
+func (c *client) call(typeID uint32, write func(e *encoder)) (*decoder, error) {
+	if !c.tokenRenewal.IsZero() && time.Now().After(c.tokenRenewal) {
+		if err := c.openChannel(true); err != nil {
+			return nil, fmt.Errorf("unable to renew secure channel: %s", err)
+		}
+	}
+	return c.exchange("MSG", typeID, write)
+}
+
+// openChannel issues or renews the security token of the channel.
+func (c *client) openChannel(renew bool) error {
+	var nonce []byte
+	if c.ch.secure() {
+		nonce = make([]byte, nonceLength)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+	}
+	var requestType int32
+	if renew {
+		requestType = 1
+	}
+
+	d, err := c.exchange("OPN", serviceOpenSecureChannel, func(e *encoder) {
+		e.uint32(0)
+		e.int32(requestType)
+		e.int32(c.ch.mode)
+		e.bytes(nonce)
+		e.uint32(uint32(channelLifetime / time.Millisecond))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to open secure channel: %s", err)
+	}
+	d.uint32()
+	channelID := d.uint32()
+	tokenID := d.uint32()
+	d.dateTime()
+	lifetime := time.Duration(d.uint32()) * time.Millisecond
+	serverNonce := d.bytes()
+	if d.err != nil {
+		return d.err
+	}
+	if c.ch.secure() {
+		if len(serverNonce) < nonceLength {
+			return errors.New("invalid server nonce")
+		}
+		c.ch.setNonces(nonce, serverNonce)
+	}
+	c.ch.channelID = channelID
+	c.ch.tokenID = tokenID
+	// renew at three quarters of the lifetime, as recommended
+	c.tokenRenewal = time.Now().Add(lifetime * 3 / 4)
+	return nil
+}
+
+func (c *client) sign(data ...[]byte) ([]byte, error) {
+	h := sha256.New()
+	for _, b := range data {
+		h.Write(b)
+	}
+	return rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, h.Sum(nil))
+}
+
+// createSession creates and activates a session.
+func (c *client) createSession(ep *endpoint) error {
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	d, err := c.call(serviceCreateSession, func(e *encoder) {
+		e.string(c.applicationURI)
+		e.string("urn:influxdata:telegraf")
+		e.localizedText("Telegraf")
+		e.int32(1)
+		e.string("")
+		e.string("")
+		e.strings(nil)
+		e.string("")
+		e.string(c.url)
+		e.string("telegraf")
+		e.bytes(nonce)
+		e.bytes(c.certificate)
+		e.float64(float64(sessionTimeout / time.Millisecond))
+		e.uint32(0)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create session: %s", err)
+	}
+	d.nodeID()
+	c.authToken = d.nodeID()
+	d.float64()
+	serverNonce := d.bytes()
+	serverCertificate := d.bytes()
+	n := d.arrayLength()
+	for i := 0; i < n && d.err == nil; i++ {
+		d.endpoint()
+	}
+	n = d.arrayLength()
+	for i := 0; i < n && d.err == nil; i++ {
+		d.bytes()
+		d.bytes()
+	}
+	d.string()
+	serverSignature := d.bytes()
+	if d.err != nil {
+		return d.err
+	}
+
+	var signature []byte
+	var algorithm string
+	if c.ch.secure() {
+		if !bytes.Equal(serverCertificate, c.ch.remoteCert) {
+			return errors.New("session created with a different server certificate")
+		}
+		h := sha256.New()
+		h.Write(c.certificate)
+		h.Write(nonce)
+		if err := rsa.VerifyPKCS1v15(c.ch.remoteKey, crypto.SHA256, h.Sum(nil), serverSignature); err != nil {
+			return errors.New("invalid server signature")
+		}
+		if signature, err = c.sign(serverCertificate, serverNonce); err != nil {
+			return err
+		}
+		algorithm = algorithmRSASHA256
+	}
+
+	tokenType, token, err := c.identityToken(ep, serverCertificate, serverNonce)
+	if err != nil {
+		return err
+	}
+	d, err = c.call(serviceActivateSession, func(e *encoder) {
+		e.string(algorithm)
+		e.bytes(signature)
+		e.int32(0)
+		e.strings(nil)
+		e.extensionObject(tokenType, token)
+		e.string("")
+		e.bytes(nil)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to activate session: %s", err)
+	}
+	return nil
+}
+
+// identityToken encodes the user identity token, encrypting the password
+// as the token policy of the endpoint requires.
+func (c *client) identityToken(ep *endpoint, serverCertificate, serverNonce []byte) (uint32, []byte, error) {
+	want := int32(tokenAnonymous)
+	if c.username != "" {
+		want = tokenUserName
+	}
+	var policy *userTokenPolicy
+	for i := range ep.tokens {
+		if ep.tokens[i].tokenType == want {
+			policy = &ep.tokens[i]
+			break
+		}
+	}
+	if policy == nil {
+		if want == tokenAnonymous {
+			return 0, nil, errors.New("server does not allow anonymous access, set username")
+		}
+		return 0, nil, errors.New("server does not allow user name authentication")
+	}
+
+	e := &encoder{}
+	e.string(policy.policyID)
+	if want == tokenAnonymous {
+		return anonymousIdentityToken, e.b, nil
+	}
+
+	securityPolicy := policy.policy
+	if securityPolicy == "" {
+		securityPolicy = ep.policy
+	}
+	e.string(c.username)
+	switch securityPolicy {
+	case policyNone:
+		e.bytes([]byte(c.password))
+		e.string("")
+	case policyBasic256Sha256:
+		password, err := encryptSecret([]byte(c.password), serverNonce, serverCertificate)
+		if err != nil {
+			return 0, nil, err
+		}
+		e.bytes(password)
+		e.string(algorithmRSAOAEP)
+	default:
+		return 0, nil, fmt.Errorf("unsupported user token security policy %s", securityPolicy)
+	}
+	return userNameIdentityToken, e.b, nil
+}
+
+// encryptSecret encrypts a password for the server: its length, the
+// password and the server nonce, with the key of the server certificate.
+func encryptSecret(secret, nonce, certificate []byte) ([]byte, error) {
+	ch := &secureChannel{}
+	if err := ch.setRemoteCertificate(certificate); err != nil {
+		return nil, fmt.Errorf("server %s", err)
+	}
+	plain := make([]byte, 4, 4+len(secret)+len(nonce))
+	binary.LittleEndian.PutUint32(plain, uint32(len(secret)+len(nonce)))
+	plain = append(append(plain, secret...), nonce...)
+
+	block := ch.remoteKey.N.BitLen()/8 - oaepOverhead
+	var out []byte
+	for len(plain) > 0 {
+		n := block
+		if len(plain) < n {
+			n = len(plain)
+		}
+		b, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, ch.remoteKey, plain[:n], nil)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+		plain = plain[n:]
+	}
+	return out, nil
+}
+
+// read reads the values of nodes.
+func (c *client) read(nodes []NodeID) ([]DataValue, error) {
+	d, err := c.call(serviceRead, func(e *encoder) {
+		e.float64(0)
+		e.int32(timestampsNeither)
+		e.int32(int32(len(nodes)))
+		for _, n := range nodes {
+			e.nodeID(n)
+			e.uint32(attributeValue)
+			e.string("")
+			e.uint16(0)
+			e.string("")
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	n := d.arrayLength()
+	values := make([]DataValue, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		values = append(values, d.dataValue())
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(values) != len(nodes) {
+		return nil, fmt.Errorf("read %d values for %d nodes", len(values), len(nodes))
+	}
+	return values, nil
+}
+
+// close closes the session and the secure channel, ignoring errors as the
+// connection is closed anyway.
+func (c *client) close() {
+	if c.ch == nil {
+		return
+	}
+	if c.authToken.Type != 0 {
+		c.call(serviceCloseSession, func(e *encoder) {
+			e.bool(true)
+		})
+		c.authToken = NodeID{}
+	}
+	if c.ch.channelID != 0 {
+		c.exchange("CLO", serviceCloseSecureChannel, func(e *encoder) {})
+	}
+	c.ch.conn.Close()
+	c.ch = nil
+	c.tokenRenewal = time.Time{}
+}
@@ -0,0 +1,258 @@
+package opc_ua
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+type OpcUA struct {
+	Endpoint          string
+	SecurityPolicy    string `toml:"security_policy"`
+	SecurityMode      string `toml:"security_mode"`
+	Certificate       string
+	PrivateKey        string `toml:"private_key"`
+	ServerCertificate string `toml:"server_certificate"`
+	ApplicationURI    string `toml:"application_uri"`
+	Username          string
+	Password          string
+	Timeout           internal.Duration
+	Nodes             []Node `toml:"node"`
+
+	sync.Mutex
+	client *client
+}
+
+// Node is a variable whose value is read on every collection.
+type Node struct {
+	// Name is the field name.
+	Name string
+	ID   string `toml:"id"`
+}
+
+var sampleConfig = `
+  ## OPC UA TCP endpoint of the server
+  endpoint = "opc.tcp://192.168.1.90:4840"
+
+  ## Security policy, "None" or "Basic256Sha256", and security mode,
+  ## "None", "Sign" or "SignAndEncrypt". The server must offer an endpoint
+  ## with this combination.
+  security_policy = "None"
+  security_mode = "None"
+
+  ## Certificate and private key of the client in PEM, required with a
+  ## security policy. The application URI must match the URI in the subject
+  ## alternative name of the certificate.
+  # certificate = "/etc/telegraf/opcua_cert.pem"
+  # private_key = "/etc/telegraf/opcua_key.pem"
+  # application_uri = "urn:telegraf"
+
+  ## Certificate of the server in PEM or DER. If set, the plugin refuses to
+  ## connect to a server with another certificate.
+  # server_certificate = "/etc/telegraf/opcua_server.der"
+
+  ## Authentication, anonymous unless a username is set
+  # username = ""
+  # password = ""
+
+  ## Timeout for connecting and for every request
+  timeout = "10s"
+
+  ## Variables to read, by node id
+  [[inputs.opc_ua.node]]
+    name = "temperature"
+    id = "ns=2;s=Channel1.Device1.Temperature"
+
+  [[inputs.opc_ua.node]]
+    name = "server_state"
+    id = "i=2259"
+`
+
+func (o *OpcUA) SampleConfig() string {
+	return sampleConfig
+}
+
+func (o *OpcUA) Description() string {
+	return "Read variables from OPC UA servers"
+}
+
+var policies = map[string]string{
+	"":               policyNone,
+	"none":           policyNone,
+	"basic256sha256": policyBasic256Sha256,
+}
+
+var modes = map[string]int32{
+	"none":           modeNone,
+	"sign":           modeSign,
+	"signandencrypt": modeSignAndEncrypt,
+}
+
+// newClient builds a client from the configuration.
+func (o *OpcUA) newClient() (*client, error) {
+	c := &client{
+		url:            o.Endpoint,
+		timeout:        o.Timeout.Duration,
+		applicationURI: o.ApplicationURI,
+		username:       o.Username,
+		password:       o.Password,
+	}
+	if c.timeout == 0 {
+		c.timeout = 10 * time.Second
+	}
+	if c.applicationURI == "" {
+		c.applicationURI = "urn:telegraf"
+	}
+
+	var ok bool
+	if c.policy, ok = policies[strings.ToLower(o.SecurityPolicy)]; !ok {
+		return nil, fmt.Errorf("unsupported security policy '%s'", o.SecurityPolicy)
+	}
+	mode := strings.ToLower(o.SecurityMode)
+	if mode == "" {
+		mode = "none"
+		if c.policy != policyNone {
+			mode = "signandencrypt"
+		}
+	}
+	if c.mode, ok = modes[mode]; !ok {
+		return nil, fmt.Errorf("invalid security mode '%s'", o.SecurityMode)
+	}
+	if (c.policy == policyNone) != (c.mode == modeNone) {
+		return nil, errors.New("security_mode must be None exactly if security_policy is None")
+	}
+
+	if c.policy != policyNone {
+		if o.Certificate == "" || o.PrivateKey == "" {
+			return nil, errors.New("a security policy requires certificate and private_key")
+		}
+		pair, err := tls.LoadX509KeyPair(o.Certificate, o.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client key/certificate from %s:%s: %s",
+				o.PrivateKey, o.Certificate, err)
+		}
+		key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private_key is not an RSA key")
+		}
+		c.certificate = pair.Certificate[0]
+		c.key = key
+	}
+
+	if o.ServerCertificate != "" {
+		b, err := ioutil.ReadFile(o.ServerCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("could not load server certificate: %s", err)
+		}
+		if block, _ := pem.Decode(b); block != nil {
+			b = block.Bytes
+		}
+		c.serverCertificate = b
+	}
+	return c, nil
+}
+
+// read reads the nodes over the open session, or a new one. A failing
+// session that was already open, e.g. because it timed out on the server,
+// is replaced once.
+func (o *OpcUA) read(nodes []NodeID) ([]DataValue, error) {
+	fresh := o.client == nil
+	if fresh {
+		c, err := o.newClient()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+		o.client = c
+	}
+
+	values, err := o.client.read(nodes)
+	if err == nil {
+		return values, nil
+	}
+	o.client.close()
+	o.client = nil
+	if fresh {
+		return nil, err
+	}
+	return o.read(nodes)
+}
+
+func (o *OpcUA) Gather(acc telegraf.Accumulator) error {
+	var nodes []NodeID
+	for _, n := range o.Nodes {
+		id, err := ParseNodeID(n.ID)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, id)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	values, err := o.read(nodes)
+	if err != nil {
+		return fmt.Errorf("%s: %s", o.Endpoint, err)
+	}
+
+	fields := make(map[string]interface{})
+	var failed []string
+	for i, v := range values {
+		name := o.Nodes[i].Name
+		if name == "" {
+			name = o.Nodes[i].ID
+		}
+		switch {
+		case v.Status.IsBad():
+			failed = append(failed, fmt.Sprintf("%s: %s", name, v.Status))
+		case v.Err != nil:
+			failed = append(failed, fmt.Sprintf("%s: %s", name, v.Err))
+		case v.Value == nil:
+		default:
+			addField(fields, name, v.Value)
+		}
+	}
+	if len(fields) > 0 {
+		acc.AddFields("opc_ua", fields, map[string]string{"endpoint": o.Endpoint})
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s: %s", o.Endpoint, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// addField adds a value, or the elements of an array as fields with their
+// index appended to the name.
+func addField(fields map[string]interface{}, name string, v interface{}) {
+	if values, ok := v.([]interface{}); ok {
+		for i, v := range values {
+			fields[name+"_"+strconv.Itoa(i)] = v
+		}
+		return
+	}
+	fields[name] = v
+}
+
+func init() {
+	inputs.Add("opc_ua", func() telegraf.Input {
+		return &OpcUA{
+			Timeout: internal.Duration{Duration: 10 * time.Second},
+		}
+	})
+}
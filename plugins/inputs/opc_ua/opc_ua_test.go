@@ -0,0 +1,587 @@
+package opc_ua
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNodeID(t *testing.T) {
+	n, err := ParseNodeID("i=2258")
+	require.NoError(t, err)
+	assert.Equal(t, NodeID{Type: 'i', Numeric: 2258}, n)
+
+	n, err = ParseNodeID("ns=2;s=Channel1.Device1.Tag1")
+	require.NoError(t, err)
+	assert.Equal(t, NodeID{Namespace: 2, Type: 's', Value: []byte("Channel1.Device1.Tag1")}, n)
+
+	n, err = ParseNodeID("ns=3;g=72962B91-FA75-4AE6-8D28-B404DC7DAF63")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x91, 0x2b, 0x96, 0x72, 0x75, 0xfa, 0xe6, 0x4a,
+		0x8d, 0x28, 0xb4, 0x04, 0xdc, 0x7d, 0xaf, 0x63,
+	}, n.Value)
+
+	n, err = ParseNodeID("ns=1;b=AQID")
+	require.NoError(t, err)
+	assert.Equal(t, NodeID{Namespace: 1, Type: 'b', Value: []byte{1, 2, 3}}, n)
+
+	for _, s := range []string{"", "2258", "ns=x;i=1", "ns=1", "i=x", "g=1234", "x=1"} {
+		_, err := ParseNodeID(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestNodeIDEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		id      string
+		encoded []byte
+	}{
+		{"i=13", []byte{0x00, 0x0d}},
+		{"ns=2;i=1025", []byte{0x01, 0x02, 0x01, 0x04}},
+		{"ns=300;i=70000", []byte{0x02, 0x2c, 0x01, 0x70, 0x11, 0x01, 0x00}},
+		{"ns=1;s=ab", []byte{0x03, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 'a', 'b'}},
+	} {
+		n, err := ParseNodeID(tt.id)
+		require.NoError(t, err)
+		e := &encoder{}
+		e.nodeID(n)
+		assert.Equal(t, tt.encoded, e.b, tt.id)
+
+		d := &decoder{b: e.b}
+		assert.Equal(t, n, d.nodeID(), tt.id)
+		assert.NoError(t, d.err)
+	}
+}
+
+func TestDataValue(t *testing.T) {
+	d := &decoder{b: []byte{
+		// double with status
+		0x03, 0x0b, 0, 0, 0, 0, 0, 0, 0x35, 0x40, 0x00, 0x00, 0x00, 0x00,
+		// array of two 16 bit unsigned integers
+		0x01, 0x85, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00,
+		// localized text with locale
+		0x01, 0x15, 0x03, 0x02, 0x00, 0x00, 0x00, 'e', 'n', 0x02, 0x00, 0x00, 0x00, 'o', 'k',
+		// bad status only
+		0x02, 0x00, 0x00, 0x34, 0x80,
+		// structure, skipped
+		0x01, 0x16, 0x01, 0x00, 0x10, 0x27, 0x01, 0x01, 0x00, 0x00, 0x00, 0xff,
+	}}
+	assert.Equal(t, DataValue{Value: 21.0}, d.dataValue())
+	assert.Equal(t, DataValue{Value: []interface{}{int64(1), int64(2)}}, d.dataValue())
+	assert.Equal(t, DataValue{Value: "ok"}, d.dataValue())
+	v := d.dataValue()
+	assert.Equal(t, StatusBadNodeIDUnknown, v.Status)
+	assert.EqualError(t, v.Status, "BadNodeIdUnknown")
+	v = d.dataValue()
+	assert.EqualError(t, v.Err, "structures are not supported")
+	assert.NoError(t, d.err)
+	assert.Len(t, d.b, 0)
+}
+
+func TestPSHA256(t *testing.T) {
+	// the output is the concatenation of HMAC(secret, A(i) + seed)
+	b := pSHA256([]byte("secret"), []byte("seed"), 40)
+	require.Len(t, b, 40)
+	assert.Equal(t, pSHA256([]byte("secret"), []byte("seed"), 64)[:40], b)
+	assert.NotEqual(t, pSHA256([]byte("seed"), []byte("secret"), 40), b)
+}
+
+// newCertificate returns a self-signed certificate and its key.
+func newCertificate(t *testing.T, name string) ([]byte, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der, key
+}
+
+func TestSecureChannel(t *testing.T) {
+	clientCert, clientKey := newCertificate(t, "client")
+	serverCert, serverKey := newCertificate(t, "server")
+
+	for _, mode := range []int32{modeSign, modeSignAndEncrypt} {
+		clientConn, serverConn := net.Pipe()
+		client := &secureChannel{
+			conn:           clientConn,
+			policy:         policyBasic256Sha256,
+			mode:           mode,
+			localKey:       clientKey,
+			localCert:      clientCert,
+			channelID:      7,
+			tokenID:        1,
+			sendBufferSize: 8192,
+		}
+		require.NoError(t, client.setRemoteCertificate(serverCert))
+		server := &secureChannel{
+			conn:           serverConn,
+			mode:           mode,
+			localKey:       serverKey,
+			localCert:      serverCert,
+			channelID:      7,
+			tokenID:        1,
+			sendBufferSize: 8192,
+		}
+		client.setNonces([]byte("client nonce"), []byte("server nonce"))
+		server.setNonces([]byte("server nonce"), []byte("client nonce"))
+
+		large := bytes.Repeat([]byte("0123456789"), 2000)
+		go func() {
+			client.sendAsymmetric(1, []byte("open"))
+			client.sendSymmetric("MSG", 2, large)
+		}()
+
+		msgType, requestID, body, err := server.receive()
+		require.NoError(t, err)
+		assert.Equal(t, "OPN", msgType)
+		assert.Equal(t, uint32(1), requestID)
+		assert.Equal(t, []byte("open"), body)
+		assert.Equal(t, policyBasic256Sha256, server.policy)
+		assert.Equal(t, clientCert, server.remoteCert)
+
+		msgType, requestID, body, err = server.receive()
+		require.NoError(t, err)
+		assert.Equal(t, "MSG", msgType)
+		assert.Equal(t, uint32(2), requestID)
+		assert.Equal(t, large, body)
+
+		// tampered messages are rejected
+		go server.sendSymmetric("MSG", 3, []byte("response"))
+		header := make([]byte, 8)
+		_, err = io.ReadFull(clientConn, header)
+		require.NoError(t, err)
+		rest := make([]byte, binary.LittleEndian.Uint32(header[4:])-8)
+		_, err = io.ReadFull(clientConn, rest)
+		require.NoError(t, err)
+		chunk := append(header, rest...)
+		chunk[len(chunk)-1]++
+		_, err = client.openSymmetric(chunk)
+		assert.EqualError(t, err, "invalid message signature")
+
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
+// fakeServer is an OPC UA server offering an unsecured endpoint and a
+// Basic256Sha256 endpoint. It serves a few variables.
+type fakeServer struct {
+	sync.Mutex
+	listener net.Listener
+	cert     []byte
+	key      *rsa.PrivateKey
+	// lifetime of the security tokens in milliseconds
+	lifetime uint32
+
+	sessions  int
+	tokens    map[string]bool
+	passwords []string
+	renewals  int
+}
+
+func newFakeServer(t *testing.T, lifetime uint32) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cert, key := newCertificate(t, "server")
+	s := &fakeServer{
+		listener: l,
+		cert:     cert,
+		key:      key,
+		lifetime: lifetime,
+		tokens:   make(map[string]bool),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "opc.tcp://" + s.listener.Addr().String() + "/server"
+}
+
+// expire drops all sessions.
+func (s *fakeServer) expire() {
+	s.Lock()
+	defer s.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+func (s *fakeServer) writeEndpoint(e *encoder, policy string, mode int32, tokenPolicy string) {
+	e.string(s.url())
+	e.string("urn:fake")
+	e.string("")
+	e.localizedText("Fake")
+	e.int32(0)
+	e.string("")
+	e.string("")
+	e.strings(nil)
+	e.bytes(s.cert)
+	e.int32(mode)
+	e.string(policy)
+	e.int32(2)
+	e.string("anonymous")
+	e.int32(tokenAnonymous)
+	e.string("")
+	e.string("")
+	e.string("")
+	e.string("username")
+	e.int32(tokenUserName)
+	e.string("")
+	e.string("")
+	e.string(tokenPolicy)
+	e.string("")
+	e.byte(0)
+}
+
+var fakeValues = map[string]func(e *encoder){
+	"ns=2;s=temperature": func(e *encoder) {
+		e.byte(0x01)
+		e.byte(typeDouble)
+		e.float64(21.5)
+	},
+	"i=2259": func(e *encoder) {
+		e.byte(0x01)
+		e.byte(typeInt32)
+		e.int32(0)
+	},
+	"ns=2;s=levels": func(e *encoder) {
+		e.byte(0x01)
+		e.byte(typeUInt16 | 0x80)
+		e.int32(2)
+		e.uint16(10)
+		e.uint16(20)
+	},
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	ch := &secureChannel{conn: conn, localKey: s.key, localCert: s.cert, sendBufferSize: 8192}
+	if _, _, err := ch.readChunk(); err != nil {
+		return
+	}
+	e := &encoder{}
+	e.uint32(0)
+	e.uint32(8192)
+	e.uint32(8192)
+	e.uint32(0)
+	e.uint32(0)
+	ch.writeRaw("ACK", e.b)
+
+	var sessionNonce []byte
+	for {
+		msgType, requestID, body, err := ch.receive()
+		if err != nil || msgType == "CLO" {
+			return
+		}
+		d := &decoder{b: body}
+		typeID := d.nodeID().Numeric
+		token := d.nodeID()
+		d.dateTime()
+		handle := d.uint32()
+		d.uint32()
+		d.string()
+		d.uint32()
+		d.extensionObject()
+
+		e := &encoder{}
+		e.nodeID(numericNodeID(typeID + 3))
+		e.dateTime(time.Now())
+		e.uint32(handle)
+		status := uint32(0)
+		authenticated := typeID == serviceOpenSecureChannel || typeID == serviceGetEndpoints ||
+			typeID == serviceCreateSession
+		if !authenticated {
+			t := &encoder{}
+			t.nodeID(token)
+			s.Lock()
+			authenticated = s.tokens[string(t.b)]
+			s.Unlock()
+		}
+		if !authenticated {
+			e = &encoder{}
+			e.nodeID(numericNodeID(397))
+			e.dateTime(time.Now())
+			e.uint32(handle)
+			status = uint32(StatusBadSessionIDInvalid)
+		}
+		e.uint32(status)
+		e.byte(0)
+		e.strings(nil)
+		e.extensionObject(0, nil)
+		if status != 0 {
+			ch.sendSymmetric("MSG", requestID, e.b)
+			continue
+		}
+
+		switch typeID {
+		case serviceOpenSecureChannel:
+			d.uint32()
+			requestType := d.int32()
+			ch.mode = d.int32()
+			clientNonce := d.bytes()
+			var serverNonce []byte
+			if ch.secure() {
+				serverNonce = bytes.Repeat([]byte{0x55}, nonceLength)
+			}
+			ch.channelID = 7
+			ch.tokenID++
+			if requestType == 1 {
+				s.Lock()
+				s.renewals++
+				s.Unlock()
+			}
+			e.uint32(0)
+			e.uint32(ch.channelID)
+			e.uint32(ch.tokenID)
+			e.dateTime(time.Now())
+			e.uint32(s.lifetime)
+			e.bytes(serverNonce)
+			ch.sendAsymmetric(requestID, e.b)
+			if ch.secure() {
+				ch.setNonces(serverNonce, clientNonce)
+			}
+			continue
+		case serviceGetEndpoints:
+			e.int32(2)
+			s.writeEndpoint(e, policyNone, modeNone, "")
+			s.writeEndpoint(e, policyBasic256Sha256, modeSignAndEncrypt, policyBasic256Sha256)
+		case serviceCreateSession:
+			d.string()
+			d.string()
+			d.localizedText()
+			d.int32()
+			d.string()
+			d.string()
+			d.strings()
+			d.string()
+			d.string()
+			d.string()
+			clientNonce := d.bytes()
+			clientCert := d.bytes()
+
+			s.Lock()
+			s.sessions++
+			token := NodeID{Namespace: 1, Type: 'i', Numeric: uint32(1000 + s.sessions)}
+			t := &encoder{}
+			t.nodeID(token)
+			s.tokens[string(t.b)] = true
+			s.Unlock()
+
+			sessionNonce = bytes.Repeat([]byte{0x66}, nonceLength)
+			e.nodeID(NodeID{Namespace: 1, Type: 'i', Numeric: 1})
+			e.nodeID(token)
+			e.float64(60000)
+			e.bytes(sessionNonce)
+			e.bytes(s.cert)
+			e.int32(0)
+			e.int32(0)
+			if ch.secure() {
+				h := sha256.Sum256(append(clientCert, clientNonce...))
+				signature, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+				e.string(algorithmRSASHA256)
+				e.bytes(signature)
+			} else {
+				e.string("")
+				e.bytes(nil)
+			}
+			e.uint32(0)
+		case serviceActivateSession:
+			d.string()
+			signature := d.bytes()
+			if ch.secure() {
+				h := sha256.Sum256(append(append([]byte(nil), s.cert...), sessionNonce...))
+				if rsa.VerifyPKCS1v15(ch.remoteKey, crypto.SHA256, h[:], signature) != nil {
+					return
+				}
+			}
+			d.arrayLength()
+			d.strings()
+			tokenType, token := d.extensionObject()
+			if tokenType.Numeric == userNameIdentityToken {
+				t := &decoder{b: token}
+				t.string()
+				t.string()
+				password := t.bytes()
+				if t.string() == algorithmRSAOAEP {
+					plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, s.key, password, nil)
+					if err != nil {
+						return
+					}
+					n := binary.LittleEndian.Uint32(plain)
+					password = plain[4 : 4+n-nonceLength]
+				}
+				s.Lock()
+				s.passwords = append(s.passwords, string(password))
+				s.Unlock()
+			}
+			e.bytes(nil)
+			e.int32(0)
+			e.int32(0)
+		case serviceRead:
+			d.float64()
+			d.int32()
+			n := d.arrayLength()
+			e.int32(int32(n))
+			for i := 0; i < n; i++ {
+				id := d.nodeID()
+				d.uint32()
+				d.string()
+				d.uint16()
+				d.string()
+				var key string
+				for k := range fakeValues {
+					n, _ := ParseNodeID(k)
+					if bytes.Equal(n.Value, id.Value) && n.Numeric == id.Numeric {
+						key = k
+					}
+				}
+				if write, ok := fakeValues[key]; ok {
+					write(e)
+				} else {
+					e.byte(0x02)
+					e.uint32(uint32(StatusBadNodeIDUnknown))
+				}
+			}
+			e.int32(0)
+		case serviceCloseSession:
+		default:
+			return
+		}
+		ch.sendSymmetric("MSG", requestID, e.b)
+	}
+}
+
+func TestGather(t *testing.T) {
+	s := newFakeServer(t, 3600000)
+	defer s.listener.Close()
+
+	o := &OpcUA{
+		Endpoint: s.url(),
+		Timeout:  internal.Duration{Duration: 5 * time.Second},
+		Nodes: []Node{
+			{Name: "temperature", ID: "ns=2;s=temperature"},
+			{Name: "server_state", ID: "i=2259"},
+			{Name: "level", ID: "ns=2;s=levels"},
+			{Name: "missing", ID: "ns=2;s=missing"},
+		},
+	}
+	fields := map[string]interface{}{
+		"temperature":  21.5,
+		"server_state": int64(0),
+		"level_0":      int64(10),
+		"level_1":      int64(20),
+	}
+	tags := map[string]string{"endpoint": s.url()}
+
+	var acc testutil.Accumulator
+	err := o.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing: BadNodeIdUnknown")
+	acc.AssertContainsTaggedFields(t, "opc_ua", fields, tags)
+
+	// the session is kept, and replaced once it is gone on the server
+	o.Nodes = o.Nodes[:3]
+	acc = testutil.Accumulator{}
+	require.NoError(t, o.Gather(&acc))
+	s.expire()
+	require.NoError(t, o.Gather(&acc))
+	assert.Len(t, acc.Metrics, 2)
+	s.Lock()
+	assert.Equal(t, 2, s.sessions)
+	s.Unlock()
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, b []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600))
+	return path
+}
+
+func TestGatherSecure(t *testing.T) {
+	// the security token is renewed before every request
+	s := newFakeServer(t, 1)
+	defer s.listener.Close()
+
+	dir, err := ioutil.TempDir("", "opc_ua")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cert, key := newCertificate(t, "telegraf")
+
+	o := &OpcUA{
+		Endpoint:          s.url(),
+		SecurityPolicy:    "Basic256Sha256",
+		SecurityMode:      "SignAndEncrypt",
+		Certificate:       writePEM(t, dir, "cert.pem", "CERTIFICATE", cert),
+		PrivateKey:        writePEM(t, dir, "key.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		ServerCertificate: writePEM(t, dir, "server.pem", "CERTIFICATE", s.cert),
+		Username:          "user",
+		Password:          "secret",
+		Timeout:           internal.Duration{Duration: 5 * time.Second},
+		Nodes:             []Node{{Name: "temperature", ID: "ns=2;s=temperature"}},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "opc_ua",
+		map[string]interface{}{"temperature": 21.5},
+		map[string]string{"endpoint": s.url()})
+
+	s.Lock()
+	assert.Equal(t, []string{"secret"}, s.passwords)
+	assert.NotZero(t, s.renewals)
+	s.Unlock()
+
+	// a different server certificate is refused
+	other, _ := newCertificate(t, "other")
+	o.client.close()
+	o.client = nil
+	o.ServerCertificate = writePEM(t, dir, "other.pem", "CERTIFICATE", other)
+	err = o.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server certificate does not match")
+}
+
+func TestConfig(t *testing.T) {
+	for _, o := range []*OpcUA{
+		{SecurityPolicy: "Basic128Rsa15"},
+		{SecurityPolicy: "None", SecurityMode: "Sign"},
+		{SecurityPolicy: "Basic256Sha256", SecurityMode: "None"},
+		{SecurityPolicy: "Basic256Sha256"},
+	} {
+		_, err := o.newClient()
+		assert.Error(t, err, o.SecurityPolicy)
+	}
+}
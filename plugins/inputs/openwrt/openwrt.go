@@ -0,0 +1,444 @@
+package openwrt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/inventory"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// nullSession is the session id used for calls made before logging in.
+const nullSession = "00000000000000000000000000000000"
+
+// ubus status and JSON-RPC error codes meaning that the session expired or
+// lacks the permissions for a call.
+const (
+	ubusStatusPermissionDenied = 6
+	rpcErrorAccessDenied       = -32002
+)
+
+// loadScale is the fixed point factor of the load averages reported by
+// system info.
+const loadScale = 65536.0
+
+type OpenWrt struct {
+	URL      string
+	Username string
+	Password string
+	Timeout  internal.Duration
+
+	GatherInterfaces      bool `toml:"gather_interfaces"`
+	GatherWirelessClients bool `toml:"gather_wireless_clients"`
+	GatherDHCPLeases      bool `toml:"gather_dhcp_leases"`
+
+	// Path to a YAML or CSV file mapping MAC addresses to extra tags
+	InventoryFile string
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client    *devicehttp.Client
+	inventory *inventory.Inventory
+
+	sync.Mutex
+	session string
+	id      int
+}
+
+var sampleConfig = `
+  ## URL of the ubus JSON-RPC endpoint (provided by uhttpd-mod-ubus)
+  url = "http://192.168.1.1/ubus"
+
+  ## Credentials of a user allowed to call the ubus objects below via rpcd
+  username = "root"
+  password = ""
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Gather per-interface counters from network.device
+  gather_interfaces = true
+  ## Gather per-station statistics from iwinfo
+  gather_wireless_clients = true
+  ## Gather DHCP lease counts from luci-rpc (OpenWrt 19.07 and later)
+  gather_dhcp_leases = true
+
+  ## Optional YAML or CSV file mapping station MAC addresses to extra tags,
+  ## such as a name or owner, added to openwrt_wireless_client
+  # inventory_file = "/etc/telegraf/inventory.yaml"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (o *OpenWrt) SampleConfig() string {
+	return sampleConfig
+}
+
+func (o *OpenWrt) Description() string {
+	return "Read system, interface, wireless and DHCP statistics from OpenWrt routers via ubus"
+}
+
+func (o *OpenWrt) Gather(acc telegraf.Accumulator) error {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", o.URL, err)
+	}
+
+	if o.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			AuthMode:           devicehttp.AuthNone,
+			Timeout:            o.Timeout.Duration,
+			SSLCA:              o.SSLCA,
+			SSLCert:            o.SSLCert,
+			SSLKey:             o.SSLKey,
+			InsecureSkipVerify: o.InsecureSkipVerify,
+		})
+		if err != nil {
+			return err
+		}
+		o.client = client
+	}
+
+	if o.InventoryFile != "" && o.inventory == nil {
+		inv, err := inventory.Load(o.InventoryFile)
+		if err != nil {
+			return err
+		}
+		o.inventory = inv
+	}
+
+	tags := map[string]string{"server": u.Host}
+
+	if err := o.gatherSystem(acc, tags); err != nil {
+		return err
+	}
+	if o.GatherInterfaces {
+		if err := o.gatherInterfaces(acc, tags); err != nil {
+			return err
+		}
+	}
+	if o.GatherWirelessClients {
+		if err := o.gatherWirelessClients(acc, tags); err != nil {
+			return err
+		}
+	}
+	if o.GatherDHCPLeases {
+		if err := o.gatherDHCPLeases(acc, tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type systemInfo struct {
+	Uptime int64     `json:"uptime"`
+	Load   []float64 `json:"load"`
+	Memory struct {
+		Total     int64 `json:"total"`
+		Free      int64 `json:"free"`
+		Shared    int64 `json:"shared"`
+		Buffered  int64 `json:"buffered"`
+		Available int64 `json:"available"`
+		Cached    int64 `json:"cached"`
+	} `json:"memory"`
+	Swap struct {
+		Total int64 `json:"total"`
+		Free  int64 `json:"free"`
+	} `json:"swap"`
+}
+
+func (o *OpenWrt) gatherSystem(acc telegraf.Accumulator, tags map[string]string) error {
+	var info systemInfo
+	if err := o.call("system", "info", nil, &info); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"uptime":        info.Uptime,
+		"mem_total":     info.Memory.Total,
+		"mem_free":      info.Memory.Free,
+		"mem_shared":    info.Memory.Shared,
+		"mem_buffered":  info.Memory.Buffered,
+		"mem_available": info.Memory.Available,
+		"mem_cached":    info.Memory.Cached,
+		"swap_total":    info.Swap.Total,
+		"swap_free":     info.Swap.Free,
+	}
+	for i, name := range []string{"load1", "load5", "load15"} {
+		if i < len(info.Load) {
+			fields[name] = info.Load[i] / loadScale
+		}
+	}
+	acc.AddFields("openwrt_system", fields, tags)
+	return nil
+}
+
+type deviceStatus struct {
+	Up         bool             `json:"up"`
+	Statistics map[string]int64 `json:"statistics"`
+}
+
+// interfaceCounters are the network.device statistics that are reported.
+var interfaceCounters = []string{
+	"rx_bytes", "tx_bytes",
+	"rx_packets", "tx_packets",
+	"rx_errors", "tx_errors",
+	"rx_dropped", "tx_dropped",
+	"multicast", "collisions",
+}
+
+func (o *OpenWrt) gatherInterfaces(acc telegraf.Accumulator, tags map[string]string) error {
+	devices := make(map[string]deviceStatus)
+	if err := o.call("network.device", "status", nil, &devices); err != nil {
+		return err
+	}
+
+	for name, dev := range devices {
+		fields := map[string]interface{}{"link_up": dev.Up}
+		for _, counter := range interfaceCounters {
+			if v, ok := dev.Statistics[counter]; ok {
+				fields[counter] = v
+			}
+		}
+		acc.AddFields("openwrt_interface", fields,
+			copyTags(tags, "interface", name))
+	}
+	return nil
+}
+
+type assocList struct {
+	Results []struct {
+		MAC      string `json:"mac"`
+		Signal   int64  `json:"signal"`
+		Noise    int64  `json:"noise"`
+		Inactive int64  `json:"inactive"`
+		RX       struct {
+			Rate    int64 `json:"rate"`
+			Packets int64 `json:"packets"`
+		} `json:"rx"`
+		TX struct {
+			Rate    int64 `json:"rate"`
+			Packets int64 `json:"packets"`
+		} `json:"tx"`
+	} `json:"results"`
+}
+
+func (o *OpenWrt) gatherWirelessClients(acc telegraf.Accumulator, tags map[string]string) error {
+	var devices struct {
+		Devices []string `json:"devices"`
+	}
+	if err := o.call("iwinfo", "devices", nil, &devices); err != nil {
+		return err
+	}
+
+	for _, device := range devices.Devices {
+		var assoc assocList
+		err := o.call("iwinfo", "assoclist",
+			map[string]interface{}{"device": device}, &assoc)
+		if err != nil {
+			return err
+		}
+
+		deviceTags := copyTags(tags, "device", device)
+		acc.AddFields("openwrt_wireless", map[string]interface{}{
+			"clients": len(assoc.Results),
+		}, deviceTags)
+
+		for _, station := range assoc.Results {
+			stationTags := copyTags(deviceTags, "mac", station.MAC)
+			o.inventory.Tag(stationTags, station.MAC)
+			acc.AddFields("openwrt_wireless_client", map[string]interface{}{
+				"signal":     station.Signal,
+				"noise":      station.Noise,
+				"inactive":   station.Inactive,
+				"rx_rate":    station.RX.Rate,
+				"rx_packets": station.RX.Packets,
+				"tx_rate":    station.TX.Rate,
+				"tx_packets": station.TX.Packets,
+			}, stationTags)
+		}
+	}
+	return nil
+}
+
+func (o *OpenWrt) gatherDHCPLeases(acc telegraf.Accumulator, tags map[string]string) error {
+	var leases struct {
+		DHCPLeases  []json.RawMessage `json:"dhcp_leases"`
+		DHCP6Leases []json.RawMessage `json:"dhcp6_leases"`
+	}
+	if err := o.call("luci-rpc", "getDHCPLeases", nil, &leases); err != nil {
+		return err
+	}
+
+	acc.AddFields("openwrt_dhcp", map[string]interface{}{
+		"leases":  len(leases.DHCPLeases),
+		"leases6": len(leases.DHCP6Leases),
+	}, tags)
+	return nil
+}
+
+// call invokes a ubus method and decodes its result into out, logging in
+// first if there is no session yet or the current one expired.
+func (o *OpenWrt) call(
+	object, method string,
+	args map[string]interface{},
+	out interface{},
+) error {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.session == "" {
+		if err := o.login(); err != nil {
+			return err
+		}
+	}
+
+	data, err := o.rpc(o.session, object, method, args)
+	if err == errAccessDenied {
+		if err = o.login(); err != nil {
+			return err
+		}
+		data, err = o.rpc(o.session, object, method, args)
+	}
+	if err != nil {
+		return fmt.Errorf("ubus call %s %s: %s", object, method, err)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unable to parse result of ubus call %s %s: %s",
+			object, method, err)
+	}
+	return nil
+}
+
+func (o *OpenWrt) login() error {
+	o.session = ""
+	data, err := o.rpc(nullSession, "session", "login", map[string]interface{}{
+		"username": o.Username,
+		"password": o.Password,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to log in to %s: %s", o.URL, err)
+	}
+
+	var result struct {
+		Session string `json:"ubus_rpc_session"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.Session == "" {
+		return fmt.Errorf("unable to log in to %s: no session returned", o.URL)
+	}
+	o.session = result.Session
+	return nil
+}
+
+var errAccessDenied = errors.New("access denied")
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result []json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// rpc performs a single JSON-RPC "call" and returns the data part of the
+// ubus reply.
+func (o *OpenWrt) rpc(
+	session, object, method string,
+	args map[string]interface{},
+) (json.RawMessage, error) {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	o.id++
+	body, err := json.Marshal(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      o.id,
+		Method:  "call",
+		Params:  []interface{}{session, object, method, args},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	b, err := o.client.Do("POST", o.URL, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp rpcResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC response: %s", err)
+	}
+	if resp.Error != nil {
+		if resp.Error.Code == rpcErrorAccessDenied {
+			return nil, errAccessDenied
+		}
+		return nil, fmt.Errorf("JSON-RPC error %d: %s",
+			resp.Error.Code, resp.Error.Message)
+	}
+	if len(resp.Result) == 0 {
+		return nil, fmt.Errorf("empty JSON-RPC result")
+	}
+
+	var status int
+	if err := json.Unmarshal(resp.Result[0], &status); err != nil {
+		return nil, fmt.Errorf("invalid ubus status: %s", err)
+	}
+	if status == ubusStatusPermissionDenied {
+		return nil, errAccessDenied
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("ubus status %d", status)
+	}
+	if len(resp.Result) < 2 {
+		return json.RawMessage("{}"), nil
+	}
+	return resp.Result[1], nil
+}
+
+func copyTags(tags map[string]string, key, value string) map[string]string {
+	out := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+func init() {
+	inputs.Add("openwrt", func() telegraf.Input {
+		return &OpenWrt{
+			GatherInterfaces:      true,
+			GatherWirelessClients: true,
+			GatherDHCPLeases:      true,
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package openwrt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var replies = map[string]string{
+	"system info": `{"uptime": 86400, "load": [32768, 16384, 65536],
+		"memory": {"total": 128000000, "free": 64000000, "shared": 1000,
+		"buffered": 2000, "available": 70000000, "cached": 3000},
+		"swap": {"total": 0, "free": 0}}`,
+	"network.device status": `{
+		"eth0": {"up": true, "statistics": {"rx_bytes": 1000, "tx_bytes": 2000,
+			"rx_packets": 10, "tx_packets": 20, "rx_errors": 0, "tx_errors": 1,
+			"rx_dropped": 2, "tx_dropped": 0, "multicast": 5, "collisions": 0}},
+		"wlan0": {"up": false, "statistics": {"rx_bytes": 5, "tx_bytes": 6}}}`,
+	"iwinfo devices": `{"devices": ["wlan0"]}`,
+	"iwinfo assoclist": `{"results": [{"mac": "AA:BB:CC:DD:EE:FF",
+		"signal": -55, "noise": -95, "inactive": 120,
+		"rx": {"rate": 144400, "packets": 300},
+		"tx": {"rate": 72200, "packets": 200}}]}`,
+	"luci-rpc getDHCPLeases": `{"dhcp_leases": [{"macaddr": "aa:bb:cc:dd:ee:ff"},
+		{"macaddr": "11:22:33:44:55:66"}], "dhcp6_leases": []}`,
+}
+
+type fakeUbus struct {
+	sessions int
+	logins   int
+	expire   bool
+}
+
+func (f *fakeUbus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int           `json:"id"`
+		Params []interface{} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	session := req.Params[0].(string)
+	call := req.Params[1].(string) + " " + req.Params[2].(string)
+
+	if call == "session login" {
+		args := req.Params[3].(map[string]interface{})
+		if args["username"] != "root" || args["password"] != "secret" {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[6]}`, req.ID)
+			return
+		}
+		f.logins++
+		f.sessions++
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"ubus_rpc_session":"s%d"}]}`,
+			req.ID, f.sessions)
+		return
+	}
+
+	if session != fmt.Sprintf("s%d", f.sessions) || f.expire {
+		f.expire = false
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"error":{"code":-32002,"message":"Access denied"}}`,
+			req.ID)
+		return
+	}
+
+	reply, ok := replies[call]
+	if !ok {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[3]}`, req.ID)
+		return
+	}
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,%s]}`, req.ID, reply)
+}
+
+func newOpenWrt(u string) *OpenWrt {
+	return &OpenWrt{
+		URL:                   u,
+		Username:              "root",
+		Password:              "secret",
+		GatherInterfaces:      true,
+		GatherWirelessClients: true,
+		GatherDHCPLeases:      true,
+	}
+}
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(&fakeUbus{})
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	o := newOpenWrt(ts.URL + "/ubus")
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+
+	tags := map[string]string{"server": u.Host}
+	acc.AssertContainsTaggedFields(t, "openwrt_system",
+		map[string]interface{}{
+			"uptime":        int64(86400),
+			"load1":         0.5,
+			"load5":         0.25,
+			"load15":        1.0,
+			"mem_total":     int64(128000000),
+			"mem_free":      int64(64000000),
+			"mem_shared":    int64(1000),
+			"mem_buffered":  int64(2000),
+			"mem_available": int64(70000000),
+			"mem_cached":    int64(3000),
+			"swap_total":    int64(0),
+			"swap_free":     int64(0),
+		}, tags)
+
+	acc.AssertContainsTaggedFields(t, "openwrt_interface",
+		map[string]interface{}{
+			"link_up":    true,
+			"rx_bytes":   int64(1000),
+			"tx_bytes":   int64(2000),
+			"rx_packets": int64(10),
+			"tx_packets": int64(20),
+			"rx_errors":  int64(0),
+			"tx_errors":  int64(1),
+			"rx_dropped": int64(2),
+			"tx_dropped": int64(0),
+			"multicast":  int64(5),
+			"collisions": int64(0),
+		},
+		map[string]string{"server": u.Host, "interface": "eth0"})
+	acc.AssertContainsTaggedFields(t, "openwrt_interface",
+		map[string]interface{}{
+			"link_up":  false,
+			"rx_bytes": int64(5),
+			"tx_bytes": int64(6),
+		},
+		map[string]string{"server": u.Host, "interface": "wlan0"})
+
+	acc.AssertContainsTaggedFields(t, "openwrt_wireless",
+		map[string]interface{}{"clients": 1},
+		map[string]string{"server": u.Host, "device": "wlan0"})
+	acc.AssertContainsTaggedFields(t, "openwrt_wireless_client",
+		map[string]interface{}{
+			"signal":     int64(-55),
+			"noise":      int64(-95),
+			"inactive":   int64(120),
+			"rx_rate":    int64(144400),
+			"rx_packets": int64(300),
+			"tx_rate":    int64(72200),
+			"tx_packets": int64(200),
+		},
+		map[string]string{
+			"server": u.Host,
+			"device": "wlan0",
+			"mac":    "AA:BB:CC:DD:EE:FF",
+		})
+
+	acc.AssertContainsTaggedFields(t, "openwrt_dhcp",
+		map[string]interface{}{"leases": 2, "leases6": 0}, tags)
+}
+
+func TestSessionReuseAndRelogin(t *testing.T) {
+	ubus := &fakeUbus{}
+	ts := httptest.NewServer(ubus)
+	defer ts.Close()
+
+	o := newOpenWrt(ts.URL + "/ubus")
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+	require.NoError(t, o.Gather(&acc))
+	assert.Equal(t, 1, ubus.logins)
+
+	ubus.expire = true
+	require.NoError(t, o.Gather(&acc))
+	assert.Equal(t, 2, ubus.logins)
+}
+
+func TestLoginFailure(t *testing.T) {
+	ts := httptest.NewServer(&fakeUbus{})
+	defer ts.Close()
+
+	o := newOpenWrt(ts.URL + "/ubus")
+	o.Password = "wrong"
+	var acc testutil.Accumulator
+	assert.Error(t, o.Gather(&acc))
+	assert.Equal(t, 0, len(acc.Metrics))
+}
+
+func TestGatherOptional(t *testing.T) {
+	ts := httptest.NewServer(&fakeUbus{})
+	defer ts.Close()
+
+	o := newOpenWrt(ts.URL + "/ubus")
+	o.GatherInterfaces = false
+	o.GatherWirelessClients = false
+	o.GatherDHCPLeases = false
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+
+	assert.True(t, acc.HasMeasurement("openwrt_system"))
+	assert.False(t, acc.HasMeasurement("openwrt_interface"))
+	assert.False(t, acc.HasMeasurement("openwrt_wireless"))
+	assert.False(t, acc.HasMeasurement("openwrt_dhcp"))
+}
+
+func TestInventoryTags(t *testing.T) {
+	ts := httptest.NewServer(&fakeUbus{})
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "inventory")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "\"aa-bb-cc-dd-ee-ff\":\n  name: laptop\n")
+	f.Close()
+
+	o := newOpenWrt(ts.URL + "/ubus")
+	o.InventoryFile = f.Name()
+	var acc testutil.Accumulator
+	require.NoError(t, o.Gather(&acc))
+
+	m, ok := acc.Get("openwrt_wireless_client")
+	require.True(t, ok)
+	assert.Equal(t, "laptop", m.Tags["name"])
+	assert.Equal(t, "AA:BB:CC:DD:EE:FF", m.Tags["mac"])
+}
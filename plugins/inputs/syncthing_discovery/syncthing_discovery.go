@@ -0,0 +1,152 @@
+package syncthing_discovery
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/poller"
+	"github.com/influxdata/telegraf/plugins/inputs"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricPrefix is shared by all the metrics exported by stdiscosrv. Go
+// runtime and process metrics served on the same endpoint are skipped.
+const metricPrefix = "syncthing_discovery_"
+
+type SyncthingDiscovery struct {
+	Servers       []string
+	Timeout       internal.Duration
+	GatherTimeout internal.Duration
+	MaxFailures   int
+	SkipPolls     int
+	PollInterval  internal.Duration
+	PollJitter    internal.Duration
+
+	client *devicehttp.Client
+	poller poller.Poller
+}
+
+var sampleConfig = `
+  ## Metrics endpoints of the discovery servers to poll, as enabled with the
+  ## -metrics-listen option of stdiscosrv
+  servers = ["http://localhost:19200/metrics"]
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Maximum time a collection may take, all requests still running when it
+  ## expires are aborted. Keep it below the collection interval.
+  # gather_timeout = "10s"
+
+  ## Stop polling a server for skip_polls collections after max_failures
+  ## failed collections in a row, 0 never stops polling
+  # max_failures = 0
+  # skip_polls = 5
+
+  ## Poll every server only every poll_interval, with a random delay of up
+  ## to poll_jitter, instead of on every collection. Use a multiple of the
+  ## collection interval.
+  # poll_interval = "0s"
+  # poll_jitter = "0s"
+`
+
+func (s *SyncthingDiscovery) SampleConfig() string {
+	return sampleConfig
+}
+
+func (s *SyncthingDiscovery) Description() string {
+	return "Read request and database statistics from Syncthing discovery servers (stdiscosrv)"
+}
+
+func (s *SyncthingDiscovery) Gather(acc telegraf.Accumulator) error {
+	if s.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			Timeout: s.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+
+	s.poller.Measurement = "syncthing_discovery"
+	s.poller.GatherTimeout = s.GatherTimeout.Duration
+	s.poller.MaxFailures = s.MaxFailures
+	s.poller.SkipPolls = s.SkipPolls
+	s.poller.PollInterval = s.PollInterval.Duration
+	s.poller.PollJitter = s.PollJitter.Duration
+	return s.poller.Poll(s.client, s.Servers, acc, s.gatherServer)
+}
+
+func (s *SyncthingDiscovery) gatherServer(server string, acc telegraf.Accumulator) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", server, err)
+	}
+
+	b, err := s.client.Get(server)
+	if err != nil {
+		return err
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unable to parse metrics from %s: %s", server, err)
+	}
+
+	for name, family := range families {
+		if !strings.HasPrefix(name, metricPrefix) {
+			continue
+		}
+		name = strings.TrimPrefix(name, metricPrefix)
+
+		for _, m := range family.Metric {
+			fields := make(map[string]interface{})
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				addValue(fields, name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				addValue(fields, name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				addValue(fields, name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				fields[name+"_count"] = float64(m.GetSummary().GetSampleCount())
+				addValue(fields, name+"_sum", m.GetSummary().GetSampleSum())
+			case dto.MetricType_HISTOGRAM:
+				fields[name+"_count"] = float64(m.GetHistogram().GetSampleCount())
+				addValue(fields, name+"_sum", m.GetHistogram().GetSampleSum())
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			tags := map[string]string{"server": u.Host}
+			for _, label := range m.Label {
+				tags[label.GetName()] = label.GetValue()
+			}
+			acc.AddFields("syncthing_discovery", fields, tags)
+		}
+	}
+	return nil
+}
+
+func addValue(fields map[string]interface{}, name string, value float64) {
+	if !math.IsNaN(value) && !math.IsInf(value, 0) {
+		fields[name] = value
+	}
+}
+
+func init() {
+	inputs.Add("syncthing_discovery", func() telegraf.Input {
+		return &SyncthingDiscovery{SkipPolls: 5}
+	})
+}
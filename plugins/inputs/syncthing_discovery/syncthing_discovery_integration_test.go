@@ -0,0 +1,37 @@
+//go:build integration
+// +build integration
+
+package syncthing_discovery
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatherIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// query a device, so that the server has request metrics to report
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + testutil.GetLocalHost() +
+		":8443/v2/?device=MFZWI3D-BONSGYC-YLTMRWG-C43ENR5-QXGZDMM-FZWI3DP-BONSGYY-LTMRWAD")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	s := &SyncthingDiscovery{
+		Servers: []string{"http://" + testutil.GetLocalHost() + ":19200/metrics"},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	assert.True(t, acc.HasFloatField("syncthing_discovery", "api_requests_total"))
+	assert.True(t, acc.HasFloatField("syncthing_discovery", "api_requests_seconds_count"))
+}
@@ -0,0 +1,143 @@
+package syncthing_discovery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/influxdata/telegraf/testutil/httpfixture"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metricsText = `# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 23
+# HELP syncthing_discovery_api_requests_total Number of API requests.
+# TYPE syncthing_discovery_api_requests_total counter
+syncthing_discovery_api_requests_total{result="success",type="announce"} 1520
+syncthing_discovery_api_requests_total{result="success",type="query"} 8711
+syncthing_discovery_api_requests_total{result="not_found",type="query"} 312
+# HELP syncthing_discovery_api_requests_seconds Latency of API requests.
+# TYPE syncthing_discovery_api_requests_seconds summary
+syncthing_discovery_api_requests_seconds{type="query",quantile="0.5"} 0.0001
+syncthing_discovery_api_requests_seconds{type="query",quantile="0.99"} 0.002
+syncthing_discovery_api_requests_seconds_sum{type="query"} 1.25
+syncthing_discovery_api_requests_seconds_count{type="query"} 9023
+# HELP syncthing_discovery_database_keys Number of database keys at last count.
+# TYPE syncthing_discovery_database_keys gauge
+syncthing_discovery_database_keys{category="current"} 4021
+syncthing_discovery_database_keys{category="total"} 5100
+`
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, metricsText)
+	}))
+	defer ts.Close()
+
+	s := &SyncthingDiscovery{Servers: []string{ts.URL + "/metrics"}}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	acc.AssertContainsTaggedFields(t, "syncthing_discovery",
+		map[string]interface{}{"api_requests_total": float64(1520)},
+		map[string]string{"server": u.Host, "type": "announce", "result": "success"})
+	acc.AssertContainsTaggedFields(t, "syncthing_discovery",
+		map[string]interface{}{"api_requests_total": float64(312)},
+		map[string]string{"server": u.Host, "type": "query", "result": "not_found"})
+	acc.AssertContainsTaggedFields(t, "syncthing_discovery",
+		map[string]interface{}{
+			"api_requests_seconds_count": float64(9023),
+			"api_requests_seconds_sum":   1.25,
+		},
+		map[string]string{"server": u.Host, "type": "query"})
+	acc.AssertContainsTaggedFields(t, "syncthing_discovery",
+		map[string]interface{}{"database_keys": float64(4021)},
+		map[string]string{"server": u.Host, "category": "current"})
+
+	acc.AssertContainsTaggedFields(t, "syncthing_discovery",
+		map[string]interface{}{"up": 1},
+		map[string]string{"server": u.Host})
+
+	// runtime metrics are not reported
+	assert.Equal(t, 7, len(acc.Metrics))
+}
+
+func TestGatherInvalidMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "syncthing_discovery_api_requests_total{ 12\n")
+	}))
+	defer ts.Close()
+
+	s := &SyncthingDiscovery{Servers: []string{ts.URL + "/metrics"}}
+	var acc testutil.Accumulator
+	assert.Error(t, s.Gather(&acc))
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	acc.AssertContainsTaggedFields(t, "syncthing_discovery",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": u.Host, "reason": "error"})
+}
+
+// TestFixtures replays the metrics of several stdiscosrv releases recorded
+// with httpfixture.
+func TestFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics int
+		fields  map[string]interface{}
+		tags    map[string]string
+	}{
+		{
+			"stdiscosrv-v1.3.json", 7,
+			map[string]interface{}{
+				"api_requests_seconds_count": float64(1109),
+				"api_requests_seconds_sum":   0.31,
+			},
+			map[string]string{"type": "query"},
+		},
+		{
+			"stdiscosrv-v1.18.json", 9,
+			map[string]interface{}{
+				"api_requests_seconds_count": float64(24823000),
+				"api_requests_seconds_sum":   4210.7,
+			},
+			map[string]string{"type": "query"},
+		},
+		{
+			"stdiscosrv-v1.18.json", 9,
+			map[string]interface{}{"replication_sent_total": float64(820112)},
+			map[string]string{"result": "success"},
+		},
+	}
+
+	for _, tt := range tests {
+		srv, err := httpfixture.NewServer(filepath.Join("testdata", tt.name))
+		require.NoError(t, err, tt.name)
+
+		s := &SyncthingDiscovery{Servers: []string{srv.URL + "/metrics"}}
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc), tt.name)
+		require.NoError(t, srv.Close())
+		if srv.Recording() {
+			continue
+		}
+
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		tt.tags["server"] = u.Host
+
+		assert.Empty(t, srv.Unmatched(), tt.name)
+		assert.Equal(t, tt.metrics, len(acc.Metrics), tt.name)
+		acc.AssertContainsTaggedFields(t, "syncthing_discovery", tt.fields, tt.tags)
+	}
+}
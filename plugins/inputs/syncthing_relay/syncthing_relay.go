@@ -0,0 +1,137 @@
+package syncthing_relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/poller"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+type SyncthingRelay struct {
+	Servers       []string
+	Timeout       internal.Duration
+	GatherTimeout internal.Duration
+	MaxFailures   int
+	SkipPolls     int
+	PollInterval  internal.Duration
+	PollJitter    internal.Duration
+
+	client *devicehttp.Client
+	poller poller.Poller
+}
+
+var sampleConfig = `
+  ## Status endpoints of the relay servers to poll
+  servers = ["http://localhost:22070/status"]
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Maximum time a collection may take, all requests still running when it
+  ## expires are aborted. Keep it below the collection interval.
+  # gather_timeout = "10s"
+
+  ## Stop polling a server for skip_polls collections after max_failures
+  ## failed collections in a row, 0 never stops polling
+  # max_failures = 0
+  # skip_polls = 5
+
+  ## Poll every server only every poll_interval, with a random delay of up
+  ## to poll_jitter, instead of on every collection. Use a multiple of the
+  ## collection interval.
+  # poll_interval = "0s"
+  # poll_jitter = "0s"
+`
+
+func (s *SyncthingRelay) SampleConfig() string {
+	return sampleConfig
+}
+
+func (s *SyncthingRelay) Description() string {
+	return "Read session and traffic statistics from Syncthing relay servers (strelaysrv)"
+}
+
+func (s *SyncthingRelay) Gather(acc telegraf.Accumulator) error {
+	if s.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			Timeout: s.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+
+	s.poller.Measurement = "syncthing_relay"
+	s.poller.GatherTimeout = s.GatherTimeout.Duration
+	s.poller.MaxFailures = s.MaxFailures
+	s.poller.SkipPolls = s.SkipPolls
+	s.poller.PollInterval = s.PollInterval.Duration
+	s.poller.PollJitter = s.PollJitter.Duration
+	return s.poller.Poll(s.client, s.Servers, acc, s.gatherServer)
+}
+
+// status is the document served by strelaysrv on /status.
+type status struct {
+	UptimeSeconds         int64   `json:"uptimeSeconds"`
+	NumPendingSessionKeys int64   `json:"numPendingSessionKeys"`
+	NumActiveSessions     int64   `json:"numActiveSessions"`
+	NumConnections        int64   `json:"numConnections"`
+	NumProxies            int64   `json:"numProxies"`
+	BytesProxied          int64   `json:"bytesProxied"`
+	GoNumRoutine          int64   `json:"goNumRoutine"`
+	Kbps                  []int64 `json:"kbps10s1m5m15m30m60m"`
+}
+
+// kbpsFields names the averaging windows of status.Kbps, in order.
+var kbpsFields = []string{
+	"kbps_10s", "kbps_1m", "kbps_5m", "kbps_15m", "kbps_30m", "kbps_60m",
+}
+
+func (s *SyncthingRelay) gatherServer(server string, acc telegraf.Accumulator) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", server, err)
+	}
+
+	b, err := s.client.Get(server)
+	if err != nil {
+		return err
+	}
+
+	var st status
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("unable to parse status from %s: %s", server, err)
+	}
+
+	fields := map[string]interface{}{
+		"uptime":               st.UptimeSeconds,
+		"pending_session_keys": st.NumPendingSessionKeys,
+		"active_sessions":      st.NumActiveSessions,
+		"connections":          st.NumConnections,
+		"proxies":              st.NumProxies,
+		"bytes_proxied":        st.BytesProxied,
+		"goroutines":           st.GoNumRoutine,
+	}
+	for i, kbps := range st.Kbps {
+		if i >= len(kbpsFields) {
+			break
+		}
+		fields[kbpsFields[i]] = kbps
+	}
+
+	tags := map[string]string{"server": u.Host}
+	acc.AddFields("syncthing_relay", fields, tags)
+	return nil
+}
+
+func init() {
+	inputs.Add("syncthing_relay", func() telegraf.Input {
+		return &SyncthingRelay{SkipPolls: 5}
+	})
+}
@@ -0,0 +1,31 @@
+//go:build integration
+// +build integration
+
+package syncthing_relay
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatherIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s := &SyncthingRelay{
+		Servers: []string{"http://" + testutil.GetLocalHost() + ":22070/status"},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	m, ok := acc.Get("syncthing_relay")
+	require.True(t, ok)
+	assert.Equal(t, 13, len(m.Fields))
+	for _, field := range []string{"uptime", "active_sessions", "bytes_proxied", "kbps_60m"} {
+		assert.True(t, acc.HasIntField("syncthing_relay", field), field)
+	}
+}
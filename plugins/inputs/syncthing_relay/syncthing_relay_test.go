@@ -0,0 +1,231 @@
+package syncthing_relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/influxdata/telegraf/testutil/httpfixture"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statusJSON = `{
+  "bytesProxied": 123456789,
+  "goArch": "amd64",
+  "goMaxProcs": 4,
+  "goNumRoutine": 42,
+  "goOS": "linux",
+  "goVersion": "go1.6.2",
+  "kbps10s1m5m15m30m60m": [10, 20, 30, 40, 50, 60],
+  "numActiveSessions": 3,
+  "numConnections": 8,
+  "numPendingSessionKeys": 1,
+  "numProxies": 6,
+  "options": {
+    "global-rate": 0,
+    "message-timeout": 60,
+    "network-timeout": 120,
+    "per-session-rate": 0,
+    "ping-interval": 60,
+    "pools": ["https://relays.syncthing.net/endpoint"],
+    "provided-by": ""
+  },
+  "startTime": "2016-07-01T10:00:00Z",
+  "uptimeSeconds": 86400
+}`
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/status", r.URL.Path)
+		fmt.Fprint(w, statusJSON)
+	}))
+	defer ts.Close()
+
+	s := &SyncthingRelay{Servers: []string{ts.URL + "/status"}}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	acc.AssertContainsTaggedFields(t, "syncthing_relay",
+		map[string]interface{}{
+			"uptime":               int64(86400),
+			"pending_session_keys": int64(1),
+			"active_sessions":      int64(3),
+			"connections":          int64(8),
+			"proxies":              int64(6),
+			"bytes_proxied":        int64(123456789),
+			"goroutines":           int64(42),
+			"kbps_10s":             int64(10),
+			"kbps_1m":              int64(20),
+			"kbps_5m":              int64(30),
+			"kbps_15m":             int64(40),
+			"kbps_30m":             int64(50),
+			"kbps_60m":             int64(60),
+		},
+		map[string]string{"server": u.Host})
+}
+
+func TestGatherInvalidJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>not a relay</html>")
+	}))
+	defer ts.Close()
+
+	s := &SyncthingRelay{Servers: []string{ts.URL + "/status"}}
+	var acc testutil.Accumulator
+	assert.Error(t, s.Gather(&acc))
+
+	// only the up point is reported
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(acc.Metrics))
+	acc.AssertContainsTaggedFields(t, "syncthing_relay",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": u.Host, "reason": "error"})
+}
+
+func TestGatherHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	s := &SyncthingRelay{Servers: []string{ts.URL + "/status"}}
+	var acc testutil.Accumulator
+	assert.Error(t, s.Gather(&acc))
+}
+
+func TestGatherTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, statusJSON)
+	}))
+	defer fast.Close()
+
+	s := &SyncthingRelay{
+		Servers:       []string{slow.URL, fast.URL},
+		GatherTimeout: internal.Duration{Duration: 50 * time.Millisecond},
+	}
+	var acc testutil.Accumulator
+	start := time.Now()
+	assert.Error(t, s.Gather(&acc))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 3, len(acc.Metrics))
+
+	u, err := url.Parse(slow.URL)
+	require.NoError(t, err)
+	acc.AssertContainsTaggedFields(t, "syncthing_relay",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": u.Host, "reason": "timeout"})
+
+	// the status and the up point of the fast server share their tags
+	u, err = url.Parse(fast.URL)
+	require.NoError(t, err)
+	var up []interface{}
+	for _, m := range acc.Metrics {
+		if m.Tags["server"] == u.Host && m.Fields["up"] != nil {
+			up = append(up, m.Fields["up"])
+		}
+	}
+	assert.Equal(t, []interface{}{1}, up)
+}
+
+func TestGatherSkipsFailingServer(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	s := &SyncthingRelay{Servers: []string{ts.URL}, MaxFailures: 2, SkipPolls: 3}
+	var acc testutil.Accumulator
+	for i := 0; i < 5; i++ {
+		err := s.Gather(&acc)
+		require.Error(t, err)
+		if i >= 2 {
+			assert.Contains(t, err.Error(), "skipping")
+		}
+	}
+	assert.Equal(t, 2, requests)
+
+	// the server is tried again after the skipped polls
+	s.Gather(&acc)
+	assert.Equal(t, 3, requests)
+}
+
+func TestGatherPollInterval(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, statusJSON)
+	}))
+	defer ts.Close()
+
+	s := &SyncthingRelay{
+		Servers:      []string{ts.URL},
+		PollInterval: internal.Duration{Duration: time.Hour},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	assert.Equal(t, 2, len(acc.Metrics))
+
+	// the server is not due yet
+	acc = testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 0, len(acc.Metrics))
+}
+
+// TestFixtures replays the status pages of several strelaysrv releases
+// recorded with httpfixture.
+func TestFixtures(t *testing.T) {
+	expected := map[string]map[string]interface{}{
+		"strelaysrv-v0.14.json": {
+			"uptime":          int64(3600),
+			"active_sessions": int64(1),
+			"bytes_proxied":   int64(52428800),
+			"kbps_60m":        int64(3),
+		},
+		"strelaysrv-v1.4.json": {
+			"uptime":          int64(1209600),
+			"active_sessions": int64(37),
+			"bytes_proxied":   int64(9876543210),
+			"kbps_60m":        int64(688),
+		},
+	}
+
+	for name, fields := range expected {
+		srv, err := httpfixture.NewServer(filepath.Join("testdata", name))
+		require.NoError(t, err, name)
+
+		s := &SyncthingRelay{Servers: []string{srv.URL + "/status"}}
+		var acc testutil.Accumulator
+		require.NoError(t, s.Gather(&acc), name)
+		require.NoError(t, srv.Close())
+		if srv.Recording() {
+			continue
+		}
+
+		assert.Empty(t, srv.Unmatched(), name)
+		m, ok := acc.Get("syncthing_relay")
+		require.True(t, ok, name)
+		assert.Equal(t, 13, len(m.Fields), name)
+		for k, v := range fields {
+			assert.Equal(t, v, m.Fields[k], name+": "+k)
+		}
+	}
+}
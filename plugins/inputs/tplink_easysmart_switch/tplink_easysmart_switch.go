@@ -0,0 +1,290 @@
+package tplink_easysmart_switch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/internal/rollover"
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// member matches the `name:[...]` members of the JavaScript objects the web
+// interface embeds its data in, e.g. `var all_info = {state:[1,1], ...}`.
+var member = regexp.MustCompile(`(\w+)\s*:\s*\[([^\]]*)\]`)
+
+// scalar matches numeric variables such as `var max_port_num = 8;`.
+var scalar = regexp.MustCompile(`var\s+(\w+)\s*=\s*(\d+)\s*;`)
+
+// linkStatus describes the link_status codes of the port statistics page.
+// Other nonzero codes are links up at a speed that isn't known.
+var linkStatus = map[int64]struct {
+	up         bool
+	speed      int64
+	fullDuplex bool
+}{
+	0: {false, 0, false},
+	2: {true, 10, false},
+	3: {true, 10, true},
+	4: {true, 100, false},
+	5: {true, 100, true},
+	6: {true, 1000, true},
+}
+
+// Statistics are reported as four packet counters per port, in this order.
+var packetFields = []string{
+	"tx_good_packets", "tx_bad_packets", "rx_good_packets", "rx_bad_packets",
+}
+
+type TPLinkEasySmartSwitch struct {
+	Servers  []string
+	Username string
+	Password string
+	Timeout  internal.Duration
+
+	client    *devicehttp.Client
+	persister state.StatePersister
+	counters  *rollover.Cache
+
+	mu sync.Mutex
+	// hosts holds a lock per switch, see gatherServer
+	hosts map[string]*sync.Mutex
+}
+
+var sampleConfig = `
+  ## Base URLs of the switch web interfaces
+  servers = ["http://192.168.0.1"]
+
+  ## Credentials of the web interface
+  username = "admin"
+  password = "admin"
+
+  ## Timeout for each request
+  # timeout = "5s"
+`
+
+func (t *TPLinkEasySmartSwitch) SampleConfig() string {
+	return sampleConfig
+}
+
+func (t *TPLinkEasySmartSwitch) Description() string {
+	return "Read port status and packet counters from TP-Link Easy Smart switches (TL-SG10xE)"
+}
+
+// SetStatePersister keeps the counter wrap offsets in the state directory of
+// the agent, so the totals continue across restarts.
+func (t *TPLinkEasySmartSwitch) SetStatePersister(p state.StatePersister) {
+	t.persister = p
+}
+
+func (t *TPLinkEasySmartSwitch) Gather(acc telegraf.Accumulator) error {
+	if t.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			Timeout: t.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		// newer firmwares track the session with a cookie, older ones by
+		// the address of the client
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		client.HTTPClient.Jar = jar
+		t.client = client
+	}
+
+	if t.counters == nil {
+		var persister rollover.Persister
+		if t.persister != nil {
+			persister = state.Bind(t.persister, "counters")
+		}
+		counters := rollover.NewCache(persister)
+		// the counters of the switch start over after a reboot
+		counters.Threshold = rollover.RestartThreshold
+		if err := counters.Load(); err != nil {
+			return err
+		}
+		t.counters = counters
+	}
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(t.Servers) + 1)
+	wg.Add(len(t.Servers))
+	for _, server := range t.Servers {
+		go func(server string) {
+			defer wg.Done()
+			errChan.C <- t.gatherServer(server, acc)
+		}(server)
+	}
+
+	wg.Wait()
+	errChan.C <- t.counters.Save()
+	return errChan.Error()
+}
+
+func (t *TPLinkEasySmartSwitch) gatherServer(server string, acc telegraf.Accumulator) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", server, err)
+	}
+	base := strings.TrimRight(server, "/")
+
+	// a login ends the previous session of the switch, so a switch listed
+	// more than once is polled by one goroutine at a time
+	lock := t.hostLock(u.Host)
+	lock.Lock()
+	defer lock.Unlock()
+
+	stats, err := t.page(base, "/PortStatisticsRpm.htm", "state")
+	if err != nil {
+		return err
+	}
+
+	state := stats["state"]
+	links := stats["link_status"]
+	pkts := stats["pkts"]
+	ports := len(state)
+	if n, err := strconv.Atoi(firstValue(stats, "max_port_num")); err == nil && n < ports {
+		ports = n
+	}
+
+	for i := 0; i < ports; i++ {
+		fields := make(map[string]interface{})
+		if state[i] != "" {
+			fields["enabled"] = state[i] == "1"
+		}
+		if i < len(links) && links[i] != "" {
+			code, err := strconv.ParseInt(links[i], 10, 64)
+			if err == nil {
+				if link, ok := linkStatus[code]; ok {
+					fields["link_up"] = link.up
+					fields["speed"] = link.speed
+					fields["full_duplex"] = link.fullDuplex
+				} else {
+					fields["link_up"] = true
+				}
+			}
+		}
+		port := strconv.Itoa(i + 1)
+		for j, name := range packetFields {
+			k := i*len(packetFields) + j
+			if k >= len(pkts) {
+				break
+			}
+			if pkts[k] == "" {
+				continue
+			}
+			if v, err := strconv.ParseUint(pkts[k], 10, 64); err == nil {
+				fields[name] = t.counters.Compensate(u.Host, port+"."+name, v)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		tags := map[string]string{
+			"server": u.Host,
+			"port":   port,
+		}
+		acc.AddFields("tplink_easysmart_switch", fields, tags)
+	}
+	return nil
+}
+
+func (t *TPLinkEasySmartSwitch) hostLock(host string) *sync.Mutex {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts == nil {
+		t.hosts = make(map[string]*sync.Mutex)
+	}
+	lock, ok := t.hosts[host]
+	if !ok {
+		lock = &sync.Mutex{}
+		t.hosts[host] = lock
+	}
+	return lock
+}
+
+// page fetches a page of the web interface and returns the members found in
+// it. The switch answers with its login page if there is no session, so if
+// the expected member is missing it logs in and fetches the page again.
+func (t *TPLinkEasySmartSwitch) page(
+	base, path, expect string,
+) (map[string][]string, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		b, err := t.client.Get(base + path)
+		if err != nil {
+			return nil, err
+		}
+		members := parseMembers(b)
+		if _, ok := members[expect]; ok {
+			return members, nil
+		}
+		if attempt == 0 {
+			if err := t.login(base); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("login to %s failed: no data on %s", base, path)
+}
+
+func (t *TPLinkEasySmartSwitch) login(base string) error {
+	form := url.Values{}
+	form.Set("username", t.Username)
+	form.Set("password", t.Password)
+	form.Set("cpassword", "")
+	form.Set("logon", "Login")
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// the switch rejects requests that don't come from its own pages
+	header.Set("Referer", base+"/")
+	_, err := t.client.Do("POST", base+"/logon.cgi", header, []byte(form.Encode()))
+	return err
+}
+
+// parseMembers returns the elements of every `name:[...]` array member, and
+// of `var name = value;` scalars as single element arrays. Blank elements are
+// kept as empty strings, as the arrays are indexed by port.
+func parseMembers(b []byte) map[string][]string {
+	members := make(map[string][]string)
+	for _, m := range member.FindAllSubmatch(b, -1) {
+		var values []string
+		if strings.TrimSpace(string(m[2])) != "" {
+			for _, v := range strings.Split(string(m[2]), ",") {
+				values = append(values, strings.Trim(strings.TrimSpace(v), `"'`))
+			}
+		}
+		members[string(m[1])] = values
+	}
+	for _, m := range scalar.FindAllSubmatch(b, -1) {
+		members[string(m[1])] = []string{string(m[2])}
+	}
+	return members
+}
+
+func firstValue(members map[string][]string, name string) string {
+	if values := members[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func init() {
+	inputs.Add("tplink_easysmart_switch", func() telegraf.Input {
+		return &TPLinkEasySmartSwitch{}
+	})
+}
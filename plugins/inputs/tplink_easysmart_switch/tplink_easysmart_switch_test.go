@@ -0,0 +1,308 @@
+package tplink_easysmart_switch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const loginPage = `<html><head><script type="text/javascript">
+var logonInfo = new Array(
+0,
+0,0);
+var g_Lan = 0;
+</script></head><body></body></html>`
+
+const statisticsPage = `<html><head><script type="text/javascript">
+var max_port_num = 5;
+var port_middle_num  = 16;
+var all_info = {
+state:[1,1,1,0,1,0,0],
+link_status:[6,0,5,0,2,0,0],
+pkts:[1000,1,2000,2,0,0,0,0,300,0,400,0,0,0,0,0,10,0,20,5,0,0]
+};
+</script></head><body></body></html>`
+
+// fakeSwitch keeps a single session, which every login replaces.
+type fakeSwitch struct {
+	sync.Mutex
+	// page is served instead of statisticsPage if set
+	page   string
+	logins int
+}
+
+func (f *fakeSwitch) setPage(page string) {
+	f.Lock()
+	f.page = page
+	f.Unlock()
+}
+
+func (f *fakeSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/logon.cgi":
+		r.ParseForm()
+		if r.Method == "POST" && r.Form.Get("username") == "admin" &&
+			r.Form.Get("password") == "secret" {
+			f.Lock()
+			f.logins++
+			session := fmt.Sprintf("session%d", f.logins)
+			f.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "H_P_SSID", Value: session})
+		}
+		fmt.Fprint(w, loginPage)
+	case "/PortStatisticsRpm.htm":
+		f.Lock()
+		session := fmt.Sprintf("session%d", f.logins)
+		page := f.page
+		f.Unlock()
+		if c, err := r.Cookie("H_P_SSID"); err != nil || c.Value != session {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+		if page != "" {
+			fmt.Fprint(w, page)
+			return
+		}
+		fmt.Fprint(w, statisticsPage)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newSwitch(server string) *TPLinkEasySmartSwitch {
+	return &TPLinkEasySmartSwitch{
+		Servers:  []string{server},
+		Username: "admin",
+		Password: "secret",
+	}
+}
+
+func TestGather(t *testing.T) {
+	sw := &fakeSwitch{}
+	ts := httptest.NewServer(sw)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	s := newSwitch(ts.URL)
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	assert.Equal(t, 5, len(acc.Metrics))
+	acc.AssertContainsTaggedFields(t, "tplink_easysmart_switch",
+		map[string]interface{}{
+			"enabled":         true,
+			"link_up":         true,
+			"speed":           int64(1000),
+			"full_duplex":     true,
+			"tx_good_packets": uint64(1000),
+			"tx_bad_packets":  uint64(1),
+			"rx_good_packets": uint64(2000),
+			"rx_bad_packets":  uint64(2),
+		},
+		map[string]string{"server": u.Host, "port": "1"})
+	acc.AssertContainsTaggedFields(t, "tplink_easysmart_switch",
+		map[string]interface{}{
+			"enabled":         false,
+			"link_up":         false,
+			"speed":           int64(0),
+			"full_duplex":     false,
+			"tx_good_packets": uint64(0),
+			"tx_bad_packets":  uint64(0),
+			"rx_good_packets": uint64(0),
+			"rx_bad_packets":  uint64(0),
+		},
+		map[string]string{"server": u.Host, "port": "4"})
+	acc.AssertContainsTaggedFields(t, "tplink_easysmart_switch",
+		map[string]interface{}{
+			"enabled":         true,
+			"link_up":         true,
+			"speed":           int64(10),
+			"full_duplex":     false,
+			"tx_good_packets": uint64(10),
+			"tx_bad_packets":  uint64(0),
+			"rx_good_packets": uint64(20),
+			"rx_bad_packets":  uint64(5),
+		},
+		map[string]string{"server": u.Host, "port": "5"})
+
+	// the session is reused
+	require.NoError(t, s.Gather(&acc))
+	sw.Lock()
+	assert.Equal(t, 1, sw.logins)
+	sw.Unlock()
+}
+
+func TestGatherSameSwitchTwice(t *testing.T) {
+	sw := &fakeSwitch{}
+	ts := httptest.NewServer(sw)
+	defer ts.Close()
+
+	s := newSwitch(ts.URL)
+	s.Servers = []string{ts.URL, ts.URL + "/"}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+	assert.Equal(t, 10, len(acc.Metrics))
+
+	// the second poll used the session of the first
+	sw.Lock()
+	assert.Equal(t, 1, sw.logins)
+	sw.Unlock()
+}
+
+func TestLoginFailure(t *testing.T) {
+	ts := httptest.NewServer(&fakeSwitch{})
+	defer ts.Close()
+
+	s := newSwitch(ts.URL)
+	s.Password = "wrong"
+	var acc testutil.Accumulator
+	assert.Error(t, s.Gather(&acc))
+	assert.Equal(t, 0, len(acc.Metrics))
+}
+
+func TestGatherBlankEntries(t *testing.T) {
+	// the counters of port 2 are blank, and must not shift those of port 3
+	sw := &fakeSwitch{page: `<script type="text/javascript">
+var max_port_num = 3;
+var all_info = {
+state:[1,,1],
+link_status:[6,,5],
+pkts:[1000,1,2000,2,,,,,300,0,400,0]
+};
+</script>`}
+	ts := httptest.NewServer(sw)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	var acc testutil.Accumulator
+	require.NoError(t, newSwitch(ts.URL).Gather(&acc))
+
+	assert.Equal(t, 2, len(acc.Metrics))
+	acc.AssertContainsTaggedFields(t, "tplink_easysmart_switch",
+		map[string]interface{}{
+			"enabled":         true,
+			"link_up":         true,
+			"speed":           int64(100),
+			"full_duplex":     true,
+			"tx_good_packets": uint64(300),
+			"tx_bad_packets":  uint64(0),
+			"rx_good_packets": uint64(400),
+			"rx_bad_packets":  uint64(0),
+		},
+		map[string]string{"server": u.Host, "port": "3"})
+}
+
+func TestGatherUnknownLinkStatus(t *testing.T) {
+	sw := &fakeSwitch{page: `<script type="text/javascript">
+var max_port_num = 2;
+var all_info = {
+state:[1,1],
+link_status:[7,x],
+pkts:[1000,1,2000,2,0,0,0,0]
+};
+</script>`}
+	ts := httptest.NewServer(sw)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	var acc testutil.Accumulator
+	require.NoError(t, newSwitch(ts.URL).Gather(&acc))
+
+	// the link is up, at a speed the plugin doesn't know
+	acc.AssertContainsTaggedFields(t, "tplink_easysmart_switch",
+		map[string]interface{}{
+			"enabled":         true,
+			"link_up":         true,
+			"tx_good_packets": uint64(1000),
+			"tx_bad_packets":  uint64(1),
+			"rx_good_packets": uint64(2000),
+			"rx_bad_packets":  uint64(2),
+		},
+		map[string]string{"server": u.Host, "port": "1"})
+	// an unreadable code reports no link state at all
+	acc.AssertContainsTaggedFields(t, "tplink_easysmart_switch",
+		map[string]interface{}{
+			"enabled":         true,
+			"tx_good_packets": uint64(0),
+			"tx_bad_packets":  uint64(0),
+			"rx_good_packets": uint64(0),
+			"rx_bad_packets":  uint64(0),
+		},
+		map[string]string{"server": u.Host, "port": "2"})
+}
+
+func countersPage(txGood uint64) string {
+	return fmt.Sprintf(`<script type="text/javascript">
+var max_port_num = 1;
+var all_info = {
+state:[1],
+link_status:[6],
+pkts:[%d,0,0,0]
+};
+</script>`, txGood)
+}
+
+func TestCounterWrap(t *testing.T) {
+	sw := &fakeSwitch{page: countersPage(4294967000)}
+	ts := httptest.NewServer(sw)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "tplink_easysmart_switch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newSwitch(ts.URL)
+	s.SetStatePersister(&state.DirPersister{Dir: dir})
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	sw.setPage(countersPage(10))
+	acc = testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	m, ok := acc.Get("tplink_easysmart_switch")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4294967296+10), m.Fields["tx_good_packets"])
+
+	// a new instance continues from the state file
+	s = newSwitch(ts.URL)
+	s.SetStatePersister(&state.DirPersister{Dir: dir})
+	acc = testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	m, ok = acc.Get("tplink_easysmart_switch")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4294967296+10), m.Fields["tx_good_packets"])
+
+	// after a reboot the counters start over
+	sw.setPage(countersPage(5))
+	acc = testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	m, ok = acc.Get("tplink_easysmart_switch")
+	require.True(t, ok)
+	assert.Equal(t, uint64(5), m.Fields["tx_good_packets"])
+}
+
+func TestParseMembers(t *testing.T) {
+	members := parseMembers([]byte(`var all_info = {state:[1, ,'0'], pkts:[]};
+var max_port_num = 8;`))
+	assert.Equal(t, map[string][]string{
+		"state":        {"1", "", "0"},
+		"pkts":         nil,
+		"max_port_num": {"8"},
+	}, members)
+}
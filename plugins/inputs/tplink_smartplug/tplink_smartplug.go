@@ -0,0 +1,220 @@
+package tplink_smartplug
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const defaultPort = "9999"
+
+// maxResponseSize guards against garbage length prefixes.
+const maxResponseSize = 64 * 1024
+
+// query asks for the device information and the current energy meter reading
+// in a single round trip.
+const query = `{"system":{"get_sysinfo":{}},"emeter":{"get_realtime":{}}}`
+
+type TPLinkSmartPlug struct {
+	Addresses []string
+	Timeout   internal.Duration
+}
+
+var sampleConfig = `
+  ## Smart plugs to poll, as "host" or "host:port" (default port 9999)
+  addresses = ["192.168.0.20"]
+
+  ## Timeout for connecting to and reading from each plug
+  timeout = "5s"
+`
+
+func (t *TPLinkSmartPlug) SampleConfig() string {
+	return sampleConfig
+}
+
+func (t *TPLinkSmartPlug) Description() string {
+	return "Read power and energy metrics from TP-Link Kasa smart plugs (HS110, KP115)"
+}
+
+func (t *TPLinkSmartPlug) Gather(acc telegraf.Accumulator) error {
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(t.Addresses))
+	wg.Add(len(t.Addresses))
+	for _, address := range t.Addresses {
+		go func(address string) {
+			defer wg.Done()
+			errChan.C <- t.gatherPlug(address, acc)
+		}(address)
+	}
+
+	wg.Wait()
+	return errChan.Error()
+}
+
+type response struct {
+	System struct {
+		SysInfo struct {
+			ErrCode    int    `json:"err_code"`
+			Alias      string `json:"alias"`
+			Model      string `json:"model"`
+			RelayState int    `json:"relay_state"`
+			OnTime     int64  `json:"on_time"`
+			RSSI       int64  `json:"rssi"`
+		} `json:"get_sysinfo"`
+	} `json:"system"`
+	Emeter struct {
+		Realtime *realtime `json:"get_realtime"`
+	} `json:"emeter"`
+}
+
+// realtime holds an energy meter reading. Hardware version 1 of the HS110
+// reports volts, amps, watts and kWh as floats, later revisions and the KP115
+// report millivolts, milliamps, milliwatts and Wh.
+type realtime struct {
+	ErrCode int `json:"err_code"`
+
+	Voltage *float64 `json:"voltage"`
+	Current *float64 `json:"current"`
+	Power   *float64 `json:"power"`
+	Total   *float64 `json:"total"`
+
+	VoltageMV *float64 `json:"voltage_mv"`
+	CurrentMA *float64 `json:"current_ma"`
+	PowerMW   *float64 `json:"power_mw"`
+	TotalWH   *float64 `json:"total_wh"`
+}
+
+func (t *TPLinkSmartPlug) gatherPlug(address string, acc telegraf.Accumulator) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+
+	b, err := t.query(address, []byte(query))
+	if err != nil {
+		return err
+	}
+
+	var resp response
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return fmt.Errorf("unable to parse response from %s: %s", address, err)
+	}
+
+	info := resp.System.SysInfo
+	if info.ErrCode != 0 {
+		return fmt.Errorf("%s returned error code %d for get_sysinfo",
+			address, info.ErrCode)
+	}
+
+	tags := map[string]string{"address": address}
+	if info.Alias != "" {
+		tags["alias"] = info.Alias
+	}
+	if info.Model != "" {
+		tags["model"] = info.Model
+	}
+	fields := map[string]interface{}{
+		"relay_state": info.RelayState,
+		"on_time":     info.OnTime,
+		"rssi":        info.RSSI,
+	}
+
+	// plugs without an energy meter (e.g. HS100) answer the emeter module
+	// with an error, they still report their relay state
+	if rt := resp.Emeter.Realtime; rt != nil && rt.ErrCode == 0 {
+		setField(fields, "voltage", rt.Voltage, rt.VoltageMV, 1000)
+		setField(fields, "current", rt.Current, rt.CurrentMA, 1000)
+		setField(fields, "power", rt.Power, rt.PowerMW, 1000)
+		if rt.Total != nil {
+			fields["total_energy"] = *rt.Total * 1000
+		} else if rt.TotalWH != nil {
+			fields["total_energy"] = *rt.TotalWH
+		}
+	}
+
+	acc.AddFields("tplink_smartplug", fields, tags)
+	return nil
+}
+
+// setField stores value in fields, falling back to the milli-unit variant
+// scaled down by divisor.
+func setField(
+	fields map[string]interface{},
+	name string,
+	value, milli *float64,
+	divisor float64,
+) {
+	if value != nil {
+		fields[name] = *value
+	} else if milli != nil {
+		fields[name] = *milli / divisor
+	}
+}
+
+// query sends a request to a plug and returns the decrypted response.
+func (t *TPLinkSmartPlug) query(address string, request []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", address, t.Timeout.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %s", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(t.Timeout.Duration))
+
+	if _, err := conn.Write(encrypt(request)); err != nil {
+		return nil, fmt.Errorf("unable to send request to %s: %s", address, err)
+	}
+
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("unable to read response from %s: %s", address, err)
+	}
+	if length > maxResponseSize {
+		return nil, fmt.Errorf("%s announced a %d byte response", address, length)
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, fmt.Errorf("unable to read response from %s: %s", address, err)
+	}
+	return decrypt(b), nil
+}
+
+// encrypt obfuscates a request with the autokey XOR cipher used by the Kasa
+// protocol and prepends the big endian length of the payload.
+func encrypt(plain []byte) []byte {
+	out := make([]byte, 4+len(plain))
+	binary.BigEndian.PutUint32(out, uint32(len(plain)))
+	key := byte(171)
+	for i, c := range plain {
+		key ^= c
+		out[4+i] = key
+	}
+	return out
+}
+
+// decrypt reverses encrypt for a payload without its length prefix.
+func decrypt(cipher []byte) []byte {
+	out := make([]byte, len(cipher))
+	key := byte(171)
+	for i, c := range cipher {
+		out[i] = key ^ c
+		key = c
+	}
+	return out
+}
+
+func init() {
+	inputs.Add("tplink_smartplug", func() telegraf.Input {
+		return &TPLinkSmartPlug{
+			Timeout: internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package tplink_smartplug
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hs110v1Response = `{
+  "system": {"get_sysinfo": {"err_code": 0, "alias": "Fridge",
+    "model": "HS110(EU)", "relay_state": 1, "on_time": 3600, "rssi": -52}},
+  "emeter": {"get_realtime": {"err_code": 0, "voltage": 230.5,
+    "current": 0.5, "power": 115.25, "total": 12.5}}
+}`
+
+const kp115Response = `{
+  "system": {"get_sysinfo": {"err_code": 0, "alias": "Washer",
+    "model": "KP115(EU)", "relay_state": 0, "on_time": 0, "rssi": -60}},
+  "emeter": {"get_realtime": {"err_code": 0, "voltage_mv": 231000,
+    "current_ma": 250, "power_mw": 57750, "total_wh": 1500}}
+}`
+
+const hs100Response = `{
+  "system": {"get_sysinfo": {"err_code": 0, "alias": "Lamp",
+    "model": "HS100(EU)", "relay_state": 1, "on_time": 10, "rssi": -40}},
+  "emeter": {"err_code": -1, "err_msg": "module not support"}
+}`
+
+// fakePlug answers every connection with the given response.
+func fakePlug(t *testing.T, response string) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var length uint32
+			if err := binary.Read(conn, binary.BigEndian, &length); err == nil {
+				b := make([]byte, length)
+				if _, err := io.ReadFull(conn, b); err == nil {
+					assert.Equal(t, query, string(decrypt(b)))
+					conn.Write(encrypt([]byte(response)))
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	msg := []byte(query)
+	enc := encrypt(msg)
+	assert.Equal(t, uint32(len(msg)), binary.BigEndian.Uint32(enc))
+	assert.Equal(t, msg, decrypt(enc[4:]))
+	// first byte is XORed with the initial key
+	assert.Equal(t, byte('{')^171, enc[4])
+}
+
+func TestGatherV1(t *testing.T) {
+	addr, stop := fakePlug(t, hs110v1Response)
+	defer stop()
+
+	plug := &TPLinkSmartPlug{
+		Addresses: []string{addr},
+		Timeout:   internal.Duration{Duration: time.Second},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, plug.Gather(&acc))
+
+	acc.AssertContainsTaggedFields(t, "tplink_smartplug",
+		map[string]interface{}{
+			"relay_state":  1,
+			"on_time":      int64(3600),
+			"rssi":         int64(-52),
+			"voltage":      230.5,
+			"current":      0.5,
+			"power":        115.25,
+			"total_energy": 12500.0,
+		},
+		map[string]string{
+			"address": addr,
+			"alias":   "Fridge",
+			"model":   "HS110(EU)",
+		})
+}
+
+func TestGatherV2(t *testing.T) {
+	addr, stop := fakePlug(t, kp115Response)
+	defer stop()
+
+	plug := &TPLinkSmartPlug{
+		Addresses: []string{addr},
+		Timeout:   internal.Duration{Duration: time.Second},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, plug.Gather(&acc))
+
+	acc.AssertContainsTaggedFields(t, "tplink_smartplug",
+		map[string]interface{}{
+			"relay_state":  0,
+			"on_time":      int64(0),
+			"rssi":         int64(-60),
+			"voltage":      231.0,
+			"current":      0.25,
+			"power":        57.75,
+			"total_energy": 1500.0,
+		},
+		map[string]string{
+			"address": addr,
+			"alias":   "Washer",
+			"model":   "KP115(EU)",
+		})
+}
+
+func TestGatherWithoutEmeter(t *testing.T) {
+	addr, stop := fakePlug(t, hs100Response)
+	defer stop()
+
+	plug := &TPLinkSmartPlug{
+		Addresses: []string{addr},
+		Timeout:   internal.Duration{Duration: time.Second},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, plug.Gather(&acc))
+
+	acc.AssertContainsTaggedFields(t, "tplink_smartplug",
+		map[string]interface{}{
+			"relay_state": 1,
+			"on_time":     int64(10),
+			"rssi":        int64(-40),
+		},
+		map[string]string{
+			"address": addr,
+			"alias":   "Lamp",
+			"model":   "HS100(EU)",
+		})
+}
+
+func TestGatherConnectionError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	plug := &TPLinkSmartPlug{
+		Addresses: []string{addr},
+		Timeout:   internal.Duration{Duration: time.Second},
+	}
+	var acc testutil.Accumulator
+	assert.Error(t, plug.Gather(&acc))
+	assert.Equal(t, 0, acc.NFields())
+}
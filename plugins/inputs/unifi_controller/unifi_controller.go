@@ -0,0 +1,373 @@
+package unifi_controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/availability"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// unifiOSPrefix is the path of the Network application on UniFi OS
+// consoles, such as the UDM or the Cloud Key Gen2.
+const unifiOSPrefix = "/proxy/network"
+
+type UnifiController struct {
+	URL      string
+	Username string
+	Password string
+	UnifiOS  bool `toml:"unifi_os"`
+	Sites    []string
+	Timeout  internal.Duration
+
+	SSLCA              string `toml:"ssl_ca"`
+	SSLCert            string `toml:"ssl_cert"`
+	SSLKey             string `toml:"ssl_key"`
+	InsecureSkipVerify bool
+
+	client   *devicehttp.Client
+	loggedIn bool
+}
+
+var sampleConfig = `
+  ## URL of the UniFi controller
+  url = "https://127.0.0.1:8443"
+
+  ## Credentials of a controller user; a read-only admin is sufficient
+  username = "telegraf"
+  password = ""
+
+  ## Set for controllers running on UniFi OS, such as the UDM, UDM Pro or
+  ## Cloud Key Gen2, whose URL has no port, e.g. "https://192.168.1.1"
+  # unifi_os = false
+
+  ## Short names of the sites to gather, as found in the URL of the site in
+  ## the web interface, all sites if empty
+  # sites = ["default"]
+
+  ## Timeout for each request
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification; the controller uses a
+  ## self-signed certificate by default
+  insecure_skip_verify = true
+`
+
+func (c *UnifiController) SampleConfig() string {
+	return sampleConfig
+}
+
+func (c *UnifiController) Description() string {
+	return "Read access point, switch and client statistics from UniFi controllers"
+}
+
+// envelope wraps every response of the controller API.
+type envelope struct {
+	Meta struct {
+		RC  string `json:"rc"`
+		Msg string `json:"msg"`
+	} `json:"meta"`
+	Data json.RawMessage `json:"data"`
+}
+
+type site struct {
+	Name string `json:"name"`
+}
+
+type device struct {
+	Type        string                 `json:"type"`
+	MAC         string                 `json:"mac"`
+	Name        string                 `json:"name"`
+	Model       string                 `json:"model"`
+	Version     string                 `json:"version"`
+	State       int64                  `json:"state"`
+	Uptime      int64                  `json:"uptime"`
+	NumSta      int64                  `json:"num_sta"`
+	UserNumSta  int64                  `json:"user-num_sta"`
+	GuestNumSta int64                  `json:"guest-num_sta"`
+	RxBytes     float64                `json:"rx_bytes"`
+	TxBytes     float64                `json:"tx_bytes"`
+	SystemStats map[string]interface{} `json:"system-stats"`
+	PortTable   []port                 `json:"port_table"`
+}
+
+type port struct {
+	Index   int64   `json:"port_idx"`
+	Name    string  `json:"name"`
+	Up      bool    `json:"up"`
+	Speed   int64   `json:"speed"`
+	RxBytes float64 `json:"rx_bytes"`
+	TxBytes float64 `json:"tx_bytes"`
+}
+
+type station struct {
+	MAC     string `json:"mac"`
+	IsWired bool   `json:"is_wired"`
+	ESSID   string `json:"essid"`
+}
+
+func (c *UnifiController) Gather(acc telegraf.Accumulator) error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", c.URL, err)
+	}
+
+	if c.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			SSLCA:              c.SSLCA,
+			SSLCert:            c.SSLCert,
+			SSLKey:             c.SSLKey,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+			Timeout:            c.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		// the session is kept in the unifises cookie, or the TOKEN cookie
+		// on UniFi OS
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		client.HTTPClient.Jar = jar
+		c.client = client
+	}
+
+	err = c.gatherSites(u.Host, acc)
+	availability.Add(acc, "unifi_controller", map[string]string{"server": u.Host}, err)
+	return err
+}
+
+func (c *UnifiController) gatherSites(host string, acc telegraf.Accumulator) error {
+	var sites []site
+	if err := c.get("/api/self/sites", &sites); err != nil {
+		return err
+	}
+
+	for _, s := range sites {
+		if !c.wantSite(s.Name) {
+			continue
+		}
+		tags := map[string]string{"server": host, "site": s.Name}
+		if err := c.gatherSite(s.Name, tags, acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *UnifiController) wantSite(name string) bool {
+	if len(c.Sites) == 0 {
+		return true
+	}
+	for _, s := range c.Sites {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *UnifiController) gatherSite(
+	name string,
+	tags map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	var devices []device
+	if err := c.get("/api/s/"+url.QueryEscape(name)+"/stat/device", &devices); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		dtags := copyTags(tags)
+		dtags["mac"] = d.MAC
+		dtags["type"] = d.Type
+		if d.Name != "" {
+			dtags["name"] = d.Name
+		}
+		if d.Model != "" {
+			dtags["model"] = d.Model
+		}
+		fields := map[string]interface{}{
+			"state":         d.State,
+			"uptime":        d.Uptime,
+			"clients":       d.NumSta,
+			"user_clients":  d.UserNumSta,
+			"guest_clients": d.GuestNumSta,
+			"rx_bytes":      int64(d.RxBytes),
+			"tx_bytes":      int64(d.TxBytes),
+		}
+		if d.Version != "" {
+			fields["version"] = d.Version
+		}
+		// the controller reports the load as strings
+		for _, stat := range []string{"cpu", "mem"} {
+			if v, ok := statValue(d.SystemStats[stat]); ok {
+				fields[stat] = v
+			}
+		}
+		acc.AddFields("unifi_device", fields, dtags)
+
+		for _, p := range d.PortTable {
+			ptags := copyTags(dtags)
+			ptags["port"] = strconv.FormatInt(p.Index, 10)
+			if p.Name != "" {
+				ptags["port_name"] = p.Name
+			}
+			acc.AddFields("unifi_port", map[string]interface{}{
+				"link_up":  p.Up,
+				"speed":    p.Speed,
+				"rx_bytes": int64(p.RxBytes),
+				"tx_bytes": int64(p.TxBytes),
+			}, ptags)
+		}
+	}
+
+	var clients []station
+	if err := c.get("/api/s/"+url.QueryEscape(name)+"/stat/sta", &clients); err != nil {
+		return err
+	}
+
+	ssids := make(map[string]int)
+	wired := 0
+	for _, s := range clients {
+		if s.IsWired {
+			wired++
+			continue
+		}
+		ssids[s.ESSID]++
+	}
+	for ssid, n := range ssids {
+		stags := copyTags(tags)
+		stags["ssid"] = ssid
+		acc.AddFields("unifi_ssid", map[string]interface{}{"clients": n}, stags)
+	}
+
+	acc.AddFields("unifi_site", map[string]interface{}{
+		"devices":          len(devices),
+		"clients":          len(clients),
+		"wireless_clients": len(clients) - wired,
+		"wired_clients":    wired,
+	}, tags)
+	return nil
+}
+
+// statValue converts a value of the system-stats object, a string or a
+// number, to a float.
+func statValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// get calls the controller API, logging in first if there is no session yet
+// or the session expired.
+func (c *UnifiController) get(path string, v interface{}) error {
+	if !c.loggedIn {
+		if err := c.login(); err != nil {
+			return err
+		}
+	}
+
+	if c.UnifiOS {
+		path = unifiOSPrefix + path
+	}
+	err := c.call("GET", path, nil, v)
+	if serr, ok := err.(*devicehttp.StatusError); ok && serr.StatusCode == http.StatusUnauthorized {
+		if err := c.login(); err != nil {
+			return err
+		}
+		err = c.call("GET", path, nil, v)
+	}
+	return err
+}
+
+func (c *UnifiController) login() error {
+	c.loggedIn = false
+	body, err := json.Marshal(map[string]string{
+		"username": c.Username,
+		"password": c.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := "/api/login"
+	if c.UnifiOS {
+		path = "/api/auth/login"
+	}
+	if err := c.call("POST", path, body, nil); err != nil {
+		return fmt.Errorf("login to %s failed: %s", c.URL, err)
+	}
+	c.loggedIn = true
+	return nil
+}
+
+type apiError struct {
+	Msg string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("controller returned error: %s", e.Msg)
+}
+
+func (c *UnifiController) call(method, path string, body []byte, v interface{}) error {
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+
+	b, err := c.client.Do(method, strings.TrimRight(c.URL, "/")+path, header, body)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		// the login response of UniFi OS is not wrapped in an envelope
+		return nil
+	}
+
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return fmt.Errorf("unable to parse response of %s: %s", path, err)
+	}
+	if env.Meta.RC != "ok" {
+		return &apiError{Msg: env.Meta.Msg}
+	}
+	if len(env.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(env.Data, v); err != nil {
+		return fmt.Errorf("unable to parse response of %s: %s", path, err)
+	}
+	return nil
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags)+4)
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+func init() {
+	inputs.Add("unifi_controller", func() telegraf.Input {
+		return &UnifiController{}
+	})
+}
@@ -0,0 +1,209 @@
+package unifi_controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sitesReply   = `[{"_id":"s1","name":"default","desc":"Default"},{"_id":"s2","name":"x7k2mq","desc":"Office"}]`
+	devicesReply = `[
+		{"type":"uap","mac":"f0:9f:c2:00:00:01","name":"Hall","model":"U7PG2",
+		 "version":"6.5.55.14277","state":1,"uptime":3600,"num_sta":3,
+		 "user-num_sta":2,"guest-num_sta":1,"rx_bytes":1000,"tx_bytes":2.5e3,
+		 "system-stats":{"cpu":"7.5","mem":"55.1","uptime":"3600"}},
+		{"type":"usw","mac":"f0:9f:c2:00:00:02","name":"Core","model":"US8P60",
+		 "version":"6.5.59.14519","state":1,"uptime":86400,"num_sta":1,
+		 "rx_bytes":5000,"tx_bytes":6000,"system-stats":{},
+		 "port_table":[
+			{"port_idx":1,"name":"Port 1","up":true,"speed":1000,"rx_bytes":300,"tx_bytes":400},
+			{"port_idx":2,"name":"Port 2","up":false,"speed":0,"rx_bytes":0,"tx_bytes":0}]}]`
+	clientsReply = `[
+		{"mac":"11:11:11:11:11:11","is_wired":false,"essid":"home"},
+		{"mac":"22:22:22:22:22:22","is_wired":false,"essid":"home"},
+		{"mac":"33:33:33:33:33:33","is_wired":false,"essid":"guest"},
+		{"mac":"44:44:44:44:44:44","is_wired":true}]`
+)
+
+type fakeController struct {
+	prefix  string
+	logins  int
+	expired bool
+}
+
+func (f *fakeController) reply(w http.ResponseWriter, data string) {
+	fmt.Fprintf(w, `{"meta":{"rc":"ok"},"data":%s}`, data)
+}
+
+func (f *fakeController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	login := "/api/login"
+	if f.prefix != "" {
+		login = "/api/auth/login"
+	}
+	if r.URL.Path == login {
+		var creds map[string]string
+		json.NewDecoder(r.Body).Decode(&creds)
+		if r.Method != "POST" || creds["username"] != "admin" ||
+			creds["password"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"meta":{"rc":"error","msg":"api.err.Invalid"},"data":[]}`)
+			return
+		}
+		f.logins++
+		f.expired = false
+		http.SetCookie(w, &http.Cookie{Name: "unifises", Value: "session", Path: "/"})
+		f.reply(w, `[]`)
+		return
+	}
+
+	if c, err := r.Cookie("unifises"); err != nil || c.Value != "session" || f.expired {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"meta":{"rc":"error","msg":"api.err.LoginRequired"},"data":[]}`)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, f.prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, f.prefix) {
+	case "/api/self/sites":
+		f.reply(w, sitesReply)
+	case "/api/s/default/stat/device":
+		f.reply(w, devicesReply)
+	case "/api/s/default/stat/sta":
+		f.reply(w, clientsReply)
+	case "/api/s/x7k2mq/stat/device", "/api/s/x7k2mq/stat/sta":
+		f.reply(w, `[]`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newUnifi(u string) *UnifiController {
+	return &UnifiController{
+		URL:      u,
+		Username: "admin",
+		Password: "secret",
+	}
+}
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{})
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	var acc testutil.Accumulator
+	require.NoError(t, newUnifi(ts.URL).Gather(&acc))
+
+	site := map[string]string{"server": u.Host, "site": "default"}
+	acc.AssertContainsTaggedFields(t, "unifi_device",
+		map[string]interface{}{
+			"state":         int64(1),
+			"uptime":        int64(3600),
+			"clients":       int64(3),
+			"user_clients":  int64(2),
+			"guest_clients": int64(1),
+			"rx_bytes":      int64(1000),
+			"tx_bytes":      int64(2500),
+			"version":       "6.5.55.14277",
+			"cpu":           7.5,
+			"mem":           55.1,
+		},
+		map[string]string{"server": u.Host, "site": "default",
+			"mac": "f0:9f:c2:00:00:01", "type": "uap", "name": "Hall", "model": "U7PG2"})
+	acc.AssertContainsTaggedFields(t, "unifi_port",
+		map[string]interface{}{
+			"link_up":  true,
+			"speed":    int64(1000),
+			"rx_bytes": int64(300),
+			"tx_bytes": int64(400),
+		},
+		map[string]string{"server": u.Host, "site": "default",
+			"mac": "f0:9f:c2:00:00:02", "type": "usw", "name": "Core", "model": "US8P60",
+			"port": "1", "port_name": "Port 1"})
+	acc.AssertContainsTaggedFields(t, "unifi_ssid",
+		map[string]interface{}{"clients": 2},
+		map[string]string{"server": u.Host, "site": "default", "ssid": "home"})
+	acc.AssertContainsTaggedFields(t, "unifi_site",
+		map[string]interface{}{
+			"devices":          2,
+			"clients":          4,
+			"wireless_clients": 3,
+			"wired_clients":    1,
+		}, site)
+	acc.AssertContainsTaggedFields(t, "unifi_site",
+		map[string]interface{}{
+			"devices":          0,
+			"clients":          0,
+			"wireless_clients": 0,
+			"wired_clients":    0,
+		}, map[string]string{"server": u.Host, "site": "x7k2mq"})
+	acc.AssertContainsTaggedFields(t, "unifi_controller",
+		map[string]interface{}{"up": 1},
+		map[string]string{"server": u.Host})
+}
+
+func TestGatherUnifiOS(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{prefix: unifiOSPrefix})
+	defer ts.Close()
+
+	c := newUnifi(ts.URL)
+	c.UnifiOS = true
+	var acc testutil.Accumulator
+	require.NoError(t, c.Gather(&acc))
+	assert.True(t, acc.HasMeasurement("unifi_device"))
+}
+
+func TestSiteFilter(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{})
+	defer ts.Close()
+
+	c := newUnifi(ts.URL)
+	c.Sites = []string{"x7k2mq"}
+	var acc testutil.Accumulator
+	require.NoError(t, c.Gather(&acc))
+	assert.False(t, acc.HasMeasurement("unifi_device"))
+	// unifi_site and unifi_controller
+	assert.Equal(t, 2, len(acc.Metrics))
+}
+
+func TestRelogin(t *testing.T) {
+	f := &fakeController{}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	c := newUnifi(ts.URL)
+	var acc testutil.Accumulator
+	require.NoError(t, c.Gather(&acc))
+	assert.Equal(t, 1, f.logins)
+
+	f.expired = true
+	require.NoError(t, c.Gather(&acc))
+	assert.Equal(t, 2, f.logins)
+}
+
+func TestLoginFailure(t *testing.T) {
+	ts := httptest.NewServer(&fakeController{})
+	defer ts.Close()
+
+	c := newUnifi(ts.URL)
+	c.Password = "wrong"
+	var acc testutil.Accumulator
+	err := c.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "login")
+	require.Equal(t, 1, len(acc.Metrics))
+	assert.Equal(t, "unifi_controller", acc.Metrics[0].Measurement)
+	assert.Equal(t, 0, acc.Metrics[0].Fields["up"])
+}
@@ -0,0 +1,306 @@
+package upnp_igd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/availability"
+	"github.com/influxdata/telegraf/internal/devicehttp"
+	"github.com/influxdata/telegraf/internal/errchan"
+	"github.com/influxdata/telegraf/internal/rollover"
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/internal/upnp"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const (
+	// ssdpSearchTarget is the device type searched for during discovery.
+	ssdpSearchTarget = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+	// commonInterfaceService provides the WAN counters.
+	commonInterfaceService = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+)
+
+// ssdpAddr is the multicast address discovery requests are sent to.
+var ssdpAddr = "239.255.255.250:1900"
+
+type UPnPIGD struct {
+	Devices          []string
+	Discover         bool
+	DiscoveryTimeout internal.Duration
+	Timeout          internal.Duration
+
+	client    *devicehttp.Client
+	persister state.StatePersister
+	counters  *rollover.Cache
+
+	sync.Mutex
+	discovered []string
+	// controls holds the control URL by host name, as a gateway may change
+	// its port when it restarts
+	controls map[string]control
+}
+
+// control is the control URL of the WANCommonInterfaceConfig service found in
+// the device description at location.
+type control struct {
+	location string
+	url      string
+}
+
+var sampleConfig = `
+  ## Discover gateways on the local network with SSDP
+  discover = true
+  ## Time to wait for answers to discovery requests
+  # discovery_timeout = "2s"
+
+  ## URLs of the device descriptions of gateways to poll in addition to the
+  ## discovered ones, as found in the LOCATION header of their SSDP answers
+  # devices = ["http://192.168.1.1:5000/rootDesc.xml"]
+
+  ## Timeout for each request
+  # timeout = "5s"
+`
+
+func (u *UPnPIGD) SampleConfig() string {
+	return sampleConfig
+}
+
+func (u *UPnPIGD) Description() string {
+	return "Read WAN traffic counters from UPnP Internet Gateway Devices"
+}
+
+// SetStatePersister keeps the counter wrap offsets in the state directory of
+// the agent, so the totals continue across restarts.
+func (u *UPnPIGD) SetStatePersister(p state.StatePersister) {
+	u.persister = p
+}
+
+func (u *UPnPIGD) Gather(acc telegraf.Accumulator) error {
+	if u.client == nil {
+		client, err := devicehttp.NewClient(devicehttp.Config{
+			Timeout: u.Timeout.Duration,
+		})
+		if err != nil {
+			return err
+		}
+		u.client = client
+	}
+
+	if u.counters == nil {
+		var persister rollover.Persister
+		if u.persister != nil {
+			persister = state.Bind(u.persister, "counters")
+		}
+		counters := rollover.NewCache(persister)
+		// the counters of the gateway start over after a restart
+		counters.Threshold = rollover.RestartThreshold
+		if err := counters.Load(); err != nil {
+			return err
+		}
+		u.counters = counters
+		u.controls = make(map[string]control)
+	}
+
+	if u.Discover && len(u.discovered) == 0 {
+		discovered, err := discover(u.discoveryTimeout())
+		if err != nil {
+			return err
+		}
+		u.discovered = discovered
+	}
+
+	discovered := u.discovered
+	devices := append([]string(nil), u.Devices...)
+	for _, location := range discovered {
+		if !contains(devices, location) {
+			devices = append(devices, location)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errChan := errchan.New(len(devices) + 1)
+	wg.Add(len(devices))
+	for _, location := range devices {
+		go func(location string) {
+			defer wg.Done()
+			errChan.C <- u.gatherDevice(location, acc)
+		}(location)
+	}
+
+	wg.Wait()
+	errChan.C <- u.counters.Save()
+	return errChan.Error()
+}
+
+func (u *UPnPIGD) discoveryTimeout() time.Duration {
+	if u.DiscoveryTimeout.Duration == 0 {
+		return 2 * time.Second
+	}
+	return u.DiscoveryTimeout.Duration
+}
+
+func (u *UPnPIGD) gatherDevice(location string, acc telegraf.Accumulator) error {
+	loc, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("unable to parse address '%s': %s", location, err)
+	}
+	host := hostname(loc)
+
+	err = u.gatherCounters(host, location, acc)
+	if err != nil {
+		// the gateway may have moved, describe it and search again next time
+		u.Lock()
+		delete(u.controls, host)
+		if contains(u.discovered, location) {
+			u.discovered = nil
+		}
+		u.Unlock()
+	}
+	availability.Add(acc, "upnp_igd", map[string]string{"server": host}, err)
+	return err
+}
+
+func (u *UPnPIGD) gatherCounters(host, location string, acc telegraf.Accumulator) error {
+	control, err := u.controlURL(host, location)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, c := range upnp.CounterActions {
+		values, err := upnp.Call(u.client, control, commonInterfaceService, c.Action)
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseUint(values[c.Argument], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s from %s: %q", c.Argument, location, values[c.Argument])
+		}
+		fields[c.Field] = u.counters.Compensate(host, c.Field, v)
+	}
+
+	link, err := upnp.Call(u.client, control, commonInterfaceService, "GetCommonLinkProperties")
+	if err == nil {
+		if v, err := strconv.ParseInt(link["NewLayer1UpstreamMaxBitRate"], 10, 64); err == nil {
+			fields["upstream_max_bitrate"] = v
+		}
+		if v, err := strconv.ParseInt(link["NewLayer1DownstreamMaxBitRate"], 10, 64); err == nil {
+			fields["downstream_max_bitrate"] = v
+		}
+		if status := link["NewPhysicalLinkStatus"]; status != "" {
+			fields["link_up"] = status == "Up"
+		}
+	}
+
+	acc.AddFields("upnp_igd", fields, map[string]string{"server": host})
+	return nil
+}
+
+// controlURL returns the control URL of the WANCommonInterfaceConfig service
+// of host described at location.
+func (u *UPnPIGD) controlURL(host, location string) (string, error) {
+	u.Lock()
+	c, ok := u.controls[host]
+	u.Unlock()
+	if ok && c.location == location {
+		return c.url, nil
+	}
+
+	b, err := u.client.Get(location)
+	if err != nil {
+		return "", err
+	}
+	services, err := upnp.ControlURLs(b, location)
+	if err != nil {
+		return "", err
+	}
+	controlURL, ok := services[commonInterfaceService]
+	if !ok {
+		return "", fmt.Errorf("%s has no %s service", location, commonInterfaceService)
+	}
+	c = control{location: location, url: controlURL}
+
+	u.Lock()
+	u.controls[host] = c
+	u.Unlock()
+	return c.url, nil
+}
+
+// discover sends an SSDP search for Internet Gateway Devices and returns the
+// description URLs of those that answer within timeout.
+func discover(timeout time.Duration) ([]string, error) {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + ssdpSearchTarget + "\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), addr); err != nil {
+		return nil, fmt.Errorf("unable to send SSDP search: %s", err)
+	}
+
+	var locations []string
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// the deadline ends the search
+			break
+		}
+		resp, err := http.ReadResponse(
+			bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if location != "" && !contains(locations, location) {
+			locations = append(locations, location)
+		}
+	}
+	return locations, nil
+}
+
+// hostname returns the host of u without the port.
+func hostname(u *url.URL) string {
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		return host
+	}
+	return strings.Trim(u.Host, "[]")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	inputs.Add("upnp_igd", func() telegraf.Input {
+		return &UPnPIGD{Discover: true}
+	})
+}
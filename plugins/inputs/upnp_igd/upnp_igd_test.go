@@ -0,0 +1,232 @@
+package upnp_igd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rootDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <serviceList>
+          <service>
+            <serviceType>urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1</serviceType>
+            <controlURL>/ctl/CmnIfCfg</controlURL>
+          </service>
+        </serviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const responseTemplate = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+<s:Body><u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1">%s</u:%sResponse></s:Body>
+</s:Envelope>`
+
+// fakeGateway answers the description and SOAP requests of an IGD.
+type fakeGateway struct {
+	sync.Mutex
+	values map[string]string
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/rootDesc.xml":
+		fmt.Fprint(w, rootDesc)
+	case "/ctl/CmnIfCfg":
+		b, _ := ioutil.ReadAll(r.Body)
+		soapAction := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+		action := soapAction[strings.Index(soapAction, "#")+1:]
+		if !strings.Contains(string(b), "<u:"+action) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		g.Lock()
+		defer g.Unlock()
+		var body string
+		switch action {
+		case "GetCommonLinkProperties":
+			body = "<NewWANAccessType>DSL</NewWANAccessType>" +
+				"<NewLayer1UpstreamMaxBitRate>40000000</NewLayer1UpstreamMaxBitRate>" +
+				"<NewLayer1DownstreamMaxBitRate>100000000</NewLayer1DownstreamMaxBitRate>" +
+				"<NewPhysicalLinkStatus>Up</NewPhysicalLinkStatus>"
+		default:
+			element := "New" + strings.TrimPrefix(action, "Get")
+			body = fmt.Sprintf("<%s>%s</%s>", element, g.values[element], element)
+		}
+		fmt.Fprintf(w, responseTemplate, action, body, action)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (g *fakeGateway) set(element, value string) {
+	g.Lock()
+	g.values[element] = value
+	g.Unlock()
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{values: map[string]string{
+		"NewTotalBytesSent":       "4294967000",
+		"NewTotalBytesReceived":   "1000",
+		"NewTotalPacketsSent":     "10",
+		"NewTotalPacketsReceived": "20",
+	}}
+}
+
+func TestGather(t *testing.T) {
+	gw := newFakeGateway()
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+
+	u := &UPnPIGD{Devices: []string{ts.URL + "/rootDesc.xml"}}
+	host := "127.0.0.1"
+
+	var acc testutil.Accumulator
+	require.NoError(t, u.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "upnp_igd",
+		map[string]interface{}{
+			"bytes_sent":             uint64(4294967000),
+			"bytes_received":         uint64(1000),
+			"packets_sent":           uint64(10),
+			"packets_received":       uint64(20),
+			"upstream_max_bitrate":   int64(40000000),
+			"downstream_max_bitrate": int64(100000000),
+			"link_up":                true,
+		},
+		map[string]string{"server": host})
+
+	// the counters and the up point share their tags
+	var up []interface{}
+	for _, m := range acc.Metrics {
+		if m.Fields["up"] != nil {
+			up = append(up, m.Fields["up"])
+		}
+	}
+	assert.Equal(t, []interface{}{1}, up)
+
+	// the sent bytes wrap, the received packets were reset by a restart
+	gw.set("NewTotalBytesSent", "100")
+	gw.set("NewTotalBytesReceived", "2000")
+	gw.set("NewTotalPacketsReceived", "5")
+
+	acc = testutil.Accumulator{}
+	require.NoError(t, u.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "upnp_igd",
+		map[string]interface{}{
+			"bytes_sent":             uint64(1<<32 + 100),
+			"bytes_received":         uint64(2000),
+			"packets_sent":           uint64(10),
+			"packets_received":       uint64(5),
+			"upstream_max_bitrate":   int64(40000000),
+			"downstream_max_bitrate": int64(100000000),
+			"link_up":                true,
+		},
+		map[string]string{"server": host})
+}
+
+func TestGatherPortChange(t *testing.T) {
+	gw := newFakeGateway()
+	ts := httptest.NewServer(gw)
+
+	u := &UPnPIGD{Devices: []string{ts.URL + "/rootDesc.xml"}}
+	var acc testutil.Accumulator
+	require.NoError(t, u.Gather(&acc))
+
+	// the gateway restarts on another port
+	ts.Close()
+	assert.Error(t, u.Gather(&acc))
+	assert.Equal(t, 0, len(u.controls))
+
+	ts = httptest.NewServer(gw)
+	defer ts.Close()
+	u.Devices = []string{ts.URL + "/rootDesc.xml"}
+	gw.set("NewTotalBytesSent", "100")
+
+	// the counters continue from those seen on the previous port
+	acc = testutil.Accumulator{}
+	require.NoError(t, u.Gather(&acc))
+	m, ok := acc.Get("upnp_igd")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1<<32+100), m.Fields["bytes_sent"])
+	assert.Equal(t, "127.0.0.1", m.Tags["server"])
+	assert.Equal(t, map[string]control{
+		"127.0.0.1": {ts.URL + "/rootDesc.xml", ts.URL + "/ctl/CmnIfCfg"},
+	}, u.controls)
+}
+
+func TestGatherMissingService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<root><device><serviceList></serviceList></device></root>`)
+	}))
+	defer ts.Close()
+
+	u := &UPnPIGD{Devices: []string{ts.URL + "/rootDesc.xml"}}
+	var acc testutil.Accumulator
+	assert.Error(t, u.Gather(&acc))
+	assert.Equal(t, 1, len(acc.Metrics))
+	acc.AssertContainsTaggedFields(t, "upnp_igd",
+		map[string]interface{}{"up": 0},
+		map[string]string{"server": "127.0.0.1", "reason": "error"})
+}
+
+func TestDiscover(t *testing.T) {
+	ts := httptest.NewServer(newFakeGateway())
+	defer ts.Close()
+
+	// answer searches like a gateway would, from a unicast address
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !strings.Contains(string(buf[:n]), "ST: "+ssdpSearchTarget) {
+				continue
+			}
+			conn.WriteTo([]byte("HTTP/1.1 200 OK\r\n"+
+				"CACHE-CONTROL: max-age=120\r\n"+
+				"ST: "+ssdpSearchTarget+"\r\n"+
+				"LOCATION: "+ts.URL+"/rootDesc.xml\r\n\r\n"), addr)
+		}
+	}()
+
+	defer func(addr string) { ssdpAddr = addr }(ssdpAddr)
+	ssdpAddr = conn.LocalAddr().String()
+
+	u := &UPnPIGD{
+		Discover:         true,
+		DiscoveryTimeout: internal.Duration{Duration: 200 * time.Millisecond},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, u.Gather(&acc))
+	assert.Equal(t, []string{ts.URL + "/rootDesc.xml"}, u.discovered)
+
+	m, ok := acc.Get("upnp_igd")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1000), m.Fields["bytes_received"])
+	assert.Equal(t, "127.0.0.1", m.Tags["server"])
+}
@@ -26,14 +26,6 @@ type Kafka struct {
 	// MaxRetry Tag
 	MaxRetry int
 
-	// Legacy SSL config options
-	// TLS client certificate
-	Certificate string
-	// TLS client key
-	Key string
-	// TLS certificate authority
-	CA string
-
 	// Path to CA file
 	SSLCA string `toml:"ssl_ca"`
 	// Path to host cert file
@@ -110,13 +102,6 @@ func (k *Kafka) Connect() error {
 	config.Producer.Compression = sarama.CompressionCodec(k.CompressionCodec)
 	config.Producer.Retry.Max = k.MaxRetry
 
-	// Legacy support ssl config
-	if k.Certificate != "" {
-		k.SSLCert = k.Certificate
-		k.SSLCA = k.CA
-		k.SSLKey = k.Key
-	}
-
 	tlsConfig, err := internal.GetTLSConfig(
 		k.SSLCert, k.SSLKey, k.SSLCA, k.InsecureSkipVerify)
 	if err != nil {
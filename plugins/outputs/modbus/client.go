@@ -0,0 +1,175 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Function codes of the write requests.
+const (
+	fcWriteSingleCoil        = 0x05
+	fcWriteSingleRegister    = 0x06
+	fcWriteMultipleRegisters = 0x10
+)
+
+// maxWriteRegisters is the largest number of registers a single Write
+// Multiple Registers request may carry.
+const maxWriteRegisters = 123
+
+// exceptionNames names the exception codes of the Modbus specification.
+var exceptionNames = map[byte]string{
+	1:  "illegal function",
+	2:  "illegal data address",
+	3:  "illegal data value",
+	4:  "server device failure",
+	5:  "acknowledge",
+	6:  "server device busy",
+	8:  "memory parity error",
+	10: "gateway path unavailable",
+	11: "gateway target device failed to respond",
+}
+
+// exceptionError is returned for requests the device answered with an
+// exception.
+type exceptionError struct {
+	Function byte
+	Code     byte
+}
+
+func (e *exceptionError) Error() string {
+	name, ok := exceptionNames[e.Code]
+	if !ok {
+		name = "unknown exception"
+	}
+	return fmt.Sprintf("function %d: exception %d (%s)", e.Function, e.Code, name)
+}
+
+// temporary reports whether the device may accept the request later.
+func (e *exceptionError) temporary() bool {
+	return e.Code == 5 || e.Code == 6
+}
+
+// client sends requests to a device over Modbus/TCP. It is not safe for
+// concurrent use.
+type client struct {
+	conn        net.Conn
+	unitID      byte
+	timeout     time.Duration
+	transaction uint16
+}
+
+// dial connects to address within connectTimeout. Every request must be
+// answered within requestTimeout.
+func dial(address string, unitID byte, connectTimeout, requestTimeout time.Duration) (*client, error) {
+	conn, err := net.DialTimeout("tcp", address, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &client{conn: conn, unitID: unitID, timeout: requestTimeout}, nil
+}
+
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
+// writeSingleCoil switches a coil on or off.
+func (c *client) writeSingleCoil(address uint16, on bool) error {
+	var value uint16
+	if on {
+		value = 0xff00
+	}
+	pdu := make([]byte, 5)
+	pdu[0] = fcWriteSingleCoil
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], value)
+	return c.write(pdu)
+}
+
+// writeSingleRegister sets a holding register.
+func (c *client) writeSingleRegister(address, value uint16) error {
+	pdu := make([]byte, 5)
+	pdu[0] = fcWriteSingleRegister
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], value)
+	return c.write(pdu)
+}
+
+// writeMultipleRegisters sets consecutive holding registers starting at
+// address.
+func (c *client) writeMultipleRegisters(address uint16, values []uint16) error {
+	if len(values) == 0 || len(values) > maxWriteRegisters {
+		return fmt.Errorf("cannot write %d registers in one request", len(values))
+	}
+	pdu := make([]byte, 6+2*len(values))
+	pdu[0] = fcWriteMultipleRegisters
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], uint16(len(values)))
+	pdu[5] = byte(2 * len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(pdu[6+2*i:], v)
+	}
+
+	// the response echoes the address and the quantity
+	return c.request(pdu, pdu[:5])
+}
+
+// write sends a request whose response echoes the request.
+func (c *client) write(pdu []byte) error {
+	return c.request(pdu, pdu)
+}
+
+// request sends a PDU in a Modbus/TCP frame and checks that the response
+// PDU equals expected.
+func (c *client) request(pdu, expected []byte) error {
+	c.transaction++
+	frame := make([]byte, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], c.transaction)
+	// protocol identifier 0 is Modbus
+	binary.BigEndian.PutUint16(frame[4:], uint16(1+len(pdu)))
+	frame[6] = c.unitID
+	copy(frame[7:], pdu)
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		return err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if len(resp) == 2 && resp[0] == pdu[0]|0x80 {
+		return &exceptionError{Function: pdu[0], Code: resp[1]}
+	}
+	if string(resp) != string(expected) {
+		return fmt.Errorf("function %d: unexpected response % x", pdu[0], resp)
+	}
+	return nil
+}
+
+// readResponse reads frames until the one answering the last request, and
+// returns its PDU. Late answers to earlier, timed out requests are skipped.
+func (c *client) readResponse() ([]byte, error) {
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > 254 {
+			return nil, fmt.Errorf("invalid Modbus/TCP header % x", header)
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, pdu); err != nil {
+			return nil, err
+		}
+		if binary.BigEndian.Uint16(header) == c.transaction {
+			return pdu, nil
+		}
+	}
+}
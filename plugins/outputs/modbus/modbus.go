@@ -0,0 +1,419 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+// defaultPort is the registered Modbus/TCP port.
+const defaultPort = "502"
+
+// defaultTimeout applies to connecting and to requests unless configured.
+const defaultTimeout = 5 * time.Second
+
+// registerCount is the number of registers taken by a value of each data
+// type.
+var registerCount = map[string]int{
+	"INT16":   1,
+	"UINT16":  1,
+	"INT32":   2,
+	"UINT32":  2,
+	"FLOAT32": 2,
+	"INT64":   4,
+	"UINT64":  4,
+	"FLOAT64": 4,
+}
+
+type Modbus struct {
+	Controller string
+	SlaveID    int `toml:"slave_id"`
+	ByteOrder  string
+
+	ConnectTimeout internal.Duration
+	RequestTimeout internal.Duration
+
+	Coils     []coil     `toml:"coil"`
+	Registers []register `toml:"register"`
+
+	address string
+	client  *client
+}
+
+// coil maps a field to a coil.
+type coil struct {
+	Measurement string
+	Field       string
+	Address     int
+}
+
+// register maps a field to one or more consecutive holding registers.
+type register struct {
+	Measurement string
+	Field       string
+	Address     int
+	DataType    string
+	ByteOrder   string
+	Scale       float64
+}
+
+var sampleConfig = `
+  ## Modbus/TCP address of the device or gateway
+  controller = "tcp://192.168.1.10:502"
+
+  ## Unit identifier of the device, selecting the device behind gateways to
+  ## serial buses
+  slave_id = 1
+
+  ## Timeout for establishing the connection, and for every request
+  # connect_timeout = "5s"
+  # request_timeout = "5s"
+
+  ## Order of the bytes of values spanning several registers, "ABCD" (big
+  ## endian), "CDAB" (words swapped), "BADC" (bytes swapped) or "DCBA"
+  ## (little endian)
+  # byte_order = "ABCD"
+
+  ## Coils switched on or off with function code 5 from boolean or numeric
+  ## fields, on when the value is true or not zero
+  # [[outputs.modbus.coil]]
+  #   measurement = "pump"
+  #   field = "enabled"
+  #   address = 0
+
+  ## Holding registers written with function code 6, or 16 for data types
+  ## spanning several registers
+  [[outputs.modbus.register]]
+    measurement = "setpoint"
+    field = "temperature"
+    address = 100
+    ## INT16, UINT16, INT32, UINT32, INT64, UINT64, FLOAT32 or FLOAT64
+    data_type = "INT16"
+    ## Factor the value is multiplied with before it is encoded, e.g. 10 for
+    ## a register holding tenths of a degree
+    # scale = 1.0
+    ## Overrides the byte order of the plugin
+    # byte_order = "ABCD"
+`
+
+func (m *Modbus) SampleConfig() string {
+	return sampleConfig
+}
+
+func (m *Modbus) Description() string {
+	return "Write metric fields to the coils and holding registers of Modbus/TCP devices"
+}
+
+// Connect checks the configuration and connects to the device. A device
+// that cannot be reached yet is connected to on the next write.
+func (m *Modbus) Connect() error {
+	u, err := url.Parse(m.Controller)
+	if err != nil || u.Scheme != "tcp" || u.Host == "" {
+		return fmt.Errorf("invalid controller %q, expected tcp://host:port", m.Controller)
+	}
+	m.address = u.Host
+	if _, _, err := net.SplitHostPort(m.address); err != nil {
+		m.address = net.JoinHostPort(m.address, defaultPort)
+	}
+	if m.SlaveID < 0 || m.SlaveID > 255 {
+		return fmt.Errorf("invalid slave_id %d", m.SlaveID)
+	}
+	m.ByteOrder = strings.ToUpper(m.ByteOrder)
+	if err := checkByteOrder(m.ByteOrder); err != nil {
+		return err
+	}
+
+	for _, c := range m.Coils {
+		if c.Address < 0 || c.Address > math.MaxUint16 {
+			return fmt.Errorf("coil %s.%s: invalid address %d", c.Measurement, c.Field, c.Address)
+		}
+	}
+	for i := range m.Registers {
+		r := &m.Registers[i]
+		r.ByteOrder = strings.ToUpper(r.ByteOrder)
+		n, ok := registerCount[strings.ToUpper(r.DataType)]
+		if !ok {
+			return fmt.Errorf("register %s.%s: invalid data_type %q", r.Measurement, r.Field, r.DataType)
+		}
+		if r.Address < 0 || r.Address+n-1 > math.MaxUint16 {
+			return fmt.Errorf("register %s.%s: invalid address %d", r.Measurement, r.Field, r.Address)
+		}
+		if err := checkByteOrder(r.ByteOrder); err != nil {
+			return fmt.Errorf("register %s.%s: %s", r.Measurement, r.Field, err)
+		}
+	}
+
+	if err := m.connect(); err != nil {
+		log.Printf("modbus: unable to connect to %s, retrying on next write: %s", m.address, err)
+	}
+	return nil
+}
+
+func (m *Modbus) connect() error {
+	connectTimeout := m.ConnectTimeout.Duration
+	if connectTimeout == 0 {
+		connectTimeout = defaultTimeout
+	}
+	requestTimeout := m.RequestTimeout.Duration
+	if requestTimeout == 0 {
+		requestTimeout = defaultTimeout
+	}
+	c, err := dial(m.address, byte(m.SlaveID), connectTimeout, requestTimeout)
+	if err != nil {
+		return err
+	}
+	m.client = c
+	return nil
+}
+
+func (m *Modbus) Close() error {
+	if m.client == nil {
+		return nil
+	}
+	err := m.client.Close()
+	m.client = nil
+	return err
+}
+
+// Write writes the latest value of every mapped field in the batch. Values
+// that cannot be encoded, and requests the device rejects, are logged and
+// dropped; connection errors fail the write so the batch is retried.
+func (m *Modbus) Write(metrics []telegraf.Metric) error {
+	coilValues := make(map[int]interface{})
+	registerValues := make(map[int]interface{})
+	for _, metric := range metrics {
+		fields := metric.Fields()
+		for i, c := range m.Coils {
+			if v, ok := fields[c.Field]; ok && metric.Name() == c.Measurement {
+				coilValues[i] = v
+			}
+		}
+		for i, r := range m.Registers {
+			if v, ok := fields[r.Field]; ok && metric.Name() == r.Measurement {
+				registerValues[i] = v
+			}
+		}
+	}
+	if len(coilValues) == 0 && len(registerValues) == 0 {
+		return nil
+	}
+
+	if m.client == nil {
+		if err := m.connect(); err != nil {
+			return fmt.Errorf("unable to connect to %s: %s", m.address, err)
+		}
+	}
+
+	for i, c := range m.Coils {
+		v, ok := coilValues[i]
+		if !ok {
+			continue
+		}
+		on, err := coilValue(v)
+		if err != nil {
+			log.Printf("modbus: coil %s.%s: %s", c.Measurement, c.Field, err)
+			continue
+		}
+		err = m.client.writeSingleCoil(uint16(c.Address), on)
+		if err := m.check(fmt.Sprintf("coil %d", c.Address), err); err != nil {
+			return err
+		}
+	}
+
+	for i, r := range m.Registers {
+		v, ok := registerValues[i]
+		if !ok {
+			continue
+		}
+		byteOrder := r.ByteOrder
+		if byteOrder == "" {
+			byteOrder = m.ByteOrder
+		}
+		values, err := encode(v, strings.ToUpper(r.DataType), byteOrder, r.Scale)
+		if err != nil {
+			log.Printf("modbus: register %s.%s: %s", r.Measurement, r.Field, err)
+			continue
+		}
+		if len(values) == 1 {
+			err = m.client.writeSingleRegister(uint16(r.Address), values[0])
+		} else {
+			err = m.client.writeMultipleRegisters(uint16(r.Address), values)
+		}
+		if err := m.check(fmt.Sprintf("register %d", r.Address), err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check handles the error of a request. Exceptions are logged, unless the
+// device is busy; other errors drop the connection.
+func (m *Modbus) check(target string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*exceptionError); ok && !e.temporary() {
+		log.Printf("modbus: writing %s of %s failed: %s", target, m.address, err)
+		return nil
+	}
+	if _, ok := err.(*exceptionError); !ok {
+		m.Close()
+	}
+	return fmt.Errorf("writing %s of %s failed: %s", target, m.address, err)
+}
+
+func checkByteOrder(order string) error {
+	switch order {
+	case "", "ABCD", "CDAB", "BADC", "DCBA":
+		return nil
+	}
+	return fmt.Errorf("invalid byte_order %q", order)
+}
+
+// coilValue converts a field value to the state of a coil.
+func coilValue(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case uint64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	}
+	return false, fmt.Errorf("cannot convert %T to a coil state", v)
+}
+
+// encode converts a field value to the registers holding it, in the order
+// they are written.
+func encode(v interface{}, dataType, byteOrder string, scale float64) ([]uint16, error) {
+	if scale != 0 && scale != 1 {
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %T to %s", v, dataType)
+		}
+		v = f * scale
+	}
+
+	bits := uint(16 * registerCount[dataType])
+	b := make([]byte, bits/8)
+	switch dataType {
+	case "FLOAT32", "FLOAT64":
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %T to %s", v, dataType)
+		}
+		if dataType == "FLOAT32" {
+			putUint(b, uint64(math.Float32bits(float32(f))))
+		} else {
+			putUint(b, math.Float64bits(f))
+		}
+	case "INT16", "INT32", "INT64":
+		var i int64
+		switch v := v.(type) {
+		case int64:
+			i = v
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil, fmt.Errorf("%d is out of range of %s", v, dataType)
+			}
+			i = int64(v)
+		default:
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("cannot convert %T to %s", v, dataType)
+			}
+			f = math.Floor(f + 0.5)
+			if !(f >= -math.Pow(2, 63) && f < math.Pow(2, 63)) {
+				return nil, fmt.Errorf("%v is out of range of %s", v, dataType)
+			}
+			i = int64(f)
+		}
+		if bits < 64 && (i < -1<<(bits-1) || i >= 1<<(bits-1)) {
+			return nil, fmt.Errorf("%v is out of range of %s", v, dataType)
+		}
+		putUint(b, uint64(i))
+	default:
+		var u uint64
+		switch v := v.(type) {
+		case int64:
+			if v < 0 {
+				return nil, fmt.Errorf("%d is out of range of %s", v, dataType)
+			}
+			u = uint64(v)
+		case uint64:
+			u = v
+		default:
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("cannot convert %T to %s", v, dataType)
+			}
+			f = math.Floor(f + 0.5)
+			if !(f >= 0 && f < math.Pow(2, 64)) {
+				return nil, fmt.Errorf("%v is out of range of %s", v, dataType)
+			}
+			u = uint64(f)
+		}
+		if bits < 64 && u >= 1<<bits {
+			return nil, fmt.Errorf("%v is out of range of %s", v, dataType)
+		}
+		putUint(b, u)
+	}
+
+	// b holds the value in ABCD order
+	swapBytes := byteOrder == "BADC" || byteOrder == "DCBA"
+	swapWords := byteOrder == "CDAB" || byteOrder == "DCBA"
+	values := make([]uint16, len(b)/2)
+	for i := range values {
+		w := i
+		if swapWords {
+			w = len(values) - 1 - i
+		}
+		hi, lo := b[2*w], b[2*w+1]
+		if swapBytes {
+			hi, lo = lo, hi
+		}
+		values[i] = uint16(hi)<<8 | uint16(lo)
+	}
+	return values, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// putUint stores the low len(b) bytes of v in b, big endian.
+func putUint(b []byte, v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+func init() {
+	outputs.Add("modbus", func() telegraf.Output {
+		return &Modbus{SlaveID: 1}
+	})
+}
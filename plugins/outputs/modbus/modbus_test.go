@@ -0,0 +1,311 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDevice is a Modbus/TCP server keeping coils and holding registers.
+type fakeDevice struct {
+	listener net.Listener
+
+	sync.Mutex
+	coils     map[uint16]bool
+	registers map[uint16]uint16
+	// exceptions holds the exception code answered for writes to an address
+	exceptions map[uint16]byte
+	units      []byte
+}
+
+func newFakeDevice(t *testing.T) *fakeDevice {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	d := &fakeDevice{
+		listener:   l,
+		coils:      make(map[uint16]bool),
+		registers:  make(map[uint16]uint16),
+		exceptions: make(map[uint16]byte),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDevice) addr() string {
+	return "tcp://" + d.listener.Addr().String()
+}
+
+func (d *fakeDevice) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		resp := d.handle(header[6], pdu)
+		frame := make([]byte, 7, 7+len(resp))
+		copy(frame, header)
+		binary.BigEndian.PutUint16(frame[4:], uint16(1+len(resp)))
+		if _, err := conn.Write(append(frame, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func (d *fakeDevice) handle(unit byte, pdu []byte) []byte {
+	d.Lock()
+	defer d.Unlock()
+	d.units = append(d.units, unit)
+
+	address := binary.BigEndian.Uint16(pdu[1:])
+	if code, ok := d.exceptions[address]; ok {
+		return []byte{pdu[0] | 0x80, code}
+	}
+	switch pdu[0] {
+	case fcWriteSingleCoil:
+		d.coils[address] = binary.BigEndian.Uint16(pdu[3:]) == 0xff00
+		return pdu
+	case fcWriteSingleRegister:
+		d.registers[address] = binary.BigEndian.Uint16(pdu[3:])
+		return pdu
+	case fcWriteMultipleRegisters:
+		n := binary.BigEndian.Uint16(pdu[3:])
+		for i := uint16(0); i < n; i++ {
+			d.registers[address+i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+		}
+		return pdu[:5]
+	}
+	return []byte{pdu[0] | 0x80, 1}
+}
+
+func testMetric(name string, fields map[string]interface{}) telegraf.Metric {
+	m, _ := telegraf.NewMetric(name, map[string]string{}, fields,
+		time.Date(2016, time.October, 16, 10, 0, 0, 0, time.UTC))
+	return m
+}
+
+func TestWrite(t *testing.T) {
+	d := newFakeDevice(t)
+	defer d.listener.Close()
+
+	m := &Modbus{
+		Controller: d.addr(),
+		SlaveID:    7,
+		// byte orders are not case sensitive
+		ByteOrder: "abcd",
+		Coils: []coil{
+			{Measurement: "pump", Field: "enabled", Address: 3},
+			{Measurement: "pump", Field: "speed", Address: 4},
+		},
+		Registers: []register{
+			{Measurement: "setpoint", Field: "temperature", Address: 100, DataType: "INT16", Scale: 10},
+			{Measurement: "setpoint", Field: "flow", Address: 200, DataType: "FLOAT32", ByteOrder: "cdab"},
+			{Measurement: "setpoint", Field: "total", Address: 300, DataType: "uint64"},
+			{Measurement: "other", Field: "temperature", Address: 400, DataType: "INT16"},
+		},
+	}
+	require.NoError(t, m.Connect())
+	defer m.Close()
+
+	require.NoError(t, m.Write([]telegraf.Metric{
+		testMetric("pump", map[string]interface{}{"enabled": true, "speed": int64(0)}),
+		testMetric("setpoint", map[string]interface{}{"temperature": -1.5, "flow": 12.5}),
+		// the latest value in the batch wins
+		testMetric("setpoint", map[string]interface{}{"temperature": 21.5, "total": uint64(0x0102030405060708)}),
+	}))
+
+	d.Lock()
+	defer d.Unlock()
+	assert.Equal(t, map[uint16]bool{3: true, 4: false}, d.coils)
+	flow := math.Float32bits(12.5)
+	assert.Equal(t, map[uint16]uint16{
+		100: 215,
+		200: uint16(flow), 201: uint16(flow >> 16),
+		300: 0x0102, 301: 0x0304, 302: 0x0506, 303: 0x0708,
+	}, d.registers)
+	for _, unit := range d.units {
+		assert.Equal(t, byte(7), unit)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range []struct {
+		value     interface{}
+		dataType  string
+		byteOrder string
+		scale     float64
+		registers []uint16
+	}{
+		{int64(-2), "INT16", "", 0, []uint16{0xfffe}},
+		{int64(0x1234), "UINT16", "BADC", 0, []uint16{0x3412}},
+		{int64(0x01020304), "INT32", "ABCD", 0, []uint16{0x0102, 0x0304}},
+		{int64(0x01020304), "UINT32", "CDAB", 0, []uint16{0x0304, 0x0102}},
+		{int64(0x01020304), "UINT32", "BADC", 0, []uint16{0x0201, 0x0403}},
+		{int64(0x01020304), "UINT32", "DCBA", 0, []uint16{0x0403, 0x0201}},
+		{int64(math.MaxInt64), "INT64", "", 0, []uint16{0x7fff, 0xffff, 0xffff, 0xffff}},
+		{int64(-1), "INT64", "DCBA", 0, []uint16{0xffff, 0xffff, 0xffff, 0xffff}},
+		{1.0, "FLOAT64", "", 0, []uint16{0x3ff0, 0, 0, 0}},
+		{true, "UINT16", "", 0, []uint16{1}},
+		{2.46, "UINT16", "", 100, []uint16{246}},
+		{int64(3), "INT16", "", 0.5, []uint16{2}},
+	} {
+		registers, err := encode(tt.value, tt.dataType, tt.byteOrder, tt.scale)
+		require.NoError(t, err, tt.dataType)
+		assert.Equal(t, tt.registers, registers, tt.dataType)
+	}
+
+	for _, tt := range []struct {
+		value    interface{}
+		dataType string
+	}{
+		{int64(32768), "INT16"},
+		{32767.6, "INT16"},
+		{int64(-1), "UINT32"},
+		{uint64(math.MaxUint64), "INT64"},
+		{math.NaN(), "INT32"},
+		{"on", "INT16"},
+	} {
+		_, err := encode(tt.value, tt.dataType, "", 0)
+		assert.Error(t, err, tt.dataType)
+	}
+}
+
+func TestWriteException(t *testing.T) {
+	d := newFakeDevice(t)
+	defer d.listener.Close()
+	d.exceptions[100] = 2
+	d.exceptions[101] = 6
+
+	m := &Modbus{
+		Controller: d.addr(),
+		Registers: []register{
+			{Measurement: "setpoint", Field: "a", Address: 100, DataType: "INT16"},
+			{Measurement: "setpoint", Field: "b", Address: 101, DataType: "INT16"},
+		},
+	}
+	require.NoError(t, m.Connect())
+	defer m.Close()
+
+	// illegal data address is dropped
+	require.NoError(t, m.Write([]telegraf.Metric{
+		testMetric("setpoint", map[string]interface{}{"a": int64(1)}),
+	}))
+
+	// a busy device is retried with the batch
+	err := m.Write([]telegraf.Metric{
+		testMetric("setpoint", map[string]interface{}{"b": int64(1)}),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server device busy")
+	assert.NotNil(t, m.client)
+}
+
+func TestReconnect(t *testing.T) {
+	// nothing listens yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	m := &Modbus{
+		Controller:     "tcp://" + addr,
+		ConnectTimeout: internal.Duration{Duration: time.Second},
+		Coils:          []coil{{Measurement: "pump", Field: "enabled", Address: 1}},
+	}
+	require.NoError(t, m.Connect())
+	metrics := []telegraf.Metric{
+		testMetric("pump", map[string]interface{}{"enabled": true}),
+	}
+	assert.Error(t, m.Write(metrics))
+
+	d := &fakeDevice{coils: make(map[uint16]bool), exceptions: make(map[uint16]byte)}
+	d.listener, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer d.listener.Close()
+	go func() {
+		for {
+			conn, err := d.listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	require.NoError(t, m.Write(metrics))
+	d.Lock()
+	assert.True(t, d.coils[1])
+	d.Unlock()
+	m.Close()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// accepts connections but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	m := &Modbus{
+		Controller:     "tcp://" + l.Addr().String(),
+		ConnectTimeout: internal.Duration{Duration: 5 * time.Second},
+		RequestTimeout: internal.Duration{Duration: 50 * time.Millisecond},
+		Coils:          []coil{{Measurement: "pump", Field: "enabled", Address: 1}},
+	}
+	require.NoError(t, m.Connect())
+	require.NotNil(t, m.client)
+
+	start := time.Now()
+	err = m.Write([]telegraf.Metric{
+		testMetric("pump", map[string]interface{}{"enabled": true}),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout")
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Nil(t, m.client)
+}
+
+func TestConnectInvalidConfig(t *testing.T) {
+	for _, m := range []*Modbus{
+		{Controller: "192.168.1.10:502"},
+		{Controller: "udp://192.168.1.10:502"},
+		{Controller: "tcp://192.168.1.10", SlaveID: 256},
+		{Controller: "tcp://192.168.1.10", ByteOrder: "BA"},
+		{Controller: "tcp://192.168.1.10", Registers: []register{{DataType: "INT16", ByteOrder: "abdc"}}},
+		{Controller: "tcp://192.168.1.10", Registers: []register{{DataType: "INT8"}}},
+		{Controller: "tcp://192.168.1.10", Registers: []register{{DataType: "INT32", Address: 65535}}},
+		{Controller: "tcp://192.168.1.10", Coils: []coil{{Address: -1}}},
+	} {
+		assert.Error(t, m.Connect(), m.Controller)
+	}
+}
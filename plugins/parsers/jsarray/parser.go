@@ -0,0 +1,221 @@
+package jsarray
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// declaration matches the start of an array declaration such as
+// `var statusPara = new Array(`.
+var declaration = regexp.MustCompile(`(?:var\s+)?([A-Za-z_$][\w$]*)\s*=\s*new\s+Array\s*\(`)
+
+// JSArrayParser extracts the `new Array(...)` variables that the web
+// interfaces of many embedded devices (routers, switches, access points)
+// embed in their HTML pages.
+type JSArrayParser struct {
+	MetricName string
+	// Variables restricts parsing to the given array names. All arrays are
+	// parsed if it is empty.
+	Variables   []string
+	DefaultTags map[string]string
+}
+
+// Parse returns a single metric with one field per array element, named
+// <variable>_<index>. Numbers become integer or float fields, quoted values
+// string fields and true/false boolean fields; empty and null elements are
+// skipped.
+func (p *JSArrayParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	arrays := FindArrays(buf)
+
+	names := p.Variables
+	if len(names) == 0 {
+		for name := range arrays {
+			names = append(names, name)
+		}
+	}
+
+	fields := make(map[string]interface{})
+	for _, name := range names {
+		elements, ok := arrays[name]
+		if !ok {
+			continue
+		}
+		for i, element := range elements {
+			if element != nil {
+				fields[name+"_"+strconv.Itoa(i)] = element
+			}
+		}
+	}
+
+	if len(fields) == 0 {
+		return []telegraf.Metric{}, nil
+	}
+
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	metric, err := telegraf.NewMetric(p.MetricName, tags, fields, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return []telegraf.Metric{metric}, nil
+}
+
+func (p *JSArrayParser) ParseLine(line string) (telegraf.Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) < 1 {
+		return nil, fmt.Errorf("can not parse the line: %s, for data format: jsarray", line)
+	}
+
+	return metrics[0], nil
+}
+
+func (p *JSArrayParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+// FindArray returns the elements of the first array assigned to the variable
+// name in buf. See FindArrays for the element types.
+func FindArray(buf []byte, name string) ([]interface{}, bool) {
+	for _, loc := range declaration.FindAllSubmatchIndex(buf, -1) {
+		if string(buf[loc[2]:loc[3]]) != name {
+			continue
+		}
+		if elements, ok := parseElements(buf[loc[1]:]); ok {
+			return elements, true
+		}
+	}
+	return nil, false
+}
+
+// FindArrays returns the elements of every array declared in buf, keyed by
+// variable name. When a variable is declared more than once, the first
+// declaration wins.
+//
+// Elements are int64, float64, string or bool values; empty elements and the
+// null and undefined literals are returned as nil so that indices are kept.
+func FindArrays(buf []byte) map[string][]interface{} {
+	arrays := make(map[string][]interface{})
+	for _, loc := range declaration.FindAllSubmatchIndex(buf, -1) {
+		name := string(buf[loc[2]:loc[3]])
+		if _, ok := arrays[name]; ok {
+			continue
+		}
+		if elements, ok := parseElements(buf[loc[1]:]); ok {
+			arrays[name] = elements
+		}
+	}
+	return arrays
+}
+
+// parseElements splits the arguments of an array constructor, starting right
+// after the opening parenthesis, into typed values. It returns false if the
+// closing parenthesis is missing.
+func parseElements(buf []byte) ([]interface{}, bool) {
+	var elements []interface{}
+	var token []byte
+	var quote byte
+	quoted := false
+	depth := 0
+
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+
+		if quote != 0 {
+			switch c {
+			case '\\':
+				if i+1 < len(buf) {
+					i++
+					token = append(token, unescape(buf[i]))
+				}
+			case quote:
+				quote = 0
+			default:
+				token = append(token, c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			// whitespace around a quoted element is not part of it
+			quote = c
+			quoted = true
+			token = token[:0]
+		case '(', '[', '{':
+			depth++
+			token = append(token, c)
+		case ')', ']', '}':
+			if depth == 0 && c == ')' {
+				// a trailing comma, which some firmwares emit before
+				// the closing parenthesis, does not add an element
+				if quoted || len(strings.TrimSpace(string(token))) > 0 {
+					elements = append(elements, convert(token, quoted))
+				}
+				return elements, true
+			}
+			depth--
+			token = append(token, c)
+		case ',':
+			if depth > 0 {
+				token = append(token, c)
+				continue
+			}
+			elements = append(elements, convert(token, quoted))
+			token = token[:0]
+			quoted = false
+		default:
+			if !quoted {
+				token = append(token, c)
+			}
+		}
+	}
+	return nil, false
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	}
+	return c
+}
+
+func convert(token []byte, quoted bool) interface{} {
+	if quoted {
+		return string(token)
+	}
+
+	s := strings.TrimSpace(string(token))
+	switch s {
+	case "", "null", "undefined":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	// anything else, such as an expression, is kept verbatim
+	return s
+}
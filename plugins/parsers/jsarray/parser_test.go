@@ -0,0 +1,137 @@
+package jsarray
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statusPage = `<HTML><HEAD>
+<SCRIPT language="javascript" type="text/javascript">
+var statusPara = new Array(
+1,
+1,
+22,
+"3.16.9 Build 150310 Rel.52074n",
+"WR740N v5 00000000",
+7140,
+0,0 );
+</SCRIPT>
+<SCRIPT language="javascript" type="text/javascript">
+var wanPara = new Array(
+4, "14-CC-20-AA-BB-CC", "10.0.0.2", 1, "255.255.255.0", 0, 0, "10.0.0.1", 1, 1, 0, "Dynamic IP", "8.8.8.8 , 8.8.4.4", "", 0, 0, "0.0.0.0", "0.0.0.0", "0.0.0.0", "0.0.0.0", 0, 0, 0, 0, 0, 0, 0, 0,
+0,0 );
+var ratio = new Array(0.5, -1.25, true, null, 'it\'s', 0x10,);
+var empty = new Array();
+</SCRIPT>
+</HEAD></HTML>`
+
+func TestFindArray(t *testing.T) {
+	elements, ok := FindArray([]byte(statusPage), "statusPara")
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{
+		int64(1), int64(1), int64(22),
+		"3.16.9 Build 150310 Rel.52074n",
+		"WR740N v5 00000000",
+		int64(7140), int64(0), int64(0),
+	}, elements)
+
+	elements, ok = FindArray([]byte(statusPage), "wanPara")
+	require.True(t, ok)
+	assert.Len(t, elements, 30)
+	assert.Equal(t, "8.8.8.8 , 8.8.4.4", elements[12])
+	assert.Equal(t, "", elements[13])
+
+	_, ok = FindArray([]byte(statusPage), "missing")
+	assert.False(t, ok)
+}
+
+func TestFindArrays(t *testing.T) {
+	arrays := FindArrays([]byte(statusPage))
+	assert.Len(t, arrays, 4)
+	assert.Equal(t, []interface{}{
+		0.5, -1.25, true, nil, "it's", int64(16),
+	}, arrays["ratio"])
+	assert.Empty(t, arrays["empty"])
+}
+
+func TestTrailingNull(t *testing.T) {
+	// an explicit null is an element, unlike a trailing comma
+	elements, ok := FindArray([]byte(`var a = new Array(1, 2, null);`), "a")
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{int64(1), int64(2), nil}, elements)
+
+	elements, ok = FindArray([]byte(`var a = new Array(1, 2, null,);`), "a")
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{int64(1), int64(2), nil}, elements)
+
+	elements, ok = FindArray([]byte(`var a = new Array(null);`), "a")
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{nil}, elements)
+}
+
+func TestUnterminatedArray(t *testing.T) {
+	_, ok := FindArray([]byte(`var a = new Array(1, 2, "x)`), "a")
+	assert.False(t, ok)
+}
+
+func TestParse(t *testing.T) {
+	parser := JSArrayParser{
+		MetricName:  "router",
+		Variables:   []string{"statusPara", "ratio"},
+		DefaultTags: map[string]string{"host": "gw"},
+	}
+	metrics, err := parser.Parse([]byte(statusPage))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	assert.Equal(t, "router", metrics[0].Name())
+	assert.Equal(t, map[string]string{"host": "gw"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"statusPara_0": int64(1),
+		"statusPara_1": int64(1),
+		"statusPara_2": int64(22),
+		"statusPara_3": "3.16.9 Build 150310 Rel.52074n",
+		"statusPara_4": "WR740N v5 00000000",
+		"statusPara_5": int64(7140),
+		"statusPara_6": int64(0),
+		"statusPara_7": int64(0),
+		"ratio_0":      0.5,
+		"ratio_1":      -1.25,
+		"ratio_2":      true,
+		"ratio_4":      "it's",
+		"ratio_5":      int64(16),
+	}, metrics[0].Fields())
+}
+
+func TestParseAllVariables(t *testing.T) {
+	parser := JSArrayParser{MetricName: "router"}
+	metrics, err := parser.Parse([]byte(statusPage))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	fields := metrics[0].Fields()
+	assert.Equal(t, int64(7140), fields["statusPara_5"])
+	assert.Equal(t, "10.0.0.2", fields["wanPara_2"])
+}
+
+func TestParseNoArrays(t *testing.T) {
+	parser := JSArrayParser{MetricName: "router"}
+	metrics, err := parser.Parse([]byte("<html></html>"))
+	require.NoError(t, err)
+	assert.Len(t, metrics, 0)
+
+	_, err = parser.ParseLine("<html></html>")
+	assert.Error(t, err)
+}
+
+func TestParseLine(t *testing.T) {
+	parser := JSArrayParser{MetricName: "router"}
+	metric, err := parser.ParseLine(`var load = new Array(12, 3.5);`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"load_0": int64(12),
+		"load_1": 3.5,
+	}, metric.Fields())
+}
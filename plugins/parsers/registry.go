@@ -7,6 +7,7 @@ import (
 
 	"github.com/influxdata/telegraf/plugins/parsers/graphite"
 	"github.com/influxdata/telegraf/plugins/parsers/influx"
+	"github.com/influxdata/telegraf/plugins/parsers/jsarray"
 	"github.com/influxdata/telegraf/plugins/parsers/json"
 	"github.com/influxdata/telegraf/plugins/parsers/nagios"
 	"github.com/influxdata/telegraf/plugins/parsers/value"
@@ -40,7 +41,7 @@ type Parser interface {
 // Config is a struct that covers the data types needed for all parser types,
 // and can be used to instantiate _any_ of the parsers.
 type Config struct {
-	// Dataformat can be one of: json, influx, graphite, value, nagios
+	// Dataformat can be one of: json, influx, graphite, value, nagios, jsarray
 	DataFormat string
 
 	// Separator only applied to Graphite data.
@@ -50,12 +51,16 @@ type Config struct {
 
 	// TagKeys only apply to JSON data
 	TagKeys []string
-	// MetricName applies to JSON & value. This will be the name of the measurement.
+	// MetricName applies to JSON, value & jsarray. This will be the name of the measurement.
 	MetricName string
 
 	// DataType only applies to value, this will be the type to parse value to
 	DataType string
 
+	// JSArrayVariables only applies to jsarray, these are the array variables
+	// to parse. All arrays are parsed if it is empty.
+	JSArrayVariables []string
+
 	// DefaultTags are the default tags that will be added to all parsed metrics.
 	DefaultTags map[string]string
 }
@@ -78,6 +83,9 @@ func NewParser(config *Config) (Parser, error) {
 	case "graphite":
 		parser, err = NewGraphiteParser(config.Separator,
 			config.Templates, config.DefaultTags)
+	case "jsarray":
+		parser, err = NewJSArrayParser(config.MetricName,
+			config.JSArrayVariables, config.DefaultTags)
 	default:
 		err = fmt.Errorf("Invalid data format: %s", config.DataFormat)
 	}
@@ -124,3 +132,15 @@ func NewValueParser(
 		DefaultTags: defaultTags,
 	}, nil
 }
+
+func NewJSArrayParser(
+	metricName string,
+	variables []string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	return &jsarray.JSArrayParser{
+		MetricName:  metricName,
+		Variables:   variables,
+		DefaultTags: defaultTags,
+	}, nil
+}
@@ -0,0 +1,218 @@
+// Package httpfixture records the responses of a live device or service into
+// fixture files and replays them in unit tests, so the inputs that scrape
+// HTTP endpoints can be tested against real responses of every firmware or
+// release they support.
+//
+// A test starts a Server for a fixture file and points the input at its URL.
+// By default the Server replays the responses stored in the file. When the
+// TELEGRAF_FIXTURE_TARGET environment variable holds the base URL of a live
+// instance, the Server proxies every request to it instead and writes the
+// responses to the fixture file when it is closed:
+//
+//   TELEGRAF_FIXTURE_TARGET=http://relay.example.com:22070 \
+//     go test ./plugins/inputs/syncthing_relay -run TestFixtures
+package httpfixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TargetEnv names the environment variable that switches servers to
+// recording mode.
+const TargetEnv = "TELEGRAF_FIXTURE_TARGET"
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Method string `json:"method"`
+	// URL is the request URI, i.e. the path and query.
+	URL    string            `json:"url"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body"`
+}
+
+// Fixture is the content of a fixture file.
+type Fixture struct {
+	// Description tells where the responses come from, e.g. the firmware
+	// version of the device.
+	Description  string        `json:"description,omitempty"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Load reads a fixture file.
+func Load(path string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse fixture %s: %s", path, err)
+	}
+	return &f, nil
+}
+
+// Save writes the fixture to a file.
+func (f *Fixture) Save(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// recordedHeaders are the response headers kept in fixtures.
+var recordedHeaders = []string{"Content-Type", "Set-Cookie", "WWW-Authenticate"}
+
+// Server replays or records a fixture.
+type Server struct {
+	*httptest.Server
+
+	path   string
+	target string
+
+	mu        sync.Mutex
+	fixture   *Fixture
+	replayed  map[int]bool
+	unmatched []string
+}
+
+// NewServer starts a Server for the fixture file at path, in recording mode
+// if TargetEnv is set and in replay mode otherwise.
+func NewServer(path string) (*Server, error) {
+	return NewServerWithTarget(path, os.Getenv(TargetEnv))
+}
+
+// NewServerWithTarget starts a Server that records the responses of target,
+// or replays the fixture file if target is empty.
+func NewServerWithTarget(path, target string) (*Server, error) {
+	s := &Server{
+		path:     path,
+		target:   strings.TrimRight(target, "/"),
+		replayed: make(map[int]bool),
+	}
+
+	if s.target != "" {
+		s.fixture = &Fixture{Description: "recorded from " + s.target}
+		s.Server = httptest.NewServer(http.HandlerFunc(s.record))
+		return s, nil
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	s.fixture = f
+	s.Server = httptest.NewServer(http.HandlerFunc(s.replay))
+	return s, nil
+}
+
+// Recording reports whether the server proxies to a live target.
+func (s *Server) Recording() bool {
+	return s.target != ""
+}
+
+// Unmatched returns the requests that had no recorded response.
+func (s *Server) Unmatched() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.unmatched...)
+}
+
+// Close shuts the server down. In recording mode, it then writes the
+// recorded responses to the fixture file.
+func (s *Server) Close() error {
+	s.Server.Close()
+	if !s.Recording() {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fixture.Save(s.path)
+}
+
+// replay answers with the first recorded response to the same method and
+// request URI that was not replayed yet, or the last one if all were. This
+// way a sequence of responses, e.g. a counter increasing between two
+// collections, is played back in order.
+func (s *Server) replay(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	match := -1
+	for i, in := range s.fixture.Interactions {
+		if in.Method != r.Method || in.URL != r.URL.RequestURI() {
+			continue
+		}
+		match = i
+		if !s.replayed[i] {
+			break
+		}
+	}
+	if match < 0 {
+		s.unmatched = append(s.unmatched, r.Method+" "+r.URL.RequestURI())
+		s.mu.Unlock()
+		http.Error(w, "no recorded response", http.StatusNotFound)
+		return
+	}
+	s.replayed[match] = true
+	in := s.fixture.Interactions[match]
+	s.mu.Unlock()
+
+	for k, v := range in.Header {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(in.Status)
+	fmt.Fprint(w, in.Body)
+}
+
+// record forwards a request to the target and stores its response.
+func (s *Server) record(w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequest(r.Method, s.target+r.URL.RequestURI(), r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	in := Interaction{
+		Method: r.Method,
+		URL:    r.URL.RequestURI(),
+		Status: resp.StatusCode,
+		Header: make(map[string]string),
+		Body:   string(body),
+	}
+	for _, k := range recordedHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			in.Header[k] = v
+			w.Header().Set(k, v)
+		}
+	}
+
+	s.mu.Lock()
+	s.fixture.Interactions = append(s.fixture.Interactions, in)
+	s.mu.Unlock()
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
@@ -0,0 +1,86 @@
+package httpfixture
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(b)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpfixture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	hits := 0
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"count":%d}`, hits)
+	}))
+	defer live.Close()
+
+	rec, err := NewServerWithTarget(path, live.URL)
+	require.NoError(t, err)
+	assert.True(t, rec.Recording())
+	status, body := get(t, rec.URL+"/status?verbose=1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"count":1}`, body)
+	get(t, rec.URL+"/status?verbose=1")
+	status, _ = get(t, rec.URL+"/missing")
+	assert.Equal(t, http.StatusNotFound, status)
+	require.NoError(t, rec.Close())
+
+	f, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, f.Interactions, 3)
+	assert.Equal(t, "/status?verbose=1", f.Interactions[0].URL)
+	assert.Equal(t, "application/json", f.Interactions[0].Header["Content-Type"])
+
+	// responses are replayed in order, the last one repeatedly
+	play, err := NewServerWithTarget(path, "")
+	require.NoError(t, err)
+	defer play.Close()
+	assert.False(t, play.Recording())
+
+	_, body = get(t, play.URL+"/status?verbose=1")
+	assert.Equal(t, `{"count":1}`, body)
+	_, body = get(t, play.URL+"/status?verbose=1")
+	assert.Equal(t, `{"count":2}`, body)
+	_, body = get(t, play.URL+"/status?verbose=1")
+	assert.Equal(t, `{"count":2}`, body)
+	assert.Equal(t, 2, hits)
+
+	status, _ = get(t, play.URL+"/missing")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Empty(t, play.Unmatched())
+
+	status, _ = get(t, play.URL+"/status")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, []string{"GET /status"}, play.Unmatched())
+}
+
+func TestMissingFixture(t *testing.T) {
+	_, err := NewServerWithTarget("/nonexistent/fixture.json", "")
+	assert.Error(t, err)
+}